@@ -4,10 +4,16 @@ set -e
 
 KAFKA=bash /usr/bin/kafka-topics
 
+# kline.raw sembole göre partition’lanır; calc-service replikaları
+# partition’ları aralarında paylaşır (replika sayısından az olmamalı)
+KLINE_PARTITIONS=${KLINE_PARTITIONS:-6}
+# SHARDING=kafka ile çalışan kline-fetcher’larda partition sayısı = grup sayısı
+FETCHER_GROUPS=${FETCHER_GROUPS:-4}
+
 # Eğer yoksa yarat
 /usr/bin/kafka-topics --bootstrap-server kafka:9092 \
   --create --if-not-exists --topic kline.raw \
-  --partitions 1 --replication-factor 1
+  --partitions "$KLINE_PARTITIONS" --replication-factor 1
 
 /usr/bin/kafka-topics --bootstrap-server kafka:9092 \
   --create --if-not-exists --topic analysis.request \
@@ -17,6 +23,24 @@ KAFKA=bash /usr/bin/kafka-topics
   --create --if-not-exists --topic alert.trigger \
   --partitions 1 --replication-factor 1
 
+/usr/bin/kafka-topics --bootstrap-server kafka:9092 \
+  --create --if-not-exists --topic symbol.events \
+  --partitions 1 --replication-factor 1
+
+/usr/bin/kafka-topics --bootstrap-server kafka:9092 \
+  --create --if-not-exists --topic data.quality \
+  --partitions 1 --replication-factor 1
+
+# api-gateway son sıralamaları baştan okuyarak kurar; bir günlük
+# retention yeterli
+/usr/bin/kafka-topics --bootstrap-server kafka:9092 \
+  --create --if-not-exists --topic screener.snapshot \
+  --partitions 1 --replication-factor 1 --config retention.ms=86400000
+
+/usr/bin/kafka-topics --bootstrap-server kafka:9092 \
+  --create --if-not-exists --topic kline-fetcher.groups \
+  --partitions "$FETCHER_GROUPS" --replication-factor 1
+
 /usr/bin/kafka-topics --bootstrap-server kafka:9092 \
   --create --if-not-exists --topic indicator.calc \
   --partitions 1 --replication-factor 1
@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/segmentio/kafka-go"
 
+	"github.com/ae144de/sonarbot-service-infra2/services/api-gateway/pkg/alerts"
 	"github.com/ae144de/sonarbot-service-infra2/services/api-gateway/pkg/handler"
+	"github.com/ae144de/sonarbot-service-infra2/services/api-gateway/pkg/registry"
+	"github.com/ae144de/sonarbot-service-infra2/services/api-gateway/pkg/screeners"
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/auth"
 )
 
 func main() {
@@ -18,26 +24,75 @@ func main() {
 	if port == "" {
 		port = "8080"
 	}
+	alertTopic := os.Getenv("ALERT_TRIGGER_TOPIC")
+	if alertTopic == "" {
+		alertTopic = "alert.trigger"
+	}
+	screenerTopic := os.Getenv("SCREENER_SNAPSHOT_TOPIC")
+	if screenerTopic == "" {
+		screenerTopic = "screener.snapshot"
+	}
+	// Her alert aboneliği alert.trigger’ın her partition’ı için ayrı bir
+	// broker bağlantısı açar; aynı anda en fazla bu kadar abone kabul edilir
+	maxAlertSubscribers, err := strconv.Atoi(os.Getenv("ALERT_STREAM_MAX_SUBSCRIBERS"))
+	if err != nil || maxAlertSubscribers <= 0 {
+		maxAlertSubscribers = 100
+	}
+	calcAddr := os.Getenv("CALC_SERVICE_ADDR")
+	if calcAddr == "" {
+		calcAddr = "http://calc-service:8080"
+	}
+
+	signingKey := os.Getenv("JWT_SIGNING_KEY")
 
 	if broker == "" || topic == "" {
 		log.Fatal("KAFKA_ADDR and ANALYSIS_REQUEST_TOPIC must be set")
 	}
+	if err := auth.CheckSigningKey([]byte(signingKey)); err != nil {
+		log.Fatalf("JWT_SIGNING_KEY: %v", err)
+	}
 
-	// 2) Kafka Writer
+	// 2) Kafka Writer: komutlar job ID’siyle key’lenir; Hash balancer bir
+	// job’un tüm komutlarını aynı partition’a, yani sıralı tutar
 	writer := kafka.NewWriter(kafka.WriterConfig{
-		Brokers: []string{broker},
-		Topic:   topic,
+		Brokers:  []string{broker},
+		Topic:    topic,
+		Balancer: &kafka.Hash{},
 	})
 	defer writer.Close()
 
-	// 3) Gin Engine
+	// 3) Job registry: analysis.request’i baştan okuyarak job listesini kurar
+	reg := registry.New()
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     []string{broker},
+		Topic:       topic,
+		StartOffset: kafka.FirstOffset,
+	})
+	defer reader.Close()
+	go reg.Run(context.Background(), reader)
+
+	// 4) Alert stream’i: her client alert.trigger’ı kendi cursor’ından okur
+	alertStream := alerts.NewStream([]string{broker}, alertTopic, maxAlertSubscribers)
+
+	// 5) Screener sıralamaları: screener.snapshot’ı baştan okuyarak her
+	// job’ın son sıralamasını kurar
+	board := screeners.New()
+	screenerReader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     []string{broker},
+		Topic:       screenerTopic,
+		StartOffset: kafka.FirstOffset,
+	})
+	defer screenerReader.Close()
+	go board.Run(context.Background(), screenerReader)
+
+	// 6) Gin Engine
 	r := gin.New()
 	r.Use(gin.Logger(), gin.Recovery())
 
-	// 4) Register routes
-	handler.RegisterRoutes(r, writer, topic)
+	// 7) Register routes
+	handler.RegisterRoutes(r, writer, topic, reg, alertStream, board, calcAddr, []byte(signingKey))
 
-	// 5) Start server
+	// 8) Start server
 	addr := ":" + port
 	log.Printf("API Gateway listening on %s", addr)
 	if err := r.Run(addr); err != nil {
@@ -0,0 +1,251 @@
+// Package alerts streams the alerts calc-service publishes to alert.trigger
+// to gateway clients.
+package alerts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/events"
+)
+
+// Cursor is the offset of the next message to read on each partition of
+// alert.trigger. Its string form, "partition:offset,...", is what clients
+// resume from.
+type Cursor map[int]int64
+
+// ParseCursor parses the string form of a Cursor; the empty string is the
+// empty cursor.
+func ParseCursor(s string) (Cursor, error) {
+	c := make(Cursor)
+	if strings.TrimSpace(s) == "" {
+		return c, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		p, o, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid cursor %q", s)
+		}
+		partition, err := strconv.Atoi(p)
+		if err != nil || partition < 0 {
+			return nil, fmt.Errorf("invalid cursor %q", s)
+		}
+		offset, err := strconv.ParseInt(o, 10, 64)
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf("invalid cursor %q", s)
+		}
+		c[partition] = offset
+	}
+	return c, nil
+}
+
+func (c Cursor) String() string {
+	partitions := make([]int, 0, len(c))
+	for p := range c {
+		partitions = append(partitions, p)
+	}
+	sort.Ints(partitions)
+	parts := make([]string, len(partitions))
+	for i, p := range partitions {
+		parts[i] = strconv.Itoa(p) + ":" + strconv.FormatInt(c[p], 10)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (c Cursor) clone() Cursor {
+	out := make(Cursor, len(c))
+	for p, o := range c {
+		out[p] = o
+	}
+	return out
+}
+
+// Filter selects the alerts a client receives. Empty fields match every
+// alert.
+type Filter struct {
+	JobIDs  []string
+	Symbols []string
+	Owner   string // the authenticated user; only their jobs' alerts pass
+}
+
+// Match reports whether the alert passes the filter.
+func (f Filter) Match(evt events.AlertEvent) bool {
+	if f.Owner != "" && evt.Owner != f.Owner {
+		return false
+	}
+	if len(f.JobIDs) > 0 && !slices.Contains(f.JobIDs, evt.JobID) {
+		return false
+	}
+	if len(f.Symbols) > 0 && !slices.ContainsFunc(f.Symbols, func(s string) bool {
+		return strings.EqualFold(s, evt.Symbol)
+	}) {
+		return false
+	}
+	return true
+}
+
+// Alert is an alert delivered to a client, with the cursor to resume
+// after it.
+type Alert struct {
+	Event  events.AlertEvent
+	Cursor Cursor
+}
+
+// ErrTooManySubscribers is returned by Acquire when every subscriber slot
+// is taken.
+var ErrTooManySubscribers = errors.New("too many alert subscribers")
+
+// Stream reads alert.trigger for gateway clients. Every subscriber reads
+// the partitions from its own cursor, so a reconnecting client picks up
+// where it left off without the gateway buffering alerts. That costs a
+// broker connection per subscriber and partition, so the number of
+// subscribers is capped.
+type Stream struct {
+	brokers []string
+	topic   string
+	slots   chan struct{} // one per subscriber
+}
+
+// NewStream returns a Stream reading topic from the given brokers for at
+// most maxSubscribers subscribers at a time.
+func NewStream(brokers []string, topic string, maxSubscribers int) *Stream {
+	return &Stream{brokers: brokers, topic: topic, slots: make(chan struct{}, maxSubscribers)}
+}
+
+// Acquire takes a subscriber slot, or returns ErrTooManySubscribers if none
+// is free. Callers take one before Subscribe and release it once Subscribe
+// returns; it is separate so they can refuse the client before answering.
+func (s *Stream) Acquire() (release func(), err error) {
+	select {
+	case s.slots <- struct{}{}:
+		return func() { <-s.slots }, nil
+	default:
+		return nil, ErrTooManySubscribers
+	}
+}
+
+// Subscribe calls fn with every alert matching f from the cursor on, until
+// ctx is done or fn returns an error. Partitions the cursor doesn't name
+// start at their latest offset, and offsets that have left retention at
+// the oldest one kept. ready is called first with the resolved cursor, so
+// clients that see no alert before disconnecting can still resume.
+func (s *Stream) Subscribe(ctx context.Context, from Cursor, f Filter, ready func(Cursor) error, fn func(Alert) error) error {
+	cursor, err := s.resolve(ctx, from)
+	if err != nil {
+		return err
+	}
+	if err := ready(cursor.clone()); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	msgs := make(chan kafka.Message)
+	errs := make(chan error, len(cursor))
+	for partition, offset := range cursor {
+		r := kafka.NewReader(kafka.ReaderConfig{
+			Brokers:   s.brokers,
+			Topic:     s.topic,
+			Partition: partition,
+			MaxWait:   time.Second,
+		})
+		if err := r.SetOffset(offset); err != nil {
+			r.Close()
+			return err
+		}
+		go func() {
+			defer r.Close()
+			for {
+				m, err := r.ReadMessage(ctx)
+				if err != nil {
+					if ctx.Err() == nil {
+						errs <- err
+					}
+					return
+				}
+				select {
+				case msgs <- m:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errs:
+			return err
+		case m := <-msgs:
+			cursor[m.Partition] = m.Offset + 1
+			evt, err := events.DecodeAlert(m.Value)
+			if err != nil {
+				log.Printf("[alerts] skipping invalid alert at %d:%d: %v", m.Partition, m.Offset, err)
+				continue
+			}
+			if !f.Match(evt) {
+				continue
+			}
+			if err := fn(Alert{Event: evt, Cursor: cursor.clone()}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// resolve returns the offset to start each partition of the topic at.
+func (s *Stream) resolve(ctx context.Context, from Cursor) (Cursor, error) {
+	conn, err := kafka.Dial("tcp", s.brokers[0])
+	if err != nil {
+		return nil, err
+	}
+	parts, err := conn.ReadPartitions(s.topic)
+	conn.Close()
+	if err != nil {
+		return nil, err
+	}
+	cursor := make(Cursor, len(parts))
+	for _, p := range parts {
+		first, last, err := s.offsets(ctx, p.ID)
+		if err != nil {
+			return nil, err
+		}
+		offset, ok := from[p.ID]
+		switch {
+		case !ok || offset > last:
+			offset = last
+		case offset < first:
+			offset = first
+		}
+		cursor[p.ID] = offset
+	}
+	return cursor, nil
+}
+
+// offsets returns the oldest offset kept on a partition and the offset the
+// next message will get.
+func (s *Stream) offsets(ctx context.Context, partition int) (first, last int64, err error) {
+	conn, err := kafka.DialLeader(ctx, "tcp", s.brokers[0], s.topic, partition)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
+	if first, err = conn.ReadFirstOffset(); err != nil {
+		return 0, 0, err
+	}
+	if last, err = conn.ReadLastOffset(); err != nil {
+		return 0, 0, err
+	}
+	return first, last, nil
+}
@@ -0,0 +1,234 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"github.com/ae144de/sonarbot-service-infra2/services/api-gateway/pkg/alerts"
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/events"
+)
+
+const (
+	alertKeepalive = 15 * time.Second
+	alertWriteWait = 10 * time.Second
+)
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// alertMessage WebSocket client’larına gönderilen mesaj. Type "ready"
+// (abonelik başladı), "alert" ya da "error"; Cursor bir sonraki bağlantıda
+// ?cursor= ile verilirse akış kaldığı yerden devam eder.
+type alertMessage struct {
+	Type   string             `json:"type"`
+	Cursor string             `json:"cursor,omitempty"`
+	Alert  *events.AlertEvent `json:"alert,omitempty"`
+	Error  string             `json:"error,omitempty"`
+}
+
+// streamAlertsSSE alert.trigger’ı Server-Sent Events olarak yayınlar. Her
+// event’in ID’si cursor’dır; EventSource yeniden bağlanırken Last-Event-ID
+// ile gönderir ve kaçan alert’ler de iletilir.
+func (h *Handler) streamAlertsSSE(c *gin.Context) {
+	cursor, ok := alertCursor(c)
+	if !ok {
+		return
+	}
+	filter := alertFilter(c)
+	release, ok := h.acquireAlertSlot(c)
+	if !ok {
+		return
+	}
+	defer release()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	var mu sync.Mutex // keepalive ve alert yazmaları
+	write := func(format string, args ...interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if _, err := fmt.Fprintf(c.Writer, format, args...); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	}
+	go func() {
+		ticker := time.NewTicker(alertKeepalive)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if write(": ping\n\n") != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	err := h.alertStream.Subscribe(ctx, cursor, filter,
+		func(cur alerts.Cursor) error {
+			return write("id: %s\nevent: ready\ndata: {}\n\n", cur)
+		},
+		func(a alerts.Alert) error {
+			b, err := json.Marshal(a.Event)
+			if err != nil {
+				return err
+			}
+			return write("id: %s\nevent: alert\ndata: %s\n\n", a.Cursor, b)
+		})
+	if err != nil && ctx.Err() == nil {
+		log.Printf("[alerts] SSE stream error: %v", err)
+		b, _ := json.Marshal(gin.H{"error": err.Error()})
+		write("event: error\ndata: %s\n\n", b)
+	}
+}
+
+// streamAlertsWS alert.trigger’ı WebSocket üzerinden alertMessage olarak
+// yayınlar; client’ın gönderdiği mesajlar yok sayılır.
+func (h *Handler) streamAlertsWS(c *gin.Context) {
+	cursor, ok := alertCursor(c)
+	if !ok {
+		return
+	}
+	filter := alertFilter(c)
+	release, ok := h.acquireAlertSlot(c)
+	if !ok {
+		return
+	}
+	defer release()
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgrade hata cevabını kendisi yazar
+		log.Printf("[alerts] websocket upgrade error: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	// Okuma döngüsü: client’ın kapatmasını ve pong’ları yakalar
+	extend := func() { conn.SetReadDeadline(time.Now().Add(2 * alertKeepalive)) }
+	extend()
+	conn.SetPongHandler(func(string) error {
+		extend()
+		return nil
+	})
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		ticker := time.NewTicker(alertKeepalive)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(alertWriteWait)) != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	var mu sync.Mutex
+	send := func(msg alertMessage) error {
+		mu.Lock()
+		defer mu.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(alertWriteWait))
+		return conn.WriteJSON(msg)
+	}
+	err = h.alertStream.Subscribe(ctx, cursor, filter,
+		func(cur alerts.Cursor) error {
+			return send(alertMessage{Type: "ready", Cursor: cur.String()})
+		},
+		func(a alerts.Alert) error {
+			return send(alertMessage{Type: "alert", Cursor: a.Cursor.String(), Alert: &a.Event})
+		})
+	if err != nil && ctx.Err() == nil {
+		log.Printf("[alerts] websocket stream error: %v", err)
+		send(alertMessage{Type: "error", Error: err.Error()})
+	}
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(alertWriteWait))
+}
+
+// acquireAlertSlot akış için bir abone yeri alır. Hepsi doluysa 503 döner
+// ve false verir; client Retry-After kadar bekleyip yeniden bağlanabilir.
+func (h *Handler) acquireAlertSlot(c *gin.Context) (func(), bool) {
+	release, err := h.alertStream.Acquire()
+	if err != nil {
+		c.Header("Retry-After", "30")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return release, true
+}
+
+// alertFilter jobId ve symbol query parametrelerinden (tekrar eden ya da
+// virgülle ayrılmış) filtreyi kurar. Kimliği doğrulanmış kullanıcı sadece
+// kendi job’larının alert’lerini alır.
+func alertFilter(c *gin.Context) alerts.Filter {
+	return alerts.Filter{
+		JobIDs:  queryList(c, "jobId"),
+		Symbols: queryList(c, "symbol"),
+		Owner:   c.GetString(userKey),
+	}
+}
+
+// alertCursor akışın başlayacağı cursor’ı döner: EventSource’un yeniden
+// bağlanırken gönderdiği Last-Event-ID ya da cursor query parametresi.
+// Geçersizse 400 döner ve false verir.
+func alertCursor(c *gin.Context) (alerts.Cursor, bool) {
+	s := c.GetHeader("Last-Event-ID")
+	if s == "" {
+		s = c.Query("cursor")
+	}
+	cursor, err := alerts.ParseCursor(s)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return cursor, true
+}
+
+// queryList tekrar eden ya da virgülle ayrılmış query parametresini toplar.
+func queryList(c *gin.Context, key string) []string {
+	var out []string
+	for _, v := range c.QueryArray(key) {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/ae144de/sonarbot-service-infra2/services/api-gateway/pkg/registry"
+)
+
+func (h *Handler) listAnalyses(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"analyses": h.registry.List(c.GetString(userKey))})
+}
+
+func (h *Handler) getAnalysis(c *gin.Context) {
+	job, ok := h.ownedJob(c)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "analysis not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+func (h *Handler) updateAnalysis(c *gin.Context) {
+	id := c.Param("id")
+	if _, ok := h.ownedJob(c); !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "analysis not found"})
+		return
+	}
+	req, ok := h.bindAnalysisRequest(c)
+	if !ok {
+		return
+	}
+	if !h.publishCommand(c, registry.CommandUpdate, id, &req) {
+		return
+	}
+	log.Printf("[updateAnalysis] published update for job %s", id)
+	c.JSON(http.StatusAccepted, gin.H{"status": "updating", "jobId": id})
+}
+
+func (h *Handler) deleteAnalysis(c *gin.Context) {
+	id := c.Param("id")
+	if _, ok := h.ownedJob(c); !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "analysis not found"})
+		return
+	}
+	if !h.publishCommand(c, registry.CommandDelete, id, nil) {
+		return
+	}
+	log.Printf("[deleteAnalysis] published delete for job %s", id)
+	c.JSON(http.StatusAccepted, gin.H{"status": "deleting", "jobId": id})
+}
+
+// ownedJob :id job’ını, isteği yapan kullanıcınınsa döner. Başka
+// kullanıcının job’ı varlığı sızmasın diye yokmuş gibi görünür.
+func (h *Handler) ownedJob(c *gin.Context) (registry.Job, bool) {
+	job, ok := h.registry.Get(c.Param("id"))
+	if !ok || job.Owner == "" || job.Owner != c.GetString(userKey) {
+		return registry.Job{}, false
+	}
+	return job, true
+}
+
+// publishCommand komutu job ID key’i ile analysis.request’e yazar ve
+// registry’ye hemen uygular. Hata durumunda 500 döner ve false verir.
+func (h *Handler) publishCommand(c *gin.Context, typ, id string, req *AnalysisRequest) bool {
+	cmd := registry.Command{Type: typ, JobID: id}
+	if req != nil {
+		cmd.Request, _ = json.Marshal(req)
+	}
+	payload, _ := json.Marshal(cmd)
+	msg := kafka.Message{Key: []byte(id), Value: payload}
+	if err := h.writer.WriteMessages(context.Background(), msg); err != nil {
+		log.Printf("[publishCommand] kafka publish error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "publish failed"})
+		return false
+	}
+	h.registry.Apply(cmd, time.Now())
+	return true
+}
+
+// newJobID rastgele 16 byte’lık hex bir job ID üretir.
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
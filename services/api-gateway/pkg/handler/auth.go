@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/auth"
+)
+
+// userKey kimliği doğrulanmış kullanıcının ID’sinin gin context’indeki key’i.
+const userKey = "userID"
+
+// authenticate auth-service’in verdiği JWT’yi Authorization: Bearer
+// header’ından doğrular ve kullanıcı ID’sini (sub) userKey’e yazar.
+// queryToken true ise token ?access_token= ile de verilebilir; tarayıcıda
+// EventSource ve WebSocket header gönderemediği için sadece alert
+// akışlarında açılır.
+func authenticate(key []byte, queryToken bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := bearerToken(c.GetHeader("Authorization"))
+		if token == "" && queryToken {
+			token = c.Query("access_token")
+		}
+		if token == "" {
+			unauthorized(c, "missing bearer token")
+			return
+		}
+		claims, err := auth.Verify(key, token)
+		if err != nil {
+			if errors.Is(err, auth.ErrExpiredToken) {
+				unauthorized(c, "token expired")
+				return
+			}
+			log.Printf("[auth] rejected token: %v", err)
+			unauthorized(c, "invalid token")
+			return
+		}
+		c.Set(userKey, claims.Subject)
+		c.Next()
+	}
+}
+
+// bearerToken "Bearer <token>" header’ından token’ı döner.
+func bearerToken(header string) string {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+func unauthorized(c *gin.Context, msg string) {
+	c.Header("WWW-Authenticate", `Bearer realm="api-gateway"`)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": msg})
+}
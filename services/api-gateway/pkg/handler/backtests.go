@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BacktestRequest, bir AnalysisRequest’i [From, To) aralığındaki geçmiş
+// kline’lar üzerinde çalıştırır. Horizons ileri getiri süreleri, örn. "4h".
+type BacktestRequest struct {
+	AnalysisRequest
+	From     time.Time `json:"from" binding:"required"`
+	To       time.Time `json:"to" binding:"required"`
+	Horizons []string  `json:"horizons,omitempty"`
+}
+
+func (h *Handler) createBacktest(c *gin.Context) {
+	// 1) JSON bind & validation
+	var req BacktestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("[createBacktest] bind error: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !h.checkAnalysisRequest(c, &req.AnalysisRequest) {
+		return
+	}
+
+	// 2) calc-service’e ilet; backtest senkron çalışır
+	body, err := json.Marshal(req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not encode request"})
+		return
+	}
+	httpReq, err := http.NewRequestWithContext(c.Request.Context(), http.MethodPost, h.calcAddr+"/backtests", bytes.NewReader(body))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := h.calcClient.Do(httpReq)
+	if err != nil {
+		log.Printf("[createBacktest] calc-service error: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "calc-service unavailable"})
+		return
+	}
+	defer resp.Body.Close()
+
+	// 3) Cevabı olduğu gibi client’a aktar
+	out, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "could not read calc-service response"})
+		return
+	}
+	c.Data(resp.StatusCode, "application/json", out)
+}
@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getCatalog calc-service’in desteklediği indikatörleri, parametre
+// şemalarını, operatörleri, borsaları ve interval’ları döner. Katalog
+// job’ları çalıştıran koddan üretildiği için cevap olduğu gibi aktarılır.
+func (h *Handler) getCatalog(c *gin.Context) {
+	httpReq, err := http.NewRequestWithContext(c.Request.Context(), http.MethodGet, h.calcAddr+"/catalog", nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	resp, err := h.calcClient.Do(httpReq)
+	if err != nil {
+		log.Printf("[getCatalog] calc-service error: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "calc-service unavailable"})
+		return
+	}
+	defer resp.Body.Close()
+
+	out, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "could not read calc-service response"})
+		return
+	}
+	c.Data(resp.StatusCode, "application/json", out)
+}
@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// evaluateTimeout calc-service’in bir değerlendirmeye verdiği en uzun süre
+// (1 dakika) artı doğrulama payı.
+const evaluateTimeout = 90 * time.Second
+
+// EvaluateRequest bir AnalysisRequest’i job kaydetmeden, şu anki
+// pencereler üzerinde Symbols’ün her biri için bir kez çalıştırır; Symbols
+// boşsa websocketKlineOptions.symbol kullanılır. Timeout Go süre formatında,
+// örn. "5s" (varsayılan 10s, en fazla 1m).
+type EvaluateRequest struct {
+	AnalysisRequest
+	Symbols []string `json:"symbols,omitempty"`
+	Timeout string   `json:"timeout,omitempty"`
+}
+
+// evaluateNow isteği calc-service’e iletir ve sembol başına indikatör
+// değerlerini ve koşul sonuçlarını döner. Süresinde değerlendirilemeyen
+// semboller cevapta hatalarıyla yer alır.
+func (h *Handler) evaluateNow(c *gin.Context) {
+	// 1) JSON bind & validation; gerisi calc-service’te
+	var req EvaluateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("[evaluateNow] bind error: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errs := thresholdErrors(&req.AnalysisRequest); len(errs) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"errors": errs})
+		return
+	}
+
+	// 2) calc-service’e ilet; değerlendirme senkron çalışır
+	body, err := json.Marshal(req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not encode request"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), evaluateTimeout)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, h.calcAddr+"/evaluate", bytes.NewReader(body))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := h.calcClient.Do(httpReq)
+	if err != nil {
+		log.Printf("[evaluateNow] calc-service error: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "calc-service unavailable"})
+		return
+	}
+	defer resp.Body.Close()
+
+	// 3) Cevabı olduğu gibi client’a aktar
+	out, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "could not read calc-service response"})
+		return
+	}
+	c.Data(resp.StatusCode, "application/json", out)
+}
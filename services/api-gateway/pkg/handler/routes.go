@@ -1,45 +1,144 @@
 package handler
 
 import (
-	"context"
-	"encoding/json"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/segmentio/kafka-go"
+
+	"github.com/ae144de/sonarbot-service-infra2/services/api-gateway/pkg/alerts"
+	"github.com/ae144de/sonarbot-service-infra2/services/api-gateway/pkg/registry"
+	"github.com/ae144de/sonarbot-service-infra2/services/api-gateway/pkg/screeners"
 )
 
-// AnalysisRequest modeli
+// AnalysisRequest modeli. Alanlar burada değil validateAnalysisRequest’te
+// doğrulanır; threshold pointer’dır ki 0 geçerli bir değer olsun ama hiç
+// verilmemesi yakalansın.
 type AnalysisRequest struct {
 	WebsocketKlineOptions struct {
-		Symbol   string `json:"symbol" binding:"required"`
-		Interval string `json:"interval" binding:"required"`
-		Exchange string `json:"exchange" binding:"required"`
+		Symbol   string `json:"symbol"`
+		Interval string `json:"interval"`
+		Exchange string `json:"exchange"`
+		// Market: spot, usdm (USDⓈ-M futures, varsayılan) ya da coinm
+		Market string `json:"market,omitempty"`
 	} `json:"websocketKlineOptions"`
 	Indicators []struct {
-		Indicator  string                 `json:"indicator" binding:"required"`
+		Indicator  string                 `json:"indicator"`
 		Parameters map[string]interface{} `json:"parameters"`
-		Operator   string                 `json:"operator" binding:"required"`
-		Threshold  float64                `json:"threshold" binding:"required"`
-	} `json:"indicators" binding:"required,dive"`
+		Operator   string                 `json:"operator"`
+		Threshold  *float64               `json:"threshold"`
+	} `json:"indicators"`
+	Conditions *Condition   `json:"conditions,omitempty"`
+	Alert      *AlertPolicy `json:"alert,omitempty"`
+	Screener   *Screener    `json:"screener,omitempty"`
+	// Owner job’ı oluşturan kullanıcı; token’dan doldurulur, client’ın
+	// gönderdiği değer yok sayılır.
+	Owner string `json:"owner,omitempty"`
+}
+
+// AlertPolicy koşullar sağlandığında alert’in ne zaman gönderileceğini
+// belirler. Mode: ONCE (varsayılan; koşul bozulunca yeniden kurulur) ya da
+// EVERY. Evaluate: INTRABAR (varsayılan) ya da CLOSE (sadece mum
+// kapanışında). Cooldown ve Period Go süre formatında, örn. "15m".
+type AlertPolicy struct {
+	Mode      string `json:"mode,omitempty"`
+	Cooldown  string `json:"cooldown,omitempty"`
+	MaxAlerts int    `json:"maxAlerts,omitempty"`
+	Period    string `json:"period,omitempty"`
+	Evaluate  string `json:"evaluate,omitempty"`
+}
+
+// Screener job’ı sembol başına alert yerine her mum kapanışında sembolleri
+// Rank’e göre sıralar ve ilk Limit’i (varsayılan 10, en fazla 100) tek bir
+// snapshot olarak yayınlar. Order: DESC (varsayılan) ya da ASC. Indicators
+// ve Conditions verilirse sadece onları sağlayan semboller sıralanır.
+type Screener struct {
+	Rank  Operand `json:"rank"`
+	Order string  `json:"order,omitempty"`
+	Limit int     `json:"limit,omitempty"`
+}
+
+// Condition, AND/OR/NOT gruplarından oluşan koşul ağacının bir düğümü.
+// Type boşsa düğüm Left ile Right'ı Operator ile karşılaştırır; Left/Right
+// verilmezse Indicator/Parameters ve Threshold kullanılır.
+type Condition struct {
+	Type       string                 `json:"type,omitempty"`
+	Conditions []Condition            `json:"conditions,omitempty"`
+	Left       *Operand               `json:"left,omitempty"`
+	Right      *Operand               `json:"right,omitempty"`
+	Indicator  string                 `json:"indicator,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	Operator   string                 `json:"operator,omitempty"`
+	Threshold  *float64               `json:"threshold,omitempty"`
 }
 
-// Handler tutacağı Kafka writer ve topic
+// Operand karşılaştırmanın bir tarafı: sabit değer, ham kline alanı
+// (open/high/low/close/volume) ya da kendi parametreleriyle bir indikatör.
+type Operand struct {
+	Value      *float64               `json:"value,omitempty"`
+	Field      string                 `json:"field,omitempty"`
+	Indicator  string                 `json:"indicator,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// Handler tutacağı Kafka writer, topic, job registry, alert stream’i,
+// screener sıralamaları ve calc-service adresi
 type Handler struct {
-	writer *kafka.Writer
-	topic  string
+	writer      *kafka.Writer
+	topic       string
+	registry    *registry.Registry
+	alertStream *alerts.Stream
+	screeners   *screeners.Board
+	calcAddr    string
+	calcClient  *http.Client
 }
 
-// RegisterRoutes Gin router’ına endpoint’leri ekler
-func RegisterRoutes(r *gin.Engine, writer *kafka.Writer, topic string) {
-	h := &Handler{writer: writer, topic: topic}
+// RegisterRoutes Gin router’ına endpoint’leri ekler. healthz ve catalog
+// dışındaki endpoint’ler signingKey ile imzalanmış bir JWT ister.
+func RegisterRoutes(r *gin.Engine, writer *kafka.Writer, topic string, reg *registry.Registry, alertStream *alerts.Stream, board *screeners.Board, calcAddr string, signingKey []byte) {
+	h := &Handler{
+		writer:      writer,
+		topic:       topic,
+		registry:    reg,
+		alertStream: alertStream,
+		screeners:   board,
+		calcAddr:    calcAddr,
+		calcClient:  &http.Client{Timeout: 3 * time.Minute},
+	}
 
 	// Healthz
 	r.GET("/healthz", h.healthz)
 
+	// İndikatör ve operatör kataloğu (calc-service’e proxy)
+	r.GET("/catalog", h.getCatalog)
+
+	api := r.Group("/", authenticate(signingKey, false))
+
 	// StreamAnalysis
-	r.POST("/streamanalysis", h.streamAnalysis)
+	api.POST("/streamanalysis", h.streamAnalysis)
+
+	// Analysis job lifecycle
+	api.GET("/analyses", h.listAnalyses)
+	api.GET("/analyses/:id", h.getAnalysis)
+	api.PUT("/analyses/:id", h.updateAnalysis)
+	api.DELETE("/analyses/:id", h.deleteAnalysis)
+
+	// Backtest (calc-service’e proxy)
+	api.POST("/backtests", h.createBacktest)
+
+	// Job kaydetmeden anlık değerlendirme (calc-service’e proxy)
+	api.POST("/evaluate", h.evaluateNow)
+
+	// Alert akışı: SSE ve WebSocket, ?jobId=&symbol=&cursor= ile. Token
+	// ?access_token= ile de verilebilir.
+	stream := r.Group("/alerts", authenticate(signingKey, true))
+	stream.GET("/stream", h.streamAlertsSSE)
+	stream.GET("/ws", h.streamAlertsWS)
+
+	// Screener job’larının son sıralaması
+	api.GET("/screeners/:id/latest", h.getScreenerLatest)
 }
 
 func (h *Handler) healthz(c *gin.Context) {
@@ -48,24 +147,38 @@ func (h *Handler) healthz(c *gin.Context) {
 
 func (h *Handler) streamAnalysis(c *gin.Context) {
 	// 1) JSON bind & validation
-	var req AnalysisRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		log.Printf("[streamAnalysis] bind error: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	req, ok := h.bindAnalysisRequest(c)
+	if !ok {
 		return
 	}
 	log.Printf("[streamAnalysis] received: %+v", req)
 
-	// 2) Marshal ve Kafka’ya publish
-	payload, _ := json.Marshal(req)
-	msg := kafka.Message{Value: payload}
-	if err := h.writer.WriteMessages(context.Background(), msg); err != nil {
-		log.Printf("[streamAnalysis] kafka publish error: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "publish failed"})
+	// 2) Job ID üret ve create komutunu Kafka’ya publish et
+	id, err := newJobID()
+	if err != nil {
+		log.Printf("[streamAnalysis] job id error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not create job id"})
+		return
+	}
+	if !h.publishCommand(c, registry.CommandCreate, id, &req) {
 		return
 	}
-	log.Printf("[streamAnalysis] published to topic %s", h.topic)
+	log.Printf("[streamAnalysis] published job %s to topic %s", id, h.topic)
 
 	// 3) Client’a cevap
-	c.JSON(http.StatusAccepted, gin.H{"status": "processing"})
+	c.JSON(http.StatusAccepted, gin.H{"status": "processing", "jobId": id})
+}
+
+// bindAnalysisRequest body’yi AnalysisRequest’e bind eder, owner’ı
+// kullanıcıya ayarlar ve doğrular;
+// bozuk JSON’da 400, geçersiz alanlarda alan listesiyle 422 döner.
+func (h *Handler) bindAnalysisRequest(c *gin.Context) (AnalysisRequest, bool) {
+	var req AnalysisRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("[bind] bind error: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return req, false
+	}
+	req.Owner = c.GetString(userKey)
+	return req, h.checkAnalysisRequest(c, &req)
 }
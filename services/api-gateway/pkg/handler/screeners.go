@@ -0,0 +1,25 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getScreenerLatest screener job’ının son sıralamasını döner. Her
+// calc-service instance’ı kendi partition’larındaki sembolleri sıralar;
+// cevap bunların birleşimidir ve complete tüm partition’lar gelene kadar
+// false’tur. Sadece job’ın sahibi görebilir.
+func (h *Handler) getScreenerLatest(c *gin.Context) {
+	id := c.Param("id")
+	if _, ok := h.ownedJob(c); !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "screener not found"})
+		return
+	}
+	snap, ok := h.screeners.Latest(id)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no snapshot yet"})
+		return
+	}
+	c.JSON(http.StatusOK, snap)
+}
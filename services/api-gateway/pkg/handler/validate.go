@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FieldError isteğin bir alanındaki doğrulama hatası; Field JSON yoludur,
+// örn. "indicators[0].parameters.period". calc-service’in /validate
+// cevabındakiyle aynı biçimdedir.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validateTimeout calc-service doğrulamasının süresi; sembol listesi
+// borsadan çekilebilir.
+const validateTimeout = 20 * time.Second
+
+// checkAnalysisRequest isteği doğrular; geçersizse alan listesiyle 422,
+// calc-service’e ulaşılamazsa 502 döner ve false verir.
+func (h *Handler) checkAnalysisRequest(c *gin.Context, req *AnalysisRequest) bool {
+	errs, err := h.validateAnalysisRequest(c.Request.Context(), req)
+	if err != nil {
+		log.Printf("[validate] calc-service error: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "validation unavailable"})
+		return false
+	}
+	if len(errs) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"errors": errs})
+		return false
+	}
+	return true
+}
+
+// validateAnalysisRequest isteği Kafka’ya gitmeden önce doğrular. Gateway
+// sadece JSON’a çevrilince kaybolanlara bakar (hiç verilmemiş bir threshold
+// gibi); indikatör kataloğu, parametre şemaları ve aralıkları, operatörler,
+// interval’lar ve sembolün varlığı job’ları çalıştıran kodla aynı yerde,
+// calc-service’in /validate endpoint’inde kontrol edilir.
+func (h *Handler) validateAnalysisRequest(ctx context.Context, req *AnalysisRequest) ([]FieldError, error) {
+	errs := thresholdErrors(req)
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, validateTimeout)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, h.calcAddr+"/validate", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := h.calcClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnprocessableEntity:
+		var out struct {
+			Errors []FieldError `json:"errors"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			return nil, err
+		}
+		errs = append(errs, out.Errors...)
+	default:
+		b, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("validate: %s: %s", resp.Status, b)
+	}
+	return errs, nil
+}
+
+// thresholdErrors verilmemiş threshold’ları bulur; JSON’da null ile 0
+// ayırt edilemediği için calc-service bunları göremez.
+func thresholdErrors(req *AnalysisRequest) []FieldError {
+	var errs []FieldError
+	for i, ind := range req.Indicators {
+		if ind.Threshold == nil {
+			errs = append(errs, FieldError{Field: fmt.Sprintf("indicators[%d].threshold", i), Message: "is required"})
+		}
+	}
+	if req.Conditions != nil {
+		errs = append(errs, missingThresholds("conditions", *req.Conditions)...)
+	}
+	return errs
+}
+
+// missingThresholds right operand’ı da threshold’u da olmayan yaprakları
+// bulur.
+func missingThresholds(path string, c Condition) []FieldError {
+	if strings.TrimSpace(c.Type) != "" {
+		var errs []FieldError
+		for i, child := range c.Conditions {
+			errs = append(errs, missingThresholds(fmt.Sprintf("%s.conditions[%d]", path, i), child)...)
+		}
+		return errs
+	}
+	if c.Right == nil && c.Threshold == nil {
+		return []FieldError{{Field: path + ".threshold", Message: "is required without right"}}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Control command types published to analysis.request.
+const (
+	CommandCreate = "create"
+	CommandUpdate = "update"
+	CommandDelete = "delete"
+)
+
+// Command is the control envelope calc-service consumes from analysis.request.
+type Command struct {
+	Type    string          `json:"type"`
+	JobID   string          `json:"jobId"`
+	Request json.RawMessage `json:"request,omitempty"`
+}
+
+// Job is the gateway's view of an analysis job.
+type Job struct {
+	ID        string          `json:"id"`
+	Owner     string          `json:"owner,omitempty"` // user who created the job
+	Request   json.RawMessage `json:"request"`
+	CreatedAt time.Time       `json:"createdAt"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+// Registry tracks active jobs by replaying the commands on analysis.request,
+// so every gateway instance ends up with the same view calc-service has.
+type Registry struct {
+	mu   sync.RWMutex
+	jobs map[string]*Job
+}
+
+// New returns an empty Registry.
+func New() *Registry {
+	return &Registry{jobs: make(map[string]*Job)}
+}
+
+// Apply updates the registry with a command seen at the given time.
+// Applying the same command twice is harmless.
+func (r *Registry) Apply(cmd Command, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch cmd.Type {
+	case CommandCreate, CommandUpdate:
+		owner := requestOwner(cmd.Request)
+		if job, ok := r.jobs[cmd.JobID]; ok {
+			job.Owner = owner
+			job.Request = cmd.Request
+			job.UpdatedAt = at
+			return
+		}
+		r.jobs[cmd.JobID] = &Job{ID: cmd.JobID, Owner: owner, Request: cmd.Request, CreatedAt: at, UpdatedAt: at}
+	case CommandDelete:
+		delete(r.jobs, cmd.JobID)
+	}
+}
+
+// requestOwner returns the owner of an analysis request. Requests published
+// before authentication existed have none.
+func requestOwner(raw json.RawMessage) string {
+	var req struct {
+		Owner string `json:"owner"`
+	}
+	if len(raw) == 0 || json.Unmarshal(raw, &req) != nil {
+		return ""
+	}
+	return req.Owner
+}
+
+// List returns the active jobs of owner ordered by creation time.
+func (r *Registry) List(owner string) []Job {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]Job, 0)
+	for _, job := range r.jobs {
+		if job.Owner == owner {
+			out = append(out, *job)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out
+}
+
+// Get returns the job with the given ID.
+func (r *Registry) Get(id string) (Job, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	job, ok := r.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// Run consumes analysis.request from the beginning and applies every
+// command until ctx is cancelled.
+func (r *Registry) Run(ctx context.Context, reader *kafka.Reader) {
+	for {
+		m, err := reader.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("[registry] read error: %v", err)
+			continue
+		}
+		cmd, err := ParseCommand(m.Value)
+		if err != nil {
+			log.Printf("[registry] skipping invalid command at offset %d: %v", m.Offset, err)
+			continue
+		}
+		r.Apply(cmd, m.Time)
+	}
+}
+
+// ParseCommand decodes a control message. Bare analysis requests published
+// before job IDs existed are treated as a create keyed by "symbol:interval",
+// the same way calc-service reads them.
+func ParseCommand(raw []byte) (Command, error) {
+	var cmd Command
+	if err := json.Unmarshal(raw, &cmd); err != nil {
+		return cmd, err
+	}
+	if cmd.Type != "" {
+		return cmd, nil
+	}
+	var legacy struct {
+		WebsocketKlineOptions struct {
+			Symbol   string `json:"symbol"`
+			Interval string `json:"interval"`
+		} `json:"websocketKlineOptions"`
+	}
+	if err := json.Unmarshal(raw, &legacy); err != nil {
+		return cmd, err
+	}
+	return Command{
+		Type:    CommandCreate,
+		JobID:   legacy.WebsocketKlineOptions.Symbol + ":" + legacy.WebsocketKlineOptions.Interval,
+		Request: raw,
+	}, nil
+}
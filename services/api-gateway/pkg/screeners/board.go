@@ -0,0 +1,132 @@
+// Package screeners keeps the latest ranking of every screener job from
+// the snapshots calc-service publishes to screener.snapshot.
+package screeners
+
+import (
+	"context"
+	"log"
+	"slices"
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/events"
+)
+
+// Snapshot is the ranking of a screener job for one candle, merged from the
+// snapshots of every calc-service instance. Until Complete, the symbols of
+// some kline.raw partitions are missing from it.
+type Snapshot struct {
+	events.ScreenerSnapshot
+	Complete bool `json:"complete"`
+}
+
+// Board merges screener snapshots per job and candle.
+type Board struct {
+	mu       sync.RWMutex
+	latest   map[string]*events.ScreenerSnapshot // job ID -> merge of the newest candle
+	complete map[string]events.ScreenerSnapshot  // job ID -> newest complete merge
+}
+
+// New returns an empty Board.
+func New() *Board {
+	return &Board{
+		latest:   make(map[string]*events.ScreenerSnapshot),
+		complete: make(map[string]events.ScreenerSnapshot),
+	}
+}
+
+// Apply merges a snapshot into the ranking of its candle. Snapshots of
+// older candles than the newest one seen are dropped, as are snapshots
+// whose partitions were all merged already.
+func (b *Board) Apply(s events.ScreenerSnapshot) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cur := b.latest[s.JobID]
+	switch {
+	case cur == nil || s.OpenTime.After(cur.OpenTime):
+		s.Entries = slices.Clone(s.Entries)
+		cur = &s
+		b.latest[s.JobID] = cur
+	case s.OpenTime.Equal(cur.OpenTime):
+		if !merge(cur, s) {
+			return
+		}
+	default:
+		return
+	}
+	if cur.Complete() {
+		done := *cur
+		done.Partitions = slices.Clone(cur.Partitions)
+		done.Entries = slices.Clone(cur.Entries)
+		b.complete[s.JobID] = done
+	}
+}
+
+// merge adds the symbols of s to cur and reports whether s covered a
+// partition cur didn't.
+func merge(cur *events.ScreenerSnapshot, s events.ScreenerSnapshot) bool {
+	added := false
+	for _, p := range s.Partitions {
+		if !slices.Contains(cur.Partitions, p) {
+			cur.Partitions = append(cur.Partitions, p)
+			added = true
+		}
+	}
+	if !added {
+		return false
+	}
+	slices.Sort(cur.Partitions)
+	for _, p := range s.JobPartitions {
+		if !slices.Contains(cur.JobPartitions, p) {
+			cur.JobPartitions = append(cur.JobPartitions, p)
+		}
+	}
+	slices.Sort(cur.JobPartitions)
+	cur.Evaluated += s.Evaluated
+	cur.Matched += s.Matched
+	if s.Time.After(cur.Time) {
+		cur.Time = s.Time
+	}
+	// A symbol ranked on both sides of a rebalance keeps its newer entry
+	entries := slices.DeleteFunc(cur.Entries, func(e events.ScreenerEntry) bool {
+		return slices.ContainsFunc(s.Entries, func(n events.ScreenerEntry) bool { return n.Symbol == e.Symbol })
+	})
+	cur.Entries = events.RankScreenerEntries(append(entries, s.Entries...), cur.Order, cur.Limit)
+	return true
+}
+
+// Latest returns the newest complete ranking of a job or, while no
+// candle has been ranked on every partition, the newest partial one.
+func (b *Board) Latest(jobID string) (Snapshot, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if s, ok := b.complete[jobID]; ok {
+		return Snapshot{ScreenerSnapshot: s, Complete: true}, true
+	}
+	if s, ok := b.latest[jobID]; ok {
+		return Snapshot{ScreenerSnapshot: *s, Complete: false}, true
+	}
+	return Snapshot{}, false
+}
+
+// Run consumes screener.snapshot from the beginning and applies every
+// snapshot until ctx is cancelled.
+func (b *Board) Run(ctx context.Context, reader *kafka.Reader) {
+	for {
+		m, err := reader.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("[screeners] read error: %v", err)
+			continue
+		}
+		s, err := events.DecodeScreenerSnapshot(m.Value)
+		if err != nil {
+			log.Printf("[screeners] skipping invalid snapshot at offset %d: %v", m.Offset, err)
+			continue
+		}
+		b.Apply(s)
+	}
+}
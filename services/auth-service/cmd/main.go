@@ -1,19 +1,31 @@
 package main
 
-// "github.com/ae144de/sonarbot-service-infra2/services/auth-service/pkg/config"
-// "github.com/ae144de/sonarbot-service-infra2/services/auth-service/pkg/handler"
+import (
+	"log"
+	"net/http"
+
+	"github.com/ae144de/sonarbot-service-infra2/services/auth-service/pkg/config"
+	"github.com/ae144de/sonarbot-service-infra2/services/auth-service/pkg/handler"
+	"github.com/ae144de/sonarbot-service-infra2/services/auth-service/pkg/store"
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/auth"
+)
 
 func main() {
-	// cfg := config.LoadConfig()
-	// userStore, err := store.NewUserStore(cfg.MongoURI)
-	// if err != nil {
-	// 	log.Fatalf("UserStore init error: %v", err)
-	// }
+	cfg := config.LoadConfig()
+	if err := auth.CheckSigningKey([]byte(cfg.JWTSigningKey)); err != nil {
+		log.Fatalf("JWT_SIGNING_KEY: %v", err)
+	}
+
+	userStore, err := store.NewUserStore(cfg.MongoURI)
+	if err != nil {
+		log.Fatalf("UserStore init error: %v", err)
+	}
 
-	// router := handler.NewRouter(userStore, cfg)
+	router := handler.NewRouter(userStore, cfg)
 
-	// log.Println("Auth Service running on port 8080")
-	// if err := http.ListenAndServe(":8080", router); err != nil {
-	// 	log.Fatalf("Server error: %v", err)
-	// }
+	addr := ":" + cfg.Port
+	log.Printf("Auth Service running on %s", addr)
+	if err := http.ListenAndServe(addr, router); err != nil {
+		log.Fatalf("Server error: %v", err)
+	}
 }
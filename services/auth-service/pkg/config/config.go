@@ -7,8 +7,9 @@ import (
 
 // Config holds auth service configuration.
 type Config struct {
+	Port          string
 	MongoURI      string
-	JWTSigningKey string
+	JWTSigningKey string // no default: tokens signed with a well-known key are forgeable
 	TokenTTL      time.Duration
 }
 
@@ -19,8 +20,9 @@ func LoadConfig() Config {
 		ttl = 24 * time.Hour
 	}
 	return Config{
+		Port:          getEnv("PORT", "8080"),
 		MongoURI:      getEnv("MONGO_URI", "mongodb://localhost:27017"),
-		JWTSigningKey: getEnv("JWT_SIGNING_KEY", "supersecret"),
+		JWTSigningKey: os.Getenv("JWT_SIGNING_KEY"),
 		TokenTTL:      ttl,
 	}
 }
@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ae144de/sonarbot-service-infra2/services/auth-service/pkg/config"
+	"github.com/ae144de/sonarbot-service-infra2/services/auth-service/pkg/store"
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/auth"
+)
+
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 128
+	maxUsernameLength = 64
+	maxBodyBytes      = 1 << 16
+)
+
+// dummyHash is checked against when the username is unknown, so that a
+// login takes as long whether or not the user exists.
+var dummyHash, _ = store.HashPassword("not a real password")
+
+// credentials is the body of register and login requests.
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// tokenResponse is returned by login. The token goes into the
+// Authorization: Bearer header of api-gateway requests.
+type tokenResponse struct {
+	Token     string    `json:"token"`
+	TokenType string    `json:"tokenType"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	UserID    string    `json:"userId"`
+}
+
+type handler struct {
+	users *store.UserStore
+	key   []byte
+	ttl   time.Duration
+}
+
+// NewRouter returns the auth service's HTTP routes:
+//
+//	POST /register  create a user from {"username", "password"}
+//	POST /login     exchange {"username", "password"} for a JWT
+//	GET  /healthz
+func NewRouter(users *store.UserStore, cfg config.Config) http.Handler {
+	h := &handler{users: users, key: []byte(cfg.JWTSigningKey), ttl: cfg.TokenTTL}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("POST /register", h.register)
+	mux.HandleFunc("POST /login", h.login)
+	return mux
+}
+
+func (h *handler) register(w http.ResponseWriter, r *http.Request) {
+	creds, ok := decodeCredentials(w, r)
+	if !ok {
+		return
+	}
+	if n := utf8.RuneCountInString(creds.Username); n == 0 || n > maxUsernameLength {
+		writeError(w, http.StatusUnprocessableEntity, "username must be 1 to 64 characters")
+		return
+	}
+	if n := len(creds.Password); n < minPasswordLength || n > maxPasswordLength {
+		writeError(w, http.StatusUnprocessableEntity, "password must be 8 to 128 bytes")
+		return
+	}
+	hash, err := store.HashPassword(creds.Password)
+	if err != nil {
+		log.Printf("[register] hash error: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not create user")
+		return
+	}
+	u := &store.User{Username: creds.Username, Password: hash}
+	if err := h.users.Create(r.Context(), u); err != nil {
+		if errors.Is(err, store.ErrUserExists) {
+			writeError(w, http.StatusConflict, "username taken")
+			return
+		}
+		log.Printf("[register] store error: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not create user")
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"userId": u.ID, "username": u.Username})
+}
+
+func (h *handler) login(w http.ResponseWriter, r *http.Request) {
+	creds, ok := decodeCredentials(w, r)
+	if !ok {
+		return
+	}
+	u, err := h.users.FindByUsername(r.Context(), creds.Username)
+	if err != nil && !errors.Is(err, store.ErrUserNotFound) {
+		log.Printf("[login] store error: %v", err)
+		writeError(w, http.StatusInternalServerError, "login failed")
+		return
+	}
+	hash := dummyHash
+	if u != nil {
+		hash = u.Password
+	}
+	match, err := store.CheckPassword(hash, creds.Password)
+	if err != nil {
+		log.Printf("[login] password check error: %v", err)
+	}
+	if u == nil || !match {
+		writeError(w, http.StatusUnauthorized, "invalid credentials")
+		return
+	}
+	token, err := auth.Issue(h.key, u.ID, h.ttl)
+	if err != nil {
+		log.Printf("[login] issue error: %v", err)
+		writeError(w, http.StatusInternalServerError, "login failed")
+		return
+	}
+	writeJSON(w, http.StatusOK, tokenResponse{
+		Token:     token,
+		TokenType: "Bearer",
+		ExpiresAt: time.Now().Add(h.ttl).UTC(),
+		UserID:    u.ID,
+	})
+}
+
+func decodeCredentials(w http.ResponseWriter, r *http.Request) (credentials, bool) {
+	var creds credentials
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes)).Decode(&creds); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return creds, false
+	}
+	return creds, true
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
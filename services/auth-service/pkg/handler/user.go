@@ -1,3 +0,0 @@
-package handler
-
-func user() {}
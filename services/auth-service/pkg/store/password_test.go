@@ -0,0 +1,64 @@
+package store
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "pbkdf2-sha256$600000$") {
+		t.Errorf("hash = %q, want pbkdf2-sha256 with 600000 iterations", hash)
+	}
+	again, err := HashPassword("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == hash {
+		t.Error("hashing the same password twice gave the same hash; salt is not random")
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	hash, err := HashPassword("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A cheap hash in the stored format, checked with its own iteration count
+	lowCost := "pbkdf2-sha256$1$c2FsdHNhbHRzYWx0c2FsdA$" + strings.Split(hash, "$")[3]
+
+	tests := []struct {
+		name     string
+		hash     string
+		password string
+		want     bool
+		wantErr  error
+	}{
+		{"match", hash, "s3cret", true, nil},
+		{"wrong password", hash, "s3cret!", false, nil},
+		{"empty password", hash, "", false, nil},
+		{"other iteration count", lowCost, "s3cret", false, nil},
+		{"other scheme", strings.Replace(hash, "pbkdf2-sha256", "bcrypt", 1), "s3cret", false, errBadHash},
+		{"missing part", strings.Join(strings.Split(hash, "$")[:3], "$"), "s3cret", false, errBadHash},
+		{"bad iterations", "pbkdf2-sha256$many$c2FsdA$a2V5", "s3cret", false, errBadHash},
+		{"zero iterations", "pbkdf2-sha256$0$c2FsdA$a2V5", "s3cret", false, errBadHash},
+		{"bad salt", "pbkdf2-sha256$1$!!$a2V5", "s3cret", false, errBadHash},
+		{"bad key", "pbkdf2-sha256$1$c2FsdA$!!", "s3cret", false, errBadHash},
+		{"empty", "", "s3cret", false, errBadHash},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CheckPassword(tt.hash, tt.password)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CheckPassword error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("CheckPassword = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,12 +2,24 @@ package store
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+var (
+	// ErrUserExists is returned when creating a user whose username is taken.
+	ErrUserExists = errors.New("user already exists")
+	// ErrUserNotFound is returned when no user has the given username.
+	ErrUserNotFound = errors.New("user not found")
+)
+
 // User represents an application user and their config.
 type User struct {
 	ID        string    `bson:"_id,omitempty"`
@@ -22,14 +34,55 @@ type UserStore struct {
 	col *mongo.Collection
 }
 
-// NewUserStore initializes a store with given Mongo URI.
+// NewUserStore initializes a store with given Mongo URI and makes sure
+// usernames are unique.
 func NewUserStore(uri string) (*UserStore, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("mongo connect error: %w", err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		return nil, fmt.Errorf("mongo ping error: %w", err)
 	}
 	col := client.Database("platform").Collection("users")
+	_, err = col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "username", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("username index error: %w", err)
+	}
 	return &UserStore{col: col}, nil
 }
+
+// Create inserts a new user with a random ID and sets u.ID and u.CreatedAt.
+func (s *UserStore) Create(ctx context.Context, u *User) error {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	u.ID = hex.EncodeToString(b)
+	u.CreatedAt = time.Now().UTC()
+	if _, err := s.col.InsertOne(ctx, u); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrUserExists
+		}
+		return err
+	}
+	return nil
+}
+
+// FindByUsername returns the user with the given username.
+func (s *UserStore) FindByUsername(ctx context.Context, username string) (*User, error) {
+	var u User
+	err := s.col.FindOne(ctx, bson.M{"username": username}).Decode(&u)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
@@ -3,15 +3,19 @@ package main
 import (
 	"context"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	kafka "github.com/segmentio/kafka-go"
 
+	"github.com/ae144de/sonarbot-service-infra2/services/calc-service/pkg/api"
 	"github.com/ae144de/sonarbot-service-infra2/services/calc-service/pkg/calculator"
-	"github.com/ae144de/sonarbot-service-infra2/services/calc-service/pkg/processor"
 	"github.com/ae144de/sonarbot-service-infra2/services/calc-service/pkg/redis"
+	"github.com/ae144de/sonarbot-service-infra2/services/calc-service/pkg/shard"
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/events"
 )
 
 func main() {
@@ -34,24 +38,22 @@ func main() {
 	ctrlTopic := os.Getenv("ANALYSIS_REQUEST_TOPIC")
 	alertTopic := os.Getenv("ALERT_TRIGGER_TOPIC")
 
+	// Her instance kendi ID’sini taşır; control topic’i instance başına ayrı
+	// bir group ile okunur ki her instance tüm job’ları görsün
+	instanceID := os.Getenv("INSTANCE_ID")
+	if instanceID == "" {
+		instanceID, _ = os.Hostname()
+	}
+
 	// 2) Control reader (analysis.request)
 	ctrlReader := kafka.NewReader(kafka.ReaderConfig{
 		Brokers:     []string{kafkaAddr},
-		GroupID:     "calc-control",
+		GroupID:     "calc-control-" + instanceID,
 		Topic:       ctrlTopic,
 		StartOffset: kafka.FirstOffset,
 	})
 	// defer ctrlReader.Close()
 
-	// 3) Data reader (kline.raw)
-	dataReader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:     []string{kafkaAddr},
-		GroupID:     "calc-data",
-		Topic:       rawTopic,
-		StartOffset: kafka.FirstOffset,
-	})
-	// defer dataReader.Close()
-
 	// 4) Kafka writer for alerts
 	alertWriter := kafka.NewWriter(kafka.WriterConfig{
 		Brokers: []string{kafkaAddr},
@@ -59,8 +61,38 @@ func main() {
 	})
 	defer alertWriter.Close()
 
-	// 5) Calculator service
-	calcSvc := calculator.NewCalculator(alertWriter)
+	// 5) Calculator service, state Redis’ten geri yüklenir
+	calcSvc := calculator.NewCalculator(alertWriter, redis.NewStore(redis.Client))
+	// Doldurulamayan kline boşlukları data quality topic’ine raporlanır
+	if qualityTopic := os.Getenv("DATA_QUALITY_TOPIC"); qualityTopic != "" {
+		qualityWriter := kafka.NewWriter(kafka.WriterConfig{
+			Brokers: []string{kafkaAddr},
+			Topic:   qualityTopic,
+		})
+		defer qualityWriter.Close()
+		calcSvc.SetQualityWriter(qualityWriter)
+	}
+	// Screener job’larının sıralamaları screener snapshot topic’ine yazılır
+	if screenerTopic := os.Getenv("SCREENER_SNAPSHOT_TOPIC"); screenerTopic != "" {
+		screenerWriter := kafka.NewWriter(kafka.WriterConfig{
+			Brokers: []string{kafkaAddr},
+			Topic:   screenerTopic,
+		})
+		defer screenerWriter.Close()
+		calcSvc.SetScreenerWriter(screenerWriter)
+	}
+	if err := calcSvc.Restore(ctxKafka); err != nil {
+		log.Printf("State restore error (starting empty): %v", err)
+	}
+	flushEvery, err := time.ParseDuration(os.Getenv("STATE_FLUSH_INTERVAL"))
+	if err != nil || flushEvery <= 0 {
+		flushEvery = 5 * time.Second
+	}
+	flushDone := make(chan struct{})
+	go func() {
+		calcSvc.RunFlusher(ctxShutdown, flushEvery)
+		close(flushDone)
+	}()
 
 	// // 6) Context & signal
 	// ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
@@ -76,34 +108,73 @@ func main() {
 				log.Printf("Control fetch error: %v", err)
 				continue
 			}
-			calcSvc.HandleControl(ctxKafka, m.Value)
-			ctrlReader.CommitMessages(ctxKafka, m)
+			// Partition sonuna kadar okunmamış komutlar biriktirilir;
+			// commit ancak uygulandıklarında yapılır
+			if calcSvc.HandleControl(ctxKafka, m) {
+				ctrlReader.CommitMessages(ctxKafka, m)
+			}
 		}
 	}()
 
-	// 8) Data loop: consume kline.raw and process
-	// log.Println("Calc-service up, awaiting kline.raw messages…")
-	// for {
-	// 	m, err := dataReader.FetchMessage(ctx)
-	// 	if err != nil {
-	// 		log.Printf("Data fetch error: %v", err)
-	// 		continue
-	// 	}
-	// 	processor.HandleKline(calcSvc, ctx, m.Value)
-	// 	dataReader.CommitMessages(ctx, m)
-	// }
-	// Data loop:
-	go func() {
-		log.Println("▶️ Data loop started")
-		for {
-			m, err := dataReader.FetchMessage(ctxKafka)
-			if err != nil {
-				log.Printf("Data fetch error (will retry): %v", err)
-				// time.Sleep(time.Second)
-				continue
+	// 7b) Symbol loop: kline-fetcher’ın yayınladığı listing/delisting
+	// event’leri "ALL" job’larının sembol listesini günceller
+	if symbolTopic := os.Getenv("SYMBOL_EVENTS_TOPIC"); symbolTopic != "" {
+		symbolReader := kafka.NewReader(kafka.ReaderConfig{
+			Brokers:     []string{kafkaAddr},
+			GroupID:     "calc-symbols-" + instanceID,
+			Topic:       symbolTopic,
+			StartOffset: kafka.FirstOffset,
+		})
+		defer symbolReader.Close()
+		go func() {
+			for {
+				m, err := symbolReader.FetchMessage(ctxShutdown)
+				if err != nil {
+					if ctxShutdown.Err() != nil {
+						return
+					}
+					log.Printf("Symbol event fetch error: %v", err)
+					continue
+				}
+				evt, err := events.DecodeSymbolEvent(m.Value)
+				if err != nil {
+					log.Printf("Skipping invalid symbol event at offset %d: %v", m.Offset, err)
+				} else {
+					calcSvc.ApplySymbolEvent(ctxKafka, evt)
+				}
+				symbolReader.CommitMessages(ctxKafka, m)
 			}
-			processor.HandleKline(calcSvc, ctxKafka, m.Value)
-			dataReader.CommitMessages(ctxKafka, m)
+		}()
+	}
+
+	// 8) Data loop: kline.raw partition’ları "calc-data" group’undaki
+	// instance’lar arasında paylaşılır; her instance yalnızca kendi
+	// partition’larındaki sembollerin state’ini tutar
+	dataDone := make(chan struct{})
+	go func() {
+		defer close(dataDone)
+		log.Printf("▶️ Data loop started (instance %s)", instanceID)
+		err := shard.Run(ctxShutdown, shard.Config{
+			Brokers:     []string{kafkaAddr},
+			GroupID:     "calc-data",
+			Topic:       rawTopic,
+			CommitEvery: time.Second,
+		}, calcSvc)
+		if err != nil {
+			log.Printf("Data loop error: %v", err)
+		}
+	}()
+
+	// 9) Dahili HTTP API (backtest vb.); dışarıya api-gateway üzerinden açılır
+	httpPort := os.Getenv("HTTP_PORT")
+	if httpPort == "" {
+		httpPort = "8080"
+	}
+	httpSrv := &http.Server{Addr: ":" + httpPort, Handler: api.NewHandler(calcSvc)}
+	go func() {
+		log.Printf("▶️ HTTP API listening on :%s", httpPort)
+		if err := httpSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("HTTP server error: %v", err)
 		}
 	}()
 
@@ -112,5 +183,10 @@ func main() {
 	log.Println("Shutting down calc-service…")
 	// reader’ları kapatıp exit edebilirsiniz
 	ctrlReader.Close()
-	dataReader.Close()
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelShutdown()
+	httpSrv.Shutdown(shutdownCtx)
+	<-dataDone
+	// Son state flush’ının bitmesini bekle
+	<-flushDone
 }
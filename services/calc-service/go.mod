@@ -3,24 +3,15 @@ module github.com/ae144de/sonarbot-service-infra2/services/calc-service
 go 1.24.1
 
 require (
-	github.com/adshao/go-binance/v2 v2.8.2
+	github.com/ae144de/sonarbot-service-infra2/services/services v0.0.0-20250516170218-c5f096f9e3b6
 	github.com/go-redis/redis/v8 v8.11.5
+	github.com/markcheno/go-talib v0.0.0-20250114000313-ec55a20c902f
 	github.com/segmentio/kafka-go v0.4.48
 )
 
-require (
-	github.com/bitly/go-simplejson v0.5.0 // indirect
-	github.com/google/uuid v1.6.0 // indirect
-	github.com/gorilla/websocket v1.5.3 // indirect
-	github.com/jpillora/backoff v1.0.0 // indirect
-	github.com/kr/text v0.2.0 // indirect
-	github.com/shopspring/decimal v1.4.0 // indirect
-)
-
 require (
 	github.com/cespare/xxhash/v2 v2.1.2 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 	github.com/klauspost/compress v1.15.9 // indirect
-	github.com/markcheno/go-talib v0.0.0-20250114000313-ec55a20c902f
 	github.com/pierrec/lz4/v4 v4.1.15 // indirect
 )
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ae144de/sonarbot-service-infra2/services/calc-service/pkg/backtest"
+	"github.com/ae144de/sonarbot-service-infra2/services/calc-service/pkg/calculator"
+	"github.com/ae144de/sonarbot-service-infra2/services/calc-service/pkg/evaluate"
+)
+
+// backtestTimeout bounds a single backtest including fetching its history.
+const backtestTimeout = 2 * time.Minute
+
+// NewHandler returns the calc-service HTTP API. It is internal: clients go
+// through api-gateway, which proxies to it. On-demand evaluations reuse the
+// windows calcSvc keeps for running jobs.
+func NewHandler(calcSvc *calculator.Calculator) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("POST /backtests", runBacktest)
+	mux.HandleFunc("POST /validate", validateRequest)
+	mux.HandleFunc("POST /evaluate", func(w http.ResponseWriter, r *http.Request) {
+		evaluateRequest(w, r, calcSvc)
+	})
+	mux.HandleFunc("GET /catalog", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, calculator.GetCatalog())
+	})
+	return mux
+}
+
+// validateTimeout bounds a validation, which may fetch the symbol list.
+const validateTimeout = 15 * time.Second
+
+// validateRequest answers 200 for a request calc-service would run and
+// 422 with the list of field errors otherwise.
+func validateRequest(w http.ResponseWriter, r *http.Request) {
+	var req calculator.AnalysisRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), validateTimeout)
+	defer cancel()
+	if errs := calculator.ValidateRequest(ctx, req); len(errs) > 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{"errors": errs})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"valid": true})
+}
+
+// evaluateRequest evaluates a request on its symbols right now; invalid
+// requests get 422 with the list of field errors.
+func evaluateRequest(w http.ResponseWriter, r *http.Request, calcSvc *calculator.Calculator) {
+	var req evaluate.Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), validateTimeout)
+	errs := evaluate.Validate(ctx, req)
+	cancel()
+	if len(errs) > 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{"errors": errs})
+		return
+	}
+
+	start := time.Now()
+	res, err := evaluate.Run(r.Context(), calcSvc, req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	log.Printf("[api] evaluated %d symbols on %s in %s", len(res.Symbols), res.Interval, time.Since(start))
+	writeJSON(w, http.StatusOK, res)
+}
+
+func runBacktest(w http.ResponseWriter, r *http.Request) {
+	var req backtest.Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), backtestTimeout)
+	defer cancel()
+
+	start := time.Now()
+	res, err := backtest.Run(ctx, req)
+	switch {
+	case errors.Is(err, backtest.ErrInvalidRequest):
+		writeError(w, http.StatusBadRequest, err)
+		return
+	case err != nil:
+		log.Printf("[api] backtest of %s:%s failed: %v", req.WebsocketKlineOptions.Symbol, req.WebsocketKlineOptions.Interval, err)
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	log.Printf("[api] backtest of %s:%s evaluated %d klines in %s, %d alerts",
+		res.Symbol, res.Interval, res.Evaluated, time.Since(start), len(res.Alerts))
+	writeJSON(w, http.StatusOK, res)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("[api] encode response error: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
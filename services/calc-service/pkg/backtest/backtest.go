@@ -0,0 +1,200 @@
+package backtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ae144de/sonarbot-service-infra2/services/calc-service/pkg/calculator"
+	"github.com/ae144de/sonarbot-service-infra2/services/calc-service/pkg/processor"
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/events"
+)
+
+// ErrInvalidRequest wraps every error caused by the request itself rather
+// than by fetching history.
+var ErrInvalidRequest = errors.New("invalid backtest request")
+
+// maxCandles bounds the number of klines a single backtest replays.
+const maxCandles = 50_000
+
+// defaultHorizons are the forward-return horizons used when a request
+// doesn't list any.
+var defaultHorizons = []string{"1h", "4h", "24h"}
+
+// Request is an AnalysisRequest plus the range to replay it over. Klines
+// opening in [From, To) are evaluated; the ones before From only warm up
+// the window.
+type Request struct {
+	calculator.AnalysisRequest
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Horizons []string  `json:"horizons,omitempty"` // e.g. "1h", "4h", "24h"
+}
+
+// Alert is an alert the request would have published, with the price change
+// in percent from the alert's candle close to each horizon. Returns whose
+// horizon lies beyond the available history are nil.
+type Alert struct {
+	Event   events.AlertEvent   `json:"event"`
+	Returns map[string]*float64 `json:"returns"`
+}
+
+// Stats summarises the forward returns of all alerts at one horizon.
+type Stats struct {
+	Count   int     `json:"count"`
+	Mean    float64 `json:"mean"`
+	Median  float64 `json:"median"`
+	WinRate float64 `json:"winRate"` // share of positive returns
+}
+
+// Result is the outcome of a backtest.
+type Result struct {
+	Exchange  string           `json:"exchange"`
+	Market    string           `json:"market"`
+	Symbol    string           `json:"symbol"`
+	Interval  string           `json:"interval"`
+	From      time.Time        `json:"from"`
+	To        time.Time        `json:"to"`
+	Evaluated int              `json:"evaluated"` // klines evaluated
+	Alerts    []Alert          `json:"alerts"`
+	Summary   map[string]Stats `json:"summary"`
+}
+
+// Run replays the klines of the request's range through the same
+// evaluation HandleKline uses and collects every alert the job's alert
+// policy would have let out. History only has closed candles, so intrabar
+// jobs are evaluated once per candle.
+func Run(ctx context.Context, req Request) (Result, error) {
+	sym := req.WebsocketKlineOptions.Symbol
+	interval := req.WebsocketKlineOptions.Interval
+	if sym == "" || sym == "ALL" {
+		return Result{}, fmt.Errorf("%w: backtests need a single symbol", ErrInvalidRequest)
+	}
+	if req.Screener != nil {
+		return Result{}, fmt.Errorf("%w: screeners can't be backtested", ErrInvalidRequest)
+	}
+	if !req.From.Before(req.To) {
+		return Result{}, fmt.Errorf("%w: from must be before to", ErrInvalidRequest)
+	}
+	step, err := calculator.IntervalDuration(interval)
+	if err != nil {
+		return Result{}, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+	if n := req.To.Sub(req.From) / step; n > maxCandles {
+		return Result{}, fmt.Errorf("%w: range covers %d klines, max is %d", ErrInvalidRequest, n, maxCandles)
+	}
+	if len(req.Horizons) == 0 {
+		req.Horizons = defaultHorizons
+	}
+	horizons := make([]time.Duration, len(req.Horizons))
+	var longest time.Duration
+	for i, h := range req.Horizons {
+		if horizons[i], err = time.ParseDuration(h); err != nil || horizons[i] <= 0 {
+			return Result{}, fmt.Errorf("%w: invalid horizon %s", ErrInvalidRequest, h)
+		}
+		longest = max(longest, horizons[i])
+	}
+
+	job, err := calculator.CompileJob("backtest", req.AnalysisRequest, []string{sym})
+	if err != nil {
+		return Result{}, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+
+	// A calculator of its own keeps the replay's previous values and alert
+	// state apart from the live jobs
+	calc := calculator.NewCalculator(nil, nil)
+	warmup := time.Duration(job.WindowSize-1) * step
+	end := req.To.Add(longest)
+	if now := time.Now(); end.After(now) {
+		end = now
+	}
+	klines, err := calc.FetchRange(ctx, job.Exchange, job.Market, sym, interval, req.From.Add(-warmup), end)
+	if err != nil {
+		return Result{}, fmt.Errorf("fetching history: %w", err)
+	}
+
+	res := Result{Exchange: job.Exchange, Market: job.Market, Symbol: sym, Interval: interval, From: req.From, To: req.To, Alerts: []Alert{}}
+	var window []calculator.Kline
+	for i, k := range klines {
+		if !k.IsClosed || !k.OpenTime.Before(req.To) {
+			break
+		}
+		if len(window) >= job.WindowSize {
+			window = window[1:]
+		}
+		window = append(window, k)
+		if k.OpenTime.Before(req.From) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return Result{}, err
+		}
+		res.Evaluated++
+		evt, ok := processor.Evaluate(calc, job, sym, interval, window, k.CloseTime)
+		if !ok {
+			continue
+		}
+		res.Alerts = append(res.Alerts, Alert{
+			Event:   evt,
+			Returns: forwardReturns(klines[i:], req.Horizons, horizons),
+		})
+	}
+	res.Summary = summarize(res.Alerts, req.Horizons)
+	return res, nil
+}
+
+// forwardReturns computes the price change from the close of after[0] to
+// the close of the first kline closing at least each horizon later.
+func forwardReturns(after []calculator.Kline, names []string, horizons []time.Duration) map[string]*float64 {
+	base := after[0]
+	out := make(map[string]*float64, len(names))
+	for i, h := range horizons {
+		target := base.CloseTime.Add(h)
+		j := sort.Search(len(after), func(j int) bool { return !after[j].CloseTime.Before(target) })
+		if j == len(after) || !after[j].IsClosed || base.Close == 0 {
+			out[names[i]] = nil
+			continue
+		}
+		r := (after[j].Close - base.Close) / base.Close * 100
+		out[names[i]] = &r
+	}
+	return out
+}
+
+func summarize(alerts []Alert, names []string) map[string]Stats {
+	out := make(map[string]Stats, len(names))
+	for _, name := range names {
+		var rets []float64
+		wins := 0
+		for _, a := range alerts {
+			if r := a.Returns[name]; r != nil {
+				rets = append(rets, *r)
+				if *r > 0 {
+					wins++
+				}
+			}
+		}
+		if len(rets) == 0 {
+			out[name] = Stats{}
+			continue
+		}
+		sort.Float64s(rets)
+		var sum float64
+		for _, r := range rets {
+			sum += r
+		}
+		median := rets[len(rets)/2]
+		if len(rets)%2 == 0 {
+			median = (rets[len(rets)/2-1] + rets[len(rets)/2]) / 2
+		}
+		out[name] = Stats{
+			Count:   len(rets),
+			Mean:    sum / float64(len(rets)),
+			Median:  median,
+			WinRate: float64(wins) / float64(len(rets)),
+		}
+	}
+	return out
+}
@@ -0,0 +1,168 @@
+package calculator
+
+import (
+	"strings"
+	"time"
+)
+
+// Alert modes.
+const (
+	// AlertOnce fires when the conditions become met and re-arms once they
+	// evaluate false again.
+	AlertOnce = "ONCE"
+	// AlertEvery fires on every evaluation the conditions are met.
+	AlertEvery = "EVERY"
+)
+
+// Evaluation timings.
+const (
+	// EvaluateIntrabar evaluates the conditions on every kline update.
+	EvaluateIntrabar = "INTRABAR"
+	// EvaluateClose evaluates the conditions only when a candle closes.
+	EvaluateClose = "CLOSE"
+)
+
+// Alert state is kept with the previous values of a job's symbol, so it is
+// persisted, rebalanced and reset together with them. Leaf state keys
+// start with a condition path, so these can't collide.
+const (
+	alertKeyFired       = "alert/fired"       // 1 once the current met streak has alerted
+	alertKeyLast        = "alert/last"        // unix millis of the last alert
+	alertKeyPeriodStart = "alert/periodStart" // unix millis the current rate period started
+	alertKeyCount       = "alert/count"       // alerts sent in the current rate period
+)
+
+// AlertPolicy controls when a job whose conditions are met publishes an
+// alert. Durations use Go syntax, e.g. "15m" or "1h". The zero value fires
+// once per met streak, intrabar, without cooldown or rate limit.
+type AlertPolicy struct {
+	Mode      string `json:"mode,omitempty"`      // ONCE (default) or EVERY
+	Cooldown  string `json:"cooldown,omitempty"`  // minimum time between two alerts
+	MaxAlerts int    `json:"maxAlerts,omitempty"` // at most this many alerts per Period
+	Period    string `json:"period,omitempty"`
+	Evaluate  string `json:"evaluate,omitempty"` // INTRABAR (default) or CLOSE
+}
+
+// alertRules is the compiled form of an AlertPolicy.
+type alertRules struct {
+	once      bool
+	onClose   bool
+	cooldown  time.Duration
+	maxAlerts int
+	period    time.Duration
+}
+
+// compileAlertPolicy compiles the policy, adding its problems to errs.
+func compileAlertPolicy(p *AlertPolicy, errs *fieldErrors) alertRules {
+	r := alertRules{once: true}
+	if p == nil {
+		return r
+	}
+	switch strings.ToUpper(p.Mode) {
+	case "", AlertOnce:
+	case AlertEvery:
+		r.once = false
+	default:
+		errs.add("alert.mode", "unknown alert mode %q (supported: %s, %s)", p.Mode, AlertOnce, AlertEvery)
+	}
+	switch strings.ToUpper(p.Evaluate) {
+	case "", EvaluateIntrabar:
+	case EvaluateClose:
+		r.onClose = true
+	default:
+		errs.add("alert.evaluate", "unknown evaluation %q (supported: %s, %s)", p.Evaluate, EvaluateIntrabar, EvaluateClose)
+	}
+	var err error
+	if p.Cooldown != "" {
+		if r.cooldown, err = time.ParseDuration(p.Cooldown); err != nil || r.cooldown < 0 {
+			errs.add("alert.cooldown", "invalid duration %q", p.Cooldown)
+		}
+	}
+	periodOK := true
+	if p.Period != "" {
+		if r.period, err = time.ParseDuration(p.Period); err != nil || r.period <= 0 {
+			errs.add("alert.period", "invalid duration %q", p.Period)
+			periodOK = false
+		}
+	}
+	switch {
+	case p.MaxAlerts < 0:
+		errs.add("alert.maxAlerts", "must not be negative")
+	case p.MaxAlerts > 0 && p.Period == "":
+		errs.add("alert.period", "is required with maxAlerts")
+	case p.MaxAlerts == 0 && r.period > 0 && periodOK:
+		errs.add("alert.maxAlerts", "is required with period")
+	}
+	r.maxAlerts = p.MaxAlerts
+	return r
+}
+
+// EvaluatesOn reports whether the job's conditions are evaluated on the
+// given kline update.
+func (j *Job) EvaluatesOn(k Kline) bool {
+	return !j.rules.onClose || k.IsClosed
+}
+
+// EvaluationMode returns EvaluateClose or EvaluateIntrabar.
+func (j *Job) EvaluationMode() string {
+	if j.rules.onClose {
+		return EvaluateClose
+	}
+	return EvaluateIntrabar
+}
+
+// ShouldAlert records the outcome of evaluating a job's conditions on a
+// symbol and reports whether it has to be published under the job's alert
+// policy.
+func (c *Calculator) ShouldAlert(job *Job, symbol string, met bool, now time.Time) bool {
+	c.prevMu.Lock()
+	defer c.prevMu.Unlock()
+
+	if _, ok := c.prevValues[job.ID]; !ok {
+		c.prevValues[job.ID] = make(map[string]map[string]float64)
+	}
+	st, ok := c.prevValues[job.ID][symbol]
+	if !ok {
+		st = make(map[string]float64)
+		c.prevValues[job.ID][symbol] = st
+	}
+	markDirty := func() {
+		if _, ok := c.dirtyPrev[job.ID]; !ok {
+			c.dirtyPrev[job.ID] = make(map[string]struct{})
+		}
+		c.dirtyPrev[job.ID][symbol] = struct{}{}
+	}
+
+	if !met {
+		// Re-arm
+		if st[alertKeyFired] != 0 {
+			st[alertKeyFired] = 0
+			markDirty()
+		}
+		return false
+	}
+
+	r := job.rules
+	nowMs := float64(now.UnixMilli())
+	if r.once && st[alertKeyFired] != 0 {
+		return false
+	}
+	if last, ok := st[alertKeyLast]; ok && r.cooldown > 0 && nowMs-last < float64(r.cooldown.Milliseconds()) {
+		return false
+	}
+	if r.maxAlerts > 0 {
+		if start, ok := st[alertKeyPeriodStart]; !ok || nowMs-start >= float64(r.period.Milliseconds()) {
+			st[alertKeyPeriodStart] = nowMs
+			st[alertKeyCount] = 0
+		}
+		if int(st[alertKeyCount]) >= r.maxAlerts {
+			markDirty()
+			return false
+		}
+		st[alertKeyCount]++
+	}
+	st[alertKeyFired] = 1
+	st[alertKeyLast] = nowMs
+	markDirty()
+	return true
+}
@@ -0,0 +1,195 @@
+package calculator
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// alertJob returns a job with the compiled alert policy.
+func alertJob(t *testing.T, p *AlertPolicy) *Job {
+	t.Helper()
+	var errs fieldErrors
+	rules := compileAlertPolicy(p, &errs)
+	if err := errs.err(); err != nil {
+		t.Fatal(err)
+	}
+	return &Job{ID: "job-1", rules: rules}
+}
+
+func TestShouldAlert(t *testing.T) {
+	type step struct {
+		at   time.Duration // since the first evaluation
+		met  bool
+		want bool
+	}
+	tests := []struct {
+		name   string
+		policy *AlertPolicy
+		steps  []step
+	}{
+		{
+			name:   "default fires once per met streak",
+			policy: nil,
+			steps: []step{
+				{0, true, true},
+				{time.Minute, true, false},
+				{2 * time.Minute, false, false},
+				{3 * time.Minute, true, true},
+				{4 * time.Minute, true, false},
+			},
+		},
+		{
+			name:   "ONCE",
+			policy: &AlertPolicy{Mode: "once"},
+			steps: []step{
+				{0, false, false},
+				{time.Minute, true, true},
+				{2 * time.Minute, true, false},
+				{3 * time.Minute, false, false},
+				{4 * time.Minute, true, true},
+			},
+		},
+		{
+			name:   "EVERY",
+			policy: &AlertPolicy{Mode: AlertEvery},
+			steps: []step{
+				{0, true, true},
+				{time.Second, true, true},
+				{2 * time.Second, false, false},
+				{3 * time.Second, true, true},
+			},
+		},
+		{
+			name:   "EVERY with cooldown",
+			policy: &AlertPolicy{Mode: AlertEvery, Cooldown: "5m"},
+			steps: []step{
+				{0, true, true},
+				{time.Minute, true, false},
+				{4*time.Minute + 59*time.Second, true, false},
+				{5 * time.Minute, true, true},
+				{6 * time.Minute, true, false},
+			},
+		},
+		{
+			name:   "cooldown outlasts re-arming",
+			policy: &AlertPolicy{Cooldown: "10m"},
+			steps: []step{
+				{0, true, true},
+				{time.Minute, false, false},
+				{2 * time.Minute, true, false},
+				{3 * time.Minute, true, false},
+				{11 * time.Minute, true, true},
+			},
+		},
+		{
+			name:   "rate limit",
+			policy: &AlertPolicy{Mode: AlertEvery, MaxAlerts: 2, Period: "1h"},
+			steps: []step{
+				{0, true, true},
+				{time.Minute, true, true},
+				{2 * time.Minute, true, false},
+				{59 * time.Minute, true, false},
+				{time.Hour, true, true},
+				{time.Hour + time.Minute, true, true},
+				{time.Hour + 2*time.Minute, true, false},
+			},
+		},
+		{
+			name:   "rate limit counts only sent alerts",
+			policy: &AlertPolicy{MaxAlerts: 2, Period: "1h"},
+			steps: []step{
+				{0, true, true},
+				{time.Minute, true, false}, // ONCE suppresses it before the rate limit
+				{2 * time.Minute, false, false},
+				{3 * time.Minute, true, true},
+				{4 * time.Minute, false, false},
+				{5 * time.Minute, true, false},
+			},
+		},
+	}
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCalculator(nil, nil)
+			job := alertJob(t, tt.policy)
+			for i, s := range tt.steps {
+				if got := c.ShouldAlert(job, "BTCUSDT", s.met, start.Add(s.at)); got != s.want {
+					t.Errorf("step %d (+%s, met %v): ShouldAlert = %v, want %v", i, s.at, s.met, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestShouldAlertPerSymbol(t *testing.T) {
+	c := NewCalculator(nil, nil)
+	job := alertJob(t, nil)
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	if !c.ShouldAlert(job, "BTCUSDT", true, now) {
+		t.Fatal("BTCUSDT did not alert")
+	}
+	if !c.ShouldAlert(job, "ETHUSDT", true, now) {
+		t.Error("ETHUSDT was suppressed by the BTCUSDT alert")
+	}
+	// Replacing the job re-arms it
+	c.resetPrevious(job.ID)
+	if !c.ShouldAlert(job, "BTCUSDT", true, now.Add(time.Second)) {
+		t.Error("BTCUSDT did not alert after a reset")
+	}
+}
+
+func TestEvaluatesOn(t *testing.T) {
+	open, closed := Kline{IsClosed: false}, Kline{IsClosed: true}
+	tests := []struct {
+		name       string
+		policy     *AlertPolicy
+		wantMode   string
+		wantOpen   bool
+		wantClosed bool
+	}{
+		{"default", nil, EvaluateIntrabar, true, true},
+		{"INTRABAR", &AlertPolicy{Evaluate: "intrabar"}, EvaluateIntrabar, true, true},
+		{"CLOSE", &AlertPolicy{Evaluate: EvaluateClose}, EvaluateClose, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := alertJob(t, tt.policy)
+			if got := job.EvaluationMode(); got != tt.wantMode {
+				t.Errorf("EvaluationMode = %q, want %q", got, tt.wantMode)
+			}
+			if got := job.EvaluatesOn(open); got != tt.wantOpen {
+				t.Errorf("EvaluatesOn(open kline) = %v, want %v", got, tt.wantOpen)
+			}
+			if got := job.EvaluatesOn(closed); got != tt.wantClosed {
+				t.Errorf("EvaluatesOn(closed kline) = %v, want %v", got, tt.wantClosed)
+			}
+		})
+	}
+}
+
+func TestCompileAlertPolicyErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  AlertPolicy
+		wantErr string
+	}{
+		{"unknown mode", AlertPolicy{Mode: "TWICE"}, `alert.mode: unknown alert mode "TWICE"`},
+		{"unknown evaluation", AlertPolicy{Evaluate: "OPEN"}, `alert.evaluate: unknown evaluation "OPEN"`},
+		{"bad cooldown", AlertPolicy{Cooldown: "soon"}, `alert.cooldown: invalid duration "soon"`},
+		{"negative cooldown", AlertPolicy{Cooldown: "-1m"}, `alert.cooldown: invalid duration "-1m"`},
+		{"zero period", AlertPolicy{MaxAlerts: 1, Period: "0s"}, `alert.period: invalid duration "0s"`},
+		{"maxAlerts without period", AlertPolicy{MaxAlerts: 3}, "alert.period: is required with maxAlerts"},
+		{"period without maxAlerts", AlertPolicy{Period: "1h"}, "alert.maxAlerts: is required with period"},
+		{"negative maxAlerts", AlertPolicy{MaxAlerts: -1, Period: "1h"}, "alert.maxAlerts: must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var errs fieldErrors
+			compileAlertPolicy(&tt.policy, &errs)
+			if err := errs.err(); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("compileAlertPolicy error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,17 +1,20 @@
 package calculator
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
-	"strconv"
+	"math"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
-	binance "github.com/adshao/go-binance/v2/futures"
-	talib "github.com/markcheno/go-talib"
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/events"
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/exchange"
 	kafka "github.com/segmentio/kafka-go"
 )
 
@@ -21,130 +24,222 @@ type AnalysisRequest struct {
 		Symbol   string `json:"symbol"`
 		Interval string `json:"interval"`
 		Exchange string `json:"exchange"`
+		Market   string `json:"market,omitempty"` // spot, usdm (default) or coinm
 	} `json:"websocketKlineOptions"`
 	Indicators []struct {
-		Indicator  string                 `json:"indicator"`
+		Indicator  string                 `json:"indicator"` // e.g. "RSI" or "MACD.histogram"
 		Parameters map[string]interface{} `json:"parameters"`
 		Operator   string                 `json:"operator"`
 		Threshold  float64                `json:"threshold"`
 	} `json:"indicators"`
+	Conditions *Condition   `json:"conditions,omitempty"`
+	Alert      *AlertPolicy `json:"alert,omitempty"`
+	Screener   *Screener    `json:"screener,omitempty"`
+	Owner      string       `json:"owner,omitempty"` // user the gateway created the job for
 }
 
+const (
+	// defaultWindowSize is the number of historical klines kept per symbol.
+	defaultWindowSize = 100
+	// maxWindowSize is the largest window a job may need.
+	maxWindowSize = 1500
+)
+
 // Kline is a simplified OHLCV struct.
 type Kline struct {
-	OpenTime  time.Time
-	Open      float64
-	High      float64
-	Low       float64
-	Close     float64
-	Volume    float64
-	CloseTime time.Time
-	IsClosed  bool
+	OpenTime  time.Time `json:"openTime"`
+	Open      float64   `json:"open"`
+	High      float64   `json:"high"`
+	Low       float64   `json:"low"`
+	Close     float64   `json:"close"`
+	Volume    float64   `json:"volume"`
+	CloseTime time.Time `json:"closeTime"`
+	IsClosed  bool      `json:"isClosed"`
 }
 
-// Job holds sliding windows and indicator configs per symbol.
+// Job holds the indicator configs and the symbols a job watches.
+// Conditions combines the results of Indicators into the alert decision;
+// rules decide whether a met decision is published. Screener jobs rank
+// their symbols instead; their Conditions, if any, only filter them.
 type Job struct {
+	ID         string
+	Request    AnalysisRequest
+	Exchange   string // name of the exchange adapter the job's klines come from
+	Market     string // market type on the exchange
 	Interval   string
 	Symbols    []string
 	Indicators []IndicatorConfig
-	Windows    map[string][]Kline
+	Conditions *ConditionNode
+	WindowSize int
+	Offset     int64 // analysis.request offset of the command that last changed the job
+	rules      alertRules
+	screener   *screenerRules
 }
 
-// IndicatorConfig holds what to compute and when to alert.
+// IndicatorConfig holds what to compute and when to alert: the Left
+// operand is compared against the Right one using Operator. ID is the
+// leaf's path in the condition tree.
 type IndicatorConfig struct {
-	Name      string
-	Operator  string
-	Threshold float64
-	Params    map[string]interface{}
+	ID       string
+	Name     string
+	Operator string
+	Left     Operand
+	Right    Operand
+}
+
+// StateKeys returns the previous-value keys of both operands. They are
+// unique per leaf, so the same indicator used in two leaves of a job
+// tracks its crossings independently.
+func (cfg IndicatorConfig) StateKeys() (left, right string) {
+	return cfg.ID + "/left/" + cfg.Left.Key(), cfg.ID + "/right/" + cfg.Right.Key()
 }
 
-// Calculator keeps all active jobs.
+// Calculator keeps all active jobs and the kline windows they share.
+// Every instance knows every job, but windows and previous values are only
+// kept for the symbols whose kline.raw partitions this instance owns.
 type Calculator struct {
-	mu         sync.Mutex
-	jobs       map[string]*Job
-	prevValues map[string]map[string]float64
-	client     *binance.Client
-	writer     *kafka.Writer
+	ctrlMu  sync.Mutex                        // serializes job changes from control commands and symbol events
+	backlog map[int]map[string]pendingCommand // partition -> job ID -> latest command, guarded by ctrlMu
+	mu      sync.Mutex
+	jobs    map[string]*Job                // job ID -> job
+	subs    map[string]map[string]struct{} // windowKey -> job IDs
+	windows map[string][]Kline             // windowKey -> window
+	owns    func(symbol string) bool       // owns nothing until the first Rebalance
+
+	prevMu     sync.Mutex
+	prevValues map[string]map[string]map[string]float64 // job ID -> symbol -> state key -> value
+
+	// Changes since the last flush to the state store
+	dirtyWindows map[string]struct{}            // guarded by mu
+	dirtyPrev    map[string]map[string]struct{} // job ID -> symbols, guarded by prevMu
+	prevResets   map[string]struct{}            // guarded by prevMu
+
+	// Screener rounds of the latest candle, and the kline.raw partitions
+	// the symbols they rank come from
+	screenMu    sync.Mutex
+	rounds      map[string]*screenerRound // job ID -> round
+	partitions  []int                     // guarded by mu
+	partitionOf func(symbol string) int   // guarded by mu
+
+	writer         *kafka.Writer
+	qualityWriter  *kafka.Writer // nil: data quality events are only logged
+	screenerWriter *kafka.Writer // nil: screener snapshots are only logged
+	store          StateStore
 }
 
-// NewCalculator returns a Calculator that will publish alerts.
-func NewCalculator(writer *kafka.Writer) *Calculator {
+// NewCalculator returns a Calculator that will publish alerts and persist
+// its state to store. A nil store keeps everything in memory.
+func NewCalculator(writer *kafka.Writer, store StateStore) *Calculator {
 	return &Calculator{
-		jobs:       make(map[string]*Job),
-		prevValues: make(map[string]map[string]float64),
-		client:     binance.NewClient("", ""),
-		writer:     writer,
+		backlog:      make(map[int]map[string]pendingCommand),
+		jobs:         make(map[string]*Job),
+		subs:         make(map[string]map[string]struct{}),
+		windows:      make(map[string][]Kline),
+		owns:         func(string) bool { return false },
+		prevValues:   make(map[string]map[string]map[string]float64),
+		dirtyWindows: make(map[string]struct{}),
+		dirtyPrev:    make(map[string]map[string]struct{}),
+		prevResets:   make(map[string]struct{}),
+		rounds:       make(map[string]*screenerRound),
+		writer:       writer,
+		store:        store,
 	}
 }
 
 // Writer returns the Kafka writer for alerts.
 func (c *Calculator) Writer() *kafka.Writer { return c.writer }
 
+// SetQualityWriter sets the Kafka writer for data quality events.
+func (c *Calculator) SetQualityWriter(w *kafka.Writer) { c.qualityWriter = w }
+
+// QualityWriter returns the Kafka writer for data quality events, or nil.
+func (c *Calculator) QualityWriter() *kafka.Writer { return c.qualityWriter }
+
 // Mutex returns pointer to internal mutex for safe access.
 func (c *Calculator) Mutex() *sync.Mutex { return &c.mu }
 
-// Jobs returns the map of active jobs.
+// Jobs returns the map of active jobs keyed by job ID.
 func (c *Calculator) Jobs() map[string]*Job { return c.jobs }
 
-// GetPrevious retrieves the last computed value for a symbol and indicator.
-func (c *Calculator) GetPrevious(symbol, name string) float64 {
-	if m, ok := c.prevValues[symbol]; ok {
-		return m[name]
+// GetPrevious retrieves the last value a job computed for a state key on a
+// symbol. It returns NaN when nothing has been stored yet.
+func (c *Calculator) GetPrevious(jobID, symbol, key string) float64 {
+	c.prevMu.Lock()
+	defer c.prevMu.Unlock()
+	if v, ok := c.prevValues[jobID][symbol][key]; ok {
+		return v
 	}
-	return 0
+	return math.NaN()
 }
 
 // SetPrevious stores the computed value for next comparison.
-func (c *Calculator) SetPrevious(symbol, name string, value float64) {
-	if _, ok := c.prevValues[symbol]; !ok {
-		c.prevValues[symbol] = make(map[string]float64)
+func (c *Calculator) SetPrevious(jobID, symbol, key string, value float64) {
+	c.prevMu.Lock()
+	defer c.prevMu.Unlock()
+	if _, ok := c.prevValues[jobID]; !ok {
+		c.prevValues[jobID] = make(map[string]map[string]float64)
+	}
+	if _, ok := c.prevValues[jobID][symbol]; !ok {
+		c.prevValues[jobID][symbol] = make(map[string]float64)
+	}
+	c.prevValues[jobID][symbol][key] = value
+	if _, ok := c.dirtyPrev[jobID]; !ok {
+		c.dirtyPrev[jobID] = make(map[string]struct{})
 	}
-	c.prevValues[symbol][name] = value
+	c.dirtyPrev[jobID][symbol] = struct{}{}
+}
+
+// resetPrevious drops all previous values of a job, here and in the store.
+func (c *Calculator) resetPrevious(jobID string) {
+	c.prevMu.Lock()
+	defer c.prevMu.Unlock()
+	delete(c.prevValues, jobID)
+	delete(c.dirtyPrev, jobID)
+	c.prevResets[jobID] = struct{}{}
+}
+
+// dropPrevious drops a job's previous values and alert state on one
+// symbol, here and in the store.
+func (c *Calculator) dropPrevious(jobID, symbol string) {
+	c.prevMu.Lock()
+	defer c.prevMu.Unlock()
+	delete(c.prevValues[jobID], symbol)
+	if _, ok := c.dirtyPrev[jobID]; !ok {
+		c.dirtyPrev[jobID] = make(map[string]struct{})
+	}
+	c.dirtyPrev[jobID][symbol] = struct{}{}
 }
 
 // CalculateIndicator computes the specified indicator on the sliding window.
-func CalculateIndicator(window []Kline, name, symbol, interval string, params map[string]interface{}) float64 {
-	data := make([]float64, len(window))
-	for i, k := range window {
-		data[i] = k.Close
-	}
-	switch strings.ToUpper(name) {
-	case "RSI":
-		if p, ok := params["period"].(float64); ok {
-			vals := talib.Rsi(data, int(p))
-			if len(vals) > 0 {
-				return vals[len(vals)-1]
-			}
-		}
-	case "EMA":
-		if p, ok := params["period"].(float64); ok {
-			vals := talib.Ema(data, int(p))
-			if len(vals) > 0 {
-				return vals[len(vals)-1]
-			}
-		}
-		// Extend with additional indicators as needed.
+// The name may select an output with a dot suffix, e.g. "MACD.histogram" or
+// "BBANDS.lower"; without one the indicator's primary output is returned.
+func CalculateIndicator(window []Kline, name, symbol, interval string, params map[string]interface{}) (float64, error) {
+	def, output, err := resolveIndicator(name)
+	if err != nil {
+		return 0, err
+	}
+	p, err := resolveParams(def, params)
+	if err != nil {
+		return 0, err
+	}
+	if need := def.Lookback(p); len(window) < need {
+		return 0, fmt.Errorf("%s for %s:%s: %w (have %d, need %d)", name, symbol, interval, ErrNotEnoughData, len(window), need)
+	}
+	vals, err := def.Compute(newSeries(window), p)
+	if err != nil {
+		return 0, fmt.Errorf("%s for %s:%s: %w", name, symbol, interval, err)
+	}
+	val, ok := vals[output]
+	if !ok || math.IsNaN(val) || math.IsInf(val, 0) {
+		return 0, fmt.Errorf("%s for %s:%s: no value for output %q", name, symbol, interval, output)
 	}
-	return 0
+	return val, nil
 }
 
-// EvaluateAlert applies the operator to current and previous values.
+// EvaluateAlert applies the operator to current and previous values
+// against a fixed threshold.
 func EvaluateAlert(val, threshold float64, operator string, prev float64) (bool, error) {
-	op := strings.ToUpper(operator)
-	switch op {
-	case "GREATER THAN":
-		return val > threshold, nil
-	case "LESS THAN":
-		return val < threshold, nil
-	case "CROSSING":
-		return (prev < threshold && val >= threshold) || (prev > threshold && val <= threshold), nil
-	case "CROSSING UP":
-		return prev < threshold && val >= threshold, nil
-	case "CROSSING DOWN":
-		return prev > threshold && val <= threshold, nil
-	default:
-		return false, errors.New("unknown operator: " + operator)
-	}
+	return EvaluateComparison(val, threshold, prev, threshold, operator)
 }
 
 // ParseFloat safely converts json.Number to float64.
@@ -153,88 +248,279 @@ func ParseFloat(n json.Number) float64 {
 	return f
 }
 
-// HandleControl parses the control message and initializes a Job.
-func (c *Calculator) HandleControl(ctx context.Context, raw []byte) {
-	log.Printf("[HandleControl] HandleControl just STARTED!!!")
-	var req AnalysisRequest
-	if err := json.Unmarshal(raw, &req); err != nil {
+// pendingCommand is a control command read behind the end of its
+// partition and not applied yet.
+type pendingCommand struct {
+	cmd    ControlCommand
+	offset int64
+}
+
+// HandleControl parses a control message read from analysis.request and
+// creates, updates or deletes a Job. A new control group replays the topic
+// from the start, so commands behind the end of their partition are folded
+// to the latest one per job and applied once the partition's last message
+// is read: a deleted job is not re-created and its windows are not
+// fetched, and a job updated many times is installed once. It reports
+// whether the partition is applied up to m, that is whether m may be
+// committed.
+func (c *Calculator) HandleControl(ctx context.Context, m kafka.Message) bool {
+	cmd, err := ParseControlCommand(m.Value)
+	if err != nil {
 		log.Printf("Invalid control payload: %v", err)
-		return
 	}
-	key := req.WebsocketKlineOptions.Symbol + ":" + req.WebsocketKlineOptions.Interval
+	c.ctrlMu.Lock()
+	defer c.ctrlMu.Unlock()
 
-	// Determine symbols list
-	syms := []string{req.WebsocketKlineOptions.Symbol}
-	if req.WebsocketKlineOptions.Symbol == "ALL" {
-		info, err := c.client.NewExchangeInfoService().Do(ctx)
-		if err != nil {
-			log.Printf("ExchangeInfo error: %v", err)
-			return
-		}
-		syms = []string{}
-		for _, si := range info.Symbols {
-			if si.QuoteAsset == "USDT" && si.Status == "TRADING" {
-				syms = append(syms, si.Symbol)
-			}
+	pending := c.backlog[m.Partition]
+	if err == nil {
+		if pending == nil {
+			pending = make(map[string]pendingCommand)
+			c.backlog[m.Partition] = pending
 		}
+		pending[cmd.JobID] = pendingCommand{cmd: cmd, offset: m.Offset}
+	}
+	if m.Offset+1 < m.HighWaterMark {
+		return false
 	}
+	delete(c.backlog, m.Partition)
 
-	// Fetch historical klines and init windows
-	windows := make(map[string][]Kline)
-	for _, sym := range syms {
-		ks, err := c.client.NewKlinesService().
-			Symbol(sym).
-			Interval(req.WebsocketKlineOptions.Interval).
-			Limit(100).
-			Do(ctx)
-		if err != nil {
-			log.Printf("Hist fetch error for %s: %v", sym, err)
-			continue
+	cmds := make([]pendingCommand, 0, len(pending))
+	for _, p := range pending {
+		cmds = append(cmds, p)
+	}
+	slices.SortFunc(cmds, func(a, b pendingCommand) int { return cmp.Compare(a.offset, b.offset) })
+	if len(cmds) > 1 {
+		log.Printf("[HandleControl] applying the latest commands of %d jobs up to offset %d", len(cmds), m.Offset)
+	}
+	for _, p := range cmds {
+		switch p.cmd.Type {
+		case CommandCreate, CommandUpdate:
+			c.registerJob(ctx, p.cmd.JobID, *p.cmd.Request, p.offset)
+		case CommandDelete:
+			c.deleteJob(p.cmd.JobID, p.offset)
 		}
+	}
+	return true
+}
+
+// deleteJob stops the job with the given ID unless the job is newer than
+// the command.
+func (c *Calculator) deleteJob(id string, offset int64) {
+	c.mu.Lock()
+	job, ok := c.jobs[id]
+	if ok && offset <= job.Offset {
+		c.mu.Unlock()
+		log.Printf("[HandleControl] job %s is at offset %d, skipping delete at %d", id, job.Offset, offset)
+		return
+	}
+	if ok {
+		delete(c.jobs, id)
+		c.unsubscribe(job)
+		c.dropUnusedWindows(job)
+	}
+	c.mu.Unlock()
+	if !ok {
+		log.Printf("[HandleControl] delete for unknown job %s", id)
+		return
+	}
+	c.resetPrevious(id)
+	c.dropScreenerRound(id)
+	c.removeJob(id)
+	log.Printf("[HandleControl] job %s deleted", id)
+}
 
-		// Log historical fetch
-		log.Printf("[HandleControl] fetched %d historical klines for %s:%s", len(ks), sym, req.WebsocketKlineOptions.Interval)
-
-		arr := make([]Kline, len(ks))
-		for i, b := range ks {
-			arr[i] = Kline{
-				OpenTime:  time.UnixMilli(b.OpenTime),
-				Open:      atof(b.Open),
-				High:      atof(b.High),
-				Low:       atof(b.Low),
-				Close:     atof(b.Close),
-				Volume:    atof(b.Volume),
-				CloseTime: time.UnixMilli(b.CloseTime),
-			}
+// registerJob installs the Job described by the request and persists its
+// definition. Instances replay analysis.request from the start when their
+// control group is new, so a command at or below the offset of the job as
+// restored from the state store is ignored rather than resetting the
+// crossing state it was restored with. The gateway keys commands by job ID,
+// which keeps a job's commands in one partition and their offsets ordered.
+func (c *Calculator) registerJob(ctx context.Context, id string, req AnalysisRequest, offset int64) {
+	c.mu.Lock()
+	old, ok := c.jobs[id]
+	c.mu.Unlock()
+	if ok && offset <= old.Offset {
+		log.Printf("[HandleControl] job %s is at offset %d, skipping command at %d", id, old.Offset, offset)
+		return
+	}
+	rec := JobRecord{ID: id, Request: req, Offset: offset}
+	if err := c.installJob(ctx, &rec); err != nil {
+		log.Printf("[HandleControl] rejecting job %s: %v", id, err)
+		return
+	}
+	// A rejected command leaves the running job and its previous values
+	// alone; only a replaced job starts over
+	c.resetPrevious(id)
+	c.saveJob(ctx, rec)
+	log.Printf("[HandleControl] job %s registered for %s %s %s:%s with %d symbols", id, req.WebsocketKlineOptions.Exchange, req.WebsocketKlineOptions.Market, req.WebsocketKlineOptions.Symbol, req.WebsocketKlineOptions.Interval, len(rec.Symbols))
+}
+
+// CompileJob compiles an analysis request into a Job watching the given
+// symbols, rejecting unsupported exchanges, intervals, indicators,
+// operators, alert policies and screeners.
+func CompileJob(id string, req AnalysisRequest, symbols []string) (*Job, error) {
+	var errs fieldErrors
+	opts := req.WebsocketKlineOptions
+	ex, err := exchange.Get(opts.Exchange, opts.Market)
+	switch {
+	case errors.Is(err, exchange.ErrUnknownMarket):
+		errs.add("websocketKlineOptions.market", "unsupported market %q (supported: %s)", opts.Market, strings.Join(marketNames(opts.Exchange), ", "))
+	case err != nil:
+		errs.add("websocketKlineOptions.exchange", "unsupported exchange %q (supported: %s)", opts.Exchange, strings.Join(exchange.Names(), ", "))
+	case opts.Interval == "":
+		errs.add("websocketKlineOptions.interval", "is required")
+	case !slices.Contains(ex.Intervals(), opts.Interval):
+		errs.add("websocketKlineOptions.interval", "unsupported interval %q on %s (supported: %s)", opts.Interval, ex.Name(), strings.Join(ex.Intervals(), ", "))
+	}
+	if opts.Symbol == "" && len(symbols) == 0 {
+		errs.add("websocketKlineOptions.symbol", "is required")
+	}
+	screener := compileScreener(req.Screener, &errs)
+	if screener != nil && req.Alert != nil {
+		errs.add("alert", "screeners publish snapshots, not alerts")
+	}
+	// Compile the condition tree; this also rejects unsupported indicators
+	// and operators up front instead of letting them evaluate to 0.
+	// Screeners don't need conditions
+	var tree *ConditionNode
+	var cfgs []IndicatorConfig
+	if screener == nil || len(req.Indicators) > 0 || req.Conditions != nil {
+		tree, cfgs = buildConditionTree(req, &errs)
+	}
+	rules := compileAlertPolicy(req.Alert, &errs)
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+
+	windowSize := defaultWindowSize
+	for _, cfg := range cfgs {
+		windowSize = max(windowSize, cfg.Left.Lookback()+1, cfg.Right.Lookback()+1)
+	}
+	if screener != nil {
+		windowSize = max(windowSize, screener.rank.Lookback()+1)
+	}
+	if windowSize > maxWindowSize {
+		return nil, fieldErrors{{Message: fmt.Sprintf("indicators need %d klines, max is %d", windowSize, maxWindowSize)}}
+	}
+	return &Job{
+		ID:         id,
+		Request:    req,
+		Exchange:   ex.Name(),
+		Market:     ex.Market(),
+		Interval:   opts.Interval,
+		Symbols:    symbols,
+		Indicators: cfgs,
+		Conditions: tree,
+		WindowSize: windowSize,
+		rules:      rules,
+		screener:   screener,
+	}, nil
+}
+
+// installJob compiles the job, resolves its symbols unless the record
+// already lists them, makes sure every symbol has a window and installs
+// the Job in place of any job with the same ID.
+func (c *Calculator) installJob(ctx context.Context, rec *JobRecord) error {
+	job, err := CompileJob(rec.ID, rec.Request, rec.Symbols)
+	if err != nil {
+		return err
+	}
+
+	// Determine symbols list
+	if len(rec.Symbols) == 0 {
+		syms, err := c.resolveSymbols(ctx, job.Exchange, job.Market, rec.Request.WebsocketKlineOptions.Symbol)
+		if err != nil {
+			return err
 		}
-		windows[sym] = arr
+		rec.Symbols = syms
+		job.Symbols = syms
 	}
+	job.Offset = rec.Offset
 
-	// Build indicator configs
-	cfgs := make([]IndicatorConfig, len(req.Indicators))
-	for i, ind := range req.Indicators {
-		cfgs[i] = IndicatorConfig{
-			Name:      ind.Indicator,
-			Operator:  ind.Operator,
-			Threshold: ind.Threshold,
-			Params:    ind.Parameters,
+	// Load windows of owned symbols without a long enough one; windows are
+	// shared by every job on the same exchange, market, symbol and interval
+	for _, sym := range job.Symbols {
+		if c.ownsSymbol(sym) {
+			c.loadWindow(ctx, job.Exchange, job.Market, sym, job.Interval, job.WindowSize)
 		}
 	}
 
 	c.mu.Lock()
-	c.jobs[key] = &Job{
-		Interval:   req.WebsocketKlineOptions.Interval,
-		Symbols:    syms,
-		Indicators: cfgs,
-		Windows:    windows,
+	old, replaced := c.jobs[rec.ID]
+	if replaced {
+		c.unsubscribe(old)
+	}
+	c.jobs[rec.ID] = job
+	c.subscribe(job)
+	if replaced {
+		c.dropUnusedWindows(old)
 	}
 	c.mu.Unlock()
-	log.Printf("[HandleControl] job registered for key %s with %d symbols", key, len(syms))
+	return nil
 }
 
-// ... rest of the code ...
+// resolveSymbols expands "ALL" into every symbol trading on the exchange's
+// market.
+func (c *Calculator) resolveSymbols(ctx context.Context, exchangeName, market, symbol string) ([]string, error) {
+	if symbol != "ALL" {
+		return []string{symbol}, nil
+	}
+	ex, err := exchange.Get(exchangeName, market)
+	if err != nil {
+		return nil, err
+	}
+	return ex.Symbols(ctx)
+}
 
-func atof(s string) float64 {
-	f, _ := strconv.ParseFloat(s, 64)
-	return f
+// FetchLatest loads the latest limit klines of a symbol from the
+// exchange's REST API.
+func (c *Calculator) FetchLatest(ctx context.Context, exchangeName, market, sym, interval string, limit int) ([]Kline, error) {
+	ex, err := exchange.Get(exchangeName, market)
+	if err != nil {
+		return nil, err
+	}
+	ks, err := exchange.Latest(ctx, ex, sym, interval, limit)
+	if err != nil {
+		return nil, err
+	}
+	return toKlines(ks), nil
+}
+
+// FetchRange loads every kline of a symbol opening in [from, to) from the
+// exchange's REST API.
+func (c *Calculator) FetchRange(ctx context.Context, exchangeName, market, sym, interval string, from, to time.Time) ([]Kline, error) {
+	ex, err := exchange.Get(exchangeName, market)
+	if err != nil {
+		return nil, err
+	}
+	ks, err := exchange.Range(ctx, ex, sym, interval, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return toKlines(ks), nil
 }
+
+func toKlines(ks []events.KlineEvent) []Kline {
+	arr := make([]Kline, len(ks))
+	for i, k := range ks {
+		arr[i] = Kline{
+			OpenTime:  k.OpenTime,
+			Open:      k.Open,
+			High:      k.High,
+			Low:       k.Low,
+			Close:     k.Close,
+			Volume:    k.Volume,
+			CloseTime: k.CloseTime,
+			IsClosed:  k.Closed,
+		}
+	}
+	return arr
+}
+
+// IntervalDuration returns the length of a kline interval such as "1m",
+// "4h" or "1w". Months are counted as 30 days.
+func IntervalDuration(interval string) (time.Duration, error) {
+	return exchange.IntervalDuration(interval)
+}
+
+// ... rest of the code ...
@@ -0,0 +1,101 @@
+package calculator
+
+import (
+	"sort"
+
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/events"
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/exchange"
+)
+
+// Catalog describes everything an analysis request may refer to. It is
+// built from the same tables requests are compiled against, so UIs and
+// validators generated from it can't drift from calc-service.
+type Catalog struct {
+	Indicators       []IndicatorInfo `json:"indicators"`
+	Operators        []string        `json:"operators"`
+	Fields           []string        `json:"fields"`         // raw kline fields operands can reference
+	ConditionTypes   []string        `json:"conditionTypes"` // condition group types
+	AlertModes       []string        `json:"alertModes"`
+	Evaluations      []string        `json:"evaluations"`
+	Exchanges        []ExchangeInfo  `json:"exchanges"`
+	MaxWindowSize    int             `json:"maxWindowSize"` // most klines a job's indicators may need
+	ScreenerOrders   []string        `json:"screenerOrders"`
+	MaxScreenerLimit int             `json:"maxScreenerLimit"`
+}
+
+// IndicatorInfo describes one indicator. It is selected by Name or one of
+// its Aliases, optionally followed by ".output"; without an output the
+// first of Outputs is used.
+type IndicatorInfo struct {
+	Name       string      `json:"name"`
+	Aliases    []string    `json:"aliases,omitempty"`
+	Parameters []ParamSpec `json:"parameters"`
+	Outputs    []string    `json:"outputs"`
+	// Lookback is the number of klines the indicator needs with its default
+	// parameters; it grows with its periods.
+	Lookback int `json:"lookback"`
+}
+
+// ExchangeInfo lists the markets of an exchange.
+type ExchangeInfo struct {
+	Name    string       `json:"name"`
+	Markets []MarketInfo `json:"markets"`
+}
+
+// MarketInfo lists the kline intervals of an exchange market.
+type MarketInfo struct {
+	Name      string   `json:"name"`
+	Intervals []string `json:"intervals"`
+}
+
+// GetCatalog returns the catalogue of supported indicators, operators,
+// kline fields, exchanges, markets, intervals and screener options.
+func GetCatalog() Catalog {
+	aliases := make(map[string][]string)
+	for alias, name := range indicatorAliases {
+		aliases[name] = append(aliases[name], alias)
+	}
+	names := make([]string, 0, len(indicators))
+	for name := range indicators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	c := Catalog{
+		Operators:        Operators,
+		Fields:           fieldNames(),
+		ConditionTypes:   []string{ConditionAnd, ConditionOr, ConditionNot},
+		AlertModes:       []string{AlertOnce, AlertEvery},
+		Evaluations:      []string{EvaluateIntrabar, EvaluateClose},
+		MaxWindowSize:    maxWindowSize,
+		ScreenerOrders:   []string{events.ScreenerDesc, events.ScreenerAsc},
+		MaxScreenerLimit: maxScreenerLimit,
+	}
+	for _, name := range names {
+		def := indicators[name]
+		p := make(paramSet, len(def.Params))
+		for _, spec := range def.Params {
+			p[spec.Name] = spec.Default
+		}
+		sort.Strings(aliases[name])
+		c.Indicators = append(c.Indicators, IndicatorInfo{
+			Name:       name,
+			Aliases:    aliases[name],
+			Parameters: append([]ParamSpec{}, def.Params...),
+			Outputs:    def.Outputs,
+			Lookback:   def.Lookback(p),
+		})
+	}
+	for _, name := range exchange.Names() {
+		info := ExchangeInfo{Name: name}
+		for _, market := range events.Markets {
+			ex, err := exchange.Get(name, market)
+			if err != nil {
+				continue
+			}
+			info.Markets = append(info.Markets, MarketInfo{Name: market, Intervals: ex.Intervals()})
+		}
+		c.Exchanges = append(c.Exchanges, info)
+	}
+	return c
+}
@@ -0,0 +1,216 @@
+package calculator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Condition group types.
+const (
+	ConditionAnd = "AND"
+	ConditionOr  = "OR"
+	ConditionNot = "NOT"
+)
+
+// maxConditionDepth bounds the nesting of condition groups.
+const maxConditionDepth = 8
+
+// Condition is a node of the boolean condition tree in an AnalysisRequest.
+// Groups set Type to AND, OR or NOT and list their children in Conditions;
+// leaves leave Type empty and compare Left against Right with Operator.
+// A leaf may instead use the same fields as an entry of
+// AnalysisRequest.Indicators: Indicator/Parameters stand in for Left and
+// Threshold for Right.
+type Condition struct {
+	Type       string                 `json:"type,omitempty"`
+	Conditions []Condition            `json:"conditions,omitempty"`
+	Left       *Operand               `json:"left,omitempty"`
+	Right      *Operand               `json:"right,omitempty"`
+	Indicator  string                 `json:"indicator,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	Operator   string                 `json:"operator,omitempty"`
+	Threshold  float64                `json:"threshold"`
+}
+
+// ConditionNode is a compiled condition tree node. Leaves refer to the
+// indicator config at index Leaf in Job.Indicators.
+type ConditionNode struct {
+	Path     string
+	Type     string
+	Children []*ConditionNode
+	Leaf     int
+}
+
+// LeafResult is the outcome of evaluating a single indicator comparison.
+// Values that couldn't be computed, or have no previous value yet, are NaN.
+type LeafResult struct {
+	Met                 bool
+	Detail              string
+	Left, Right         float64
+	PrevLeft, PrevRight float64
+	Err                 error
+}
+
+// ConditionResult reports how each node of a condition tree evaluated.
+type ConditionResult struct {
+	Path     string            `json:"path"`
+	Type     string            `json:"type"`
+	Met      bool              `json:"met"`
+	Detail   string            `json:"detail,omitempty"`
+	Children []ConditionResult `json:"children,omitempty"`
+}
+
+// BuildConditionTree compiles the request's conditions into a tree whose
+// leaves index into the returned indicator configs. The flat Indicators
+// list is kept for compatibility and is treated as an implicit AND group;
+// when both are given they are ANDed together.
+func BuildConditionTree(req AnalysisRequest) (*ConditionNode, []IndicatorConfig, error) {
+	var errs fieldErrors
+	node, cfgs := buildConditionTree(req, &errs)
+	if err := errs.err(); err != nil {
+		return nil, nil, err
+	}
+	return node, cfgs, nil
+}
+
+// buildConditionTree is BuildConditionTree adding its problems to errs.
+// Nodes are numbered from the root "0" whatever the request's JSON layout;
+// problems are reported under the JSON path of their field.
+func buildConditionTree(req AnalysisRequest, errs *fieldErrors) (*ConditionNode, []IndicatorConfig) {
+	var cfgs []IndicatorConfig
+	if len(req.Indicators) == 0 {
+		if req.Conditions == nil {
+			errs.add("indicators", "either indicators or conditions is required")
+			return nil, nil
+		}
+		return compileCondition(*req.Conditions, "conditions", "0", 0, &cfgs, errs), cfgs
+	}
+	root := &ConditionNode{Path: "0", Type: ConditionAnd, Leaf: -1}
+	for i, ind := range req.Indicators {
+		leaf := Condition{
+			Indicator:  ind.Indicator,
+			Parameters: ind.Parameters,
+			Operator:   ind.Operator,
+			Threshold:  ind.Threshold,
+		}
+		root.Children = append(root.Children, compileCondition(leaf, fmt.Sprintf("indicators[%d]", i), "0."+strconv.Itoa(i), 1, &cfgs, errs))
+	}
+	if req.Conditions != nil {
+		path := "0." + strconv.Itoa(len(req.Indicators))
+		root.Children = append(root.Children, compileCondition(*req.Conditions, "conditions", path, 1, &cfgs, errs))
+	}
+	return root, cfgs
+}
+
+// compileCondition compiles the node at path of the tree, whose JSON path
+// is field, and its children.
+func compileCondition(c Condition, field, path string, depth int, cfgs *[]IndicatorConfig, errs *fieldErrors) *ConditionNode {
+	if depth > maxConditionDepth {
+		errs.add(field, "nesting deeper than %d", maxConditionDepth)
+		return nil
+	}
+	typ := strings.ToUpper(strings.TrimSpace(c.Type))
+	switch typ {
+	case "":
+		cfg, ok := compileLeaf(c, field, errs)
+		if !ok {
+			return nil
+		}
+		cfg.ID = path
+		*cfgs = append(*cfgs, cfg)
+		return &ConditionNode{Path: path, Leaf: len(*cfgs) - 1}
+	case ConditionAnd, ConditionOr:
+		if len(c.Conditions) == 0 {
+			errs.add(field+".conditions", "%s group needs at least one child", typ)
+		}
+	case ConditionNot:
+		if len(c.Conditions) != 1 {
+			errs.add(field+".conditions", "NOT group needs exactly one child")
+		}
+	default:
+		errs.add(field+".type", "unknown group type %q (supported: %s, %s, %s)", c.Type, ConditionAnd, ConditionOr, ConditionNot)
+		return nil
+	}
+
+	node := &ConditionNode{Path: path, Type: typ, Leaf: -1}
+	for i, child := range c.Conditions {
+		childField := fmt.Sprintf("%s.conditions[%d]", field, i)
+		node.Children = append(node.Children, compileCondition(child, childField, path+"."+strconv.Itoa(i), depth+1, cfgs, errs))
+	}
+	return node
+}
+
+// compileLeaf compiles a comparison; without Left its Indicator and
+// Parameters are the left operand, without Right its Threshold is the
+// right one. It reports false if the leaf has problems.
+func compileLeaf(c Condition, field string, errs *fieldErrors) (IndicatorConfig, bool) {
+	n := len(*errs)
+	if c.Operator == "" {
+		errs.add(field+".operator", "is required")
+	} else if _, err := EvaluateComparison(0, 0, 0, 0, c.Operator); err != nil {
+		errs.add(field+".operator", "unsupported operator %q (supported: %s)", c.Operator, strings.Join(Operators, ", "))
+	}
+	left, leftField := Operand{Indicator: c.Indicator, Parameters: c.Parameters}, field
+	if c.Left != nil {
+		left, leftField = *c.Left, field+".left"
+	}
+	left.compile(leftField, errs)
+	right := Constant(c.Threshold)
+	if c.Right != nil {
+		right = *c.Right
+		right.compile(field+".right", errs)
+	}
+	if left.IsConstant() && right.IsConstant() {
+		errs.add(field, "comparing two constants")
+	}
+	if len(*errs) > n {
+		return IndicatorConfig{}, false
+	}
+	return IndicatorConfig{
+		Name:     left.Key() + " " + strings.ToUpper(c.Operator) + " " + right.Key(),
+		Operator: c.Operator,
+		Left:     left,
+		Right:    right,
+	}, true
+}
+
+// Evaluate resolves the tree against the per-leaf results, indexed like
+// Job.Indicators.
+func (n *ConditionNode) Evaluate(leaves []LeafResult) ConditionResult {
+	if n.Type == "" {
+		leaf := leaves[n.Leaf]
+		return ConditionResult{Path: n.Path, Type: "LEAF", Met: leaf.Met, Detail: leaf.Detail}
+	}
+
+	res := ConditionResult{Path: n.Path, Type: n.Type}
+	for _, child := range n.Children {
+		res.Children = append(res.Children, child.Evaluate(leaves))
+	}
+	switch n.Type {
+	case ConditionAnd:
+		res.Met = true
+		for _, c := range res.Children {
+			res.Met = res.Met && c.Met
+		}
+	case ConditionOr:
+		for _, c := range res.Children {
+			res.Met = res.Met || c.Met
+		}
+	case ConditionNot:
+		res.Met = !res.Children[0].Met
+	}
+	return res
+}
+
+// Satisfied lists the paths of every node in the result that was met.
+func (r ConditionResult) Satisfied() []string {
+	var paths []string
+	if r.Met {
+		paths = append(paths, r.Path)
+	}
+	for _, c := range r.Children {
+		paths = append(paths, c.Satisfied()...)
+	}
+	return paths
+}
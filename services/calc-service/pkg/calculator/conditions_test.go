@@ -0,0 +1,153 @@
+package calculator
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func request(t *testing.T, body string) AnalysisRequest {
+	t.Helper()
+	var req AnalysisRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestConditionTreeEvaluate(t *testing.T) {
+	const (
+		rsiLow  = `{"indicator":"RSI","operator":"LESS THAN","threshold":30}`
+		rsiHigh = `{"indicator":"RSI","operator":"GREATER THAN","threshold":70}`
+		cross   = `{"left":{"indicator":"EMA","parameters":{"period":9}},"operator":"CROSSING UP","right":{"indicator":"EMA","parameters":{"period":21}}}`
+	)
+	tests := []struct {
+		name      string
+		body      string
+		leaves    []bool // Met of each leaf, in indicator config order
+		wantNames []string
+		want      bool
+		satisfied []string
+	}{
+		{
+			name:      "flat indicators are ANDed",
+			body:      `{"indicators":[` + rsiLow + `,{"indicator":"MACD.histogram","operator":"GREATER THAN","threshold":0}]}`,
+			leaves:    []bool{true, false},
+			wantNames: []string{"RSI() LESS THAN 30", "MACD.histogram() GREATER THAN 0"},
+			want:      false,
+			satisfied: []string{"0.0"},
+		},
+		{
+			name:      "all flat indicators met",
+			body:      `{"indicators":[` + rsiLow + `,` + rsiHigh + `]}`,
+			leaves:    []bool{true, true},
+			want:      true,
+			satisfied: []string{"0", "0.0", "0.1"},
+		},
+		{
+			name:      "OR of leaves",
+			body:      `{"conditions":{"type":"OR","conditions":[` + rsiLow + `,` + rsiHigh + `]}}`,
+			leaves:    []bool{false, true},
+			want:      true,
+			satisfied: []string{"0", "0.1"},
+		},
+		{
+			name:      "OR of nothing met",
+			body:      `{"conditions":{"type":"or","conditions":[` + rsiLow + `,` + rsiHigh + `]}}`,
+			leaves:    []bool{false, false},
+			want:      false,
+			satisfied: nil,
+		},
+		{
+			name:      "NOT",
+			body:      `{"conditions":{"type":"NOT","conditions":[` + rsiHigh + `]}}`,
+			leaves:    []bool{false},
+			want:      true,
+			satisfied: []string{"0"},
+		},
+		{
+			name:      "nested AND of OR and NOT",
+			body:      `{"conditions":{"type":"AND","conditions":[{"type":"OR","conditions":[` + rsiLow + `,` + cross + `]},{"type":"NOT","conditions":[` + rsiHigh + `]}]}}`,
+			leaves:    []bool{false, true, false},
+			wantNames: []string{"RSI() LESS THAN 30", "EMA(period=9) CROSSING UP EMA(period=21)", "RSI() GREATER THAN 70"},
+			want:      true,
+			satisfied: []string{"0", "0.0", "0.0.1", "0.1"},
+		},
+		{
+			name:      "indicators ANDed with conditions",
+			body:      `{"indicators":[` + rsiLow + `],"conditions":{"type":"OR","conditions":[` + rsiHigh + `,` + cross + `]}}`,
+			leaves:    []bool{true, false, false},
+			want:      false,
+			satisfied: []string{"0.0"},
+		},
+		{
+			name:      "single leaf condition",
+			body:      `{"conditions":` + cross + `}`,
+			leaves:    []bool{true},
+			want:      true,
+			satisfied: []string{"0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree, cfgs, err := BuildConditionTree(request(t, tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cfgs) != len(tt.leaves) {
+				t.Fatalf("got %d indicator configs, want %d", len(cfgs), len(tt.leaves))
+			}
+			if tt.wantNames != nil {
+				var names []string
+				for _, cfg := range cfgs {
+					names = append(names, cfg.Name)
+				}
+				if !reflect.DeepEqual(names, tt.wantNames) {
+					t.Errorf("names = %q, want %q", names, tt.wantNames)
+				}
+			}
+			leaves := make([]LeafResult, len(tt.leaves))
+			for i, met := range tt.leaves {
+				leaves[i] = LeafResult{Met: met}
+			}
+			res := tree.Evaluate(leaves)
+			if res.Met != tt.want {
+				t.Errorf("Met = %v, want %v", res.Met, tt.want)
+			}
+			if got := res.Satisfied(); !reflect.DeepEqual(got, tt.satisfied) {
+				t.Errorf("Satisfied = %q, want %q", got, tt.satisfied)
+			}
+		})
+	}
+}
+
+func TestBuildConditionTreeErrors(t *testing.T) {
+	leaf := `{"indicator":"RSI","operator":"LESS THAN","threshold":30}`
+	deep := leaf
+	for i := 0; i <= maxConditionDepth; i++ {
+		deep = `{"type":"NOT","conditions":[` + deep + `]}`
+	}
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{"nothing to evaluate", `{}`, "indicators: either indicators or conditions is required"},
+		{"empty AND", `{"conditions":{"type":"AND"}}`, "conditions.conditions: AND group needs at least one child"},
+		{"NOT of two", `{"conditions":{"type":"NOT","conditions":[` + leaf + `,` + leaf + `]}}`, "conditions.conditions: NOT group needs exactly one child"},
+		{"unknown group", `{"conditions":{"type":"XOR","conditions":[` + leaf + `]}}`, `conditions.type: unknown group type "XOR"`},
+		{"too deep", `{"conditions":` + deep + `}`, "nesting deeper than 8"},
+		{"unknown operator", `{"conditions":{"type":"OR","conditions":[{"indicator":"RSI","operator":"ABOVE"}]}}`, `conditions.conditions[0].operator: unsupported operator "ABOVE"`},
+		{"unknown indicator", `{"indicators":[{"indicator":"NOPE","operator":"LESS THAN"}]}`, "indicators[0].indicator: unknown indicator: NOPE"},
+		{"two constants", `{"conditions":{"left":{"value":1},"operator":"LESS THAN","right":{"value":2}}}`, "conditions: comparing two constants"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := BuildConditionTree(request(t, tt.body))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("BuildConditionTree error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package calculator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Control command types carried on analysis.request.
+const (
+	CommandCreate = "create"
+	CommandUpdate = "update"
+	CommandDelete = "delete"
+)
+
+// ControlCommand is the typed envelope published to analysis.request.
+// Create and update carry the full request; delete only needs the job ID.
+type ControlCommand struct {
+	Type    string           `json:"type"`
+	JobID   string           `json:"jobId"`
+	Request *AnalysisRequest `json:"request,omitempty"`
+}
+
+// ParseControlCommand decodes a control message. Messages without a type
+// are bare AnalysisRequests from before job IDs existed; they are treated
+// as a create whose ID is the request's "symbol:interval" key.
+func ParseControlCommand(raw []byte) (ControlCommand, error) {
+	var cmd ControlCommand
+	if err := json.Unmarshal(raw, &cmd); err != nil {
+		return cmd, err
+	}
+	if cmd.Type == "" {
+		var req AnalysisRequest
+		if err := json.Unmarshal(raw, &req); err != nil {
+			return cmd, err
+		}
+		return ControlCommand{
+			Type:    CommandCreate,
+			JobID:   req.WebsocketKlineOptions.Symbol + ":" + req.WebsocketKlineOptions.Interval,
+			Request: &req,
+		}, nil
+	}
+
+	if cmd.JobID == "" {
+		return cmd, errors.New("control command without jobId")
+	}
+	switch cmd.Type {
+	case CommandCreate, CommandUpdate:
+		if cmd.Request == nil {
+			return cmd, fmt.Errorf("%s command for job %s without request", cmd.Type, cmd.JobID)
+		}
+	case CommandDelete:
+	default:
+		return cmd, fmt.Errorf("unknown control command type: %s", cmd.Type)
+	}
+	return cmd, nil
+}
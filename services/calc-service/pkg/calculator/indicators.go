@@ -0,0 +1,343 @@
+package calculator
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	talib "github.com/markcheno/go-talib"
+)
+
+// ParamSpec describes a single numeric indicator parameter.
+type ParamSpec struct {
+	Name    string  `json:"name"`
+	Default float64 `json:"default"`
+	Min     float64 `json:"min"`
+	Max     float64 `json:"max"`
+	Integer bool    `json:"integer"` // periods; fractions are rejected
+}
+
+// indicatorDef describes how an indicator is computed and what it outputs.
+// The first entry of Outputs is used when a request doesn't select one.
+type indicatorDef struct {
+	Params   []ParamSpec
+	Outputs  []string
+	Check    func(p paramSet) error // constraints between parameters, optional
+	Lookback func(p paramSet) int
+	Compute  func(s series, p paramSet) (map[string]float64, error)
+}
+
+// series holds the OHLCV columns of a window as talib-friendly slices.
+type series struct {
+	openTime                       []int64
+	open, high, low, close, volume []float64
+}
+
+// paramSet holds resolved parameter values keyed by parameter name.
+type paramSet map[string]float64
+
+func (p paramSet) int(name string) int { return int(p[name]) }
+
+// ErrNotEnoughData is returned when the window is shorter than the indicator lookback.
+var ErrNotEnoughData = errors.New("not enough klines in window")
+
+// indicatorAliases maps alternative spellings to canonical indicator names.
+var indicatorAliases = map[string]string{
+	"BOLLINGER":  "BBANDS",
+	"BB":         "BBANDS",
+	"STOCHASTIC": "STOCH",
+}
+
+// indicators is the catalogue of supported indicators keyed by canonical name.
+var indicators = map[string]indicatorDef{
+	"SMA": singlePeriod(20, 1, func(s series, n int) []float64 { return talib.Sma(s.close, n) }, func(n int) int { return n }),
+	"EMA": singlePeriod(20, 1, func(s series, n int) []float64 { return talib.Ema(s.close, n) }, func(n int) int { return n }),
+	"WMA": singlePeriod(20, 1, func(s series, n int) []float64 { return talib.Wma(s.close, n) }, func(n int) int { return n }),
+	"RSI": singlePeriod(14, 2, func(s series, n int) []float64 { return talib.Rsi(s.close, n) }, func(n int) int { return n + 1 }),
+	"ATR": singlePeriod(14, 1, func(s series, n int) []float64 { return talib.Atr(s.high, s.low, s.close, n) }, func(n int) int { return n + 1 }),
+	"CCI": singlePeriod(20, 2, func(s series, n int) []float64 { return talib.Cci(s.high, s.low, s.close, n) }, func(n int) int { return n }),
+	"MFI": singlePeriod(14, 2, func(s series, n int) []float64 { return talib.Mfi(s.high, s.low, s.close, s.volume, n) }, func(n int) int { return n + 1 }),
+	"ROC": singlePeriod(10, 1, func(s series, n int) []float64 { return talib.Roc(s.close, n) }, func(n int) int { return n + 1 }),
+	"RVOL": {
+		// Relative volume: the latest kline's volume over the average volume
+		// of the period klines before it; 3 is a spike of three times the
+		// average.
+		Params:   []ParamSpec{{Name: "period", Default: 20, Min: 1, Max: 500, Integer: true}},
+		Outputs:  []string{"value"},
+		Lookback: func(p paramSet) int { return p.int("period") + 1 },
+		Compute: func(s series, p paramSet) (map[string]float64, error) {
+			n, end := p.int("period"), len(s.volume)-1
+			var sum float64
+			for _, v := range s.volume[end-n : end] {
+				sum += v
+			}
+			if sum == 0 {
+				return nil, errors.New("no volume in RVOL range")
+			}
+			return map[string]float64{"value": s.volume[end] / (sum / float64(n))}, nil
+		},
+	},
+	"VWAP": {
+		// period=0 anchors the VWAP to the start of the current UTC day;
+		// a positive period gives a rolling VWAP over that many klines.
+		Params:  []ParamSpec{{Name: "period", Default: 0, Min: 0, Max: 500, Integer: true}},
+		Outputs: []string{"value"},
+		Lookback: func(p paramSet) int {
+			return max(p.int("period"), 1)
+		},
+		Compute: func(s series, p paramSet) (map[string]float64, error) {
+			n := len(s.close)
+			start := n - p.int("period")
+			if p.int("period") == 0 {
+				day := s.openTime[n-1] - s.openTime[n-1]%(24*time.Hour).Milliseconds()
+				for start = n - 1; start > 0 && s.openTime[start-1] >= day; start-- {
+				}
+			}
+			var pv, vol float64
+			for i := start; i < n; i++ {
+				pv += (s.high[i] + s.low[i] + s.close[i]) / 3 * s.volume[i]
+				vol += s.volume[i]
+			}
+			if vol == 0 {
+				return nil, errors.New("no volume in VWAP range")
+			}
+			return map[string]float64{"value": pv / vol}, nil
+		},
+	},
+	"OBV": {
+		Outputs:  []string{"value"},
+		Lookback: func(p paramSet) int { return 1 },
+		Compute: func(s series, p paramSet) (map[string]float64, error) {
+			return map[string]float64{"value": last(talib.Obv(s.close, s.volume))}, nil
+		},
+	},
+	"MACD": {
+		Params: []ParamSpec{
+			{Name: "fastPeriod", Default: 12, Min: 2, Max: 500, Integer: true},
+			{Name: "slowPeriod", Default: 26, Min: 2, Max: 500, Integer: true},
+			{Name: "signalPeriod", Default: 9, Min: 1, Max: 500, Integer: true},
+		},
+		Outputs: []string{"macd", "signal", "histogram"},
+		Check: func(p paramSet) error {
+			if p.int("fastPeriod") >= p.int("slowPeriod") {
+				return errors.New("fastPeriod must be smaller than slowPeriod")
+			}
+			return nil
+		},
+		Lookback: func(p paramSet) int {
+			return p.int("slowPeriod") + p.int("signalPeriod") - 1
+		},
+		Compute: func(s series, p paramSet) (map[string]float64, error) {
+			macd, signal, hist := talib.Macd(s.close, p.int("fastPeriod"), p.int("slowPeriod"), p.int("signalPeriod"))
+			return map[string]float64{
+				"macd":      last(macd),
+				"signal":    last(signal),
+				"histogram": last(hist),
+			}, nil
+		},
+	},
+	"BBANDS": {
+		Params: []ParamSpec{
+			{Name: "period", Default: 20, Min: 2, Max: 500, Integer: true},
+			{Name: "stdDev", Default: 2, Min: 0.1, Max: 10},
+		},
+		Outputs:  []string{"middle", "upper", "lower", "percentb"},
+		Lookback: func(p paramSet) int { return p.int("period") },
+		Compute: func(s series, p paramSet) (map[string]float64, error) {
+			upper, middle, lower := talib.BBands(s.close, p.int("period"), p["stdDev"], p["stdDev"], talib.SMA)
+			u, m, l := last(upper), last(middle), last(lower)
+			out := map[string]float64{"upper": u, "middle": m, "lower": l}
+			if u == l {
+				// %B is undefined on a flat band; leave it out so it can't be compared against.
+				return out, nil
+			}
+			out["percentb"] = (last(s.close) - l) / (u - l)
+			return out, nil
+		},
+	},
+	"STOCH": {
+		Params: []ParamSpec{
+			{Name: "kPeriod", Default: 14, Min: 1, Max: 500, Integer: true},
+			{Name: "kSmoothing", Default: 3, Min: 1, Max: 100, Integer: true},
+			{Name: "dPeriod", Default: 3, Min: 1, Max: 100, Integer: true},
+		},
+		Outputs: []string{"k", "d"},
+		Lookback: func(p paramSet) int {
+			return p.int("kPeriod") + p.int("kSmoothing") + p.int("dPeriod") - 2
+		},
+		Compute: func(s series, p paramSet) (map[string]float64, error) {
+			k, d := talib.Stoch(s.high, s.low, s.close,
+				p.int("kPeriod"), p.int("kSmoothing"), talib.SMA, p.int("dPeriod"), talib.SMA)
+			return map[string]float64{"k": last(k), "d": last(d)}, nil
+		},
+	},
+	"ADX": {
+		Params:   []ParamSpec{{Name: "period", Default: 14, Min: 2, Max: 500, Integer: true}},
+		Outputs:  []string{"adx", "plusdi", "minusdi"},
+		Lookback: func(p paramSet) int { return 2 * p.int("period") },
+		Compute: func(s series, p paramSet) (map[string]float64, error) {
+			n := p.int("period")
+			return map[string]float64{
+				"adx":     last(talib.Adx(s.high, s.low, s.close, n)),
+				"plusdi":  last(talib.PlusDI(s.high, s.low, s.close, n)),
+				"minusdi": last(talib.MinusDI(s.high, s.low, s.close, n)),
+			}, nil
+		},
+	},
+}
+
+// singlePeriod builds the definition of an indicator driven by a single
+// "period" parameter of at least minPeriod. RSI, CCI and MFI are degenerate
+// at period 1, where talib returns zeros rather than an error.
+func singlePeriod(def, minPeriod float64, fn func(s series, n int) []float64, lookback func(n int) int) indicatorDef {
+	return indicatorDef{
+		Params:   []ParamSpec{{Name: "period", Default: def, Min: minPeriod, Max: 500, Integer: true}},
+		Outputs:  []string{"value"},
+		Lookback: func(p paramSet) int { return lookback(p.int("period")) },
+		Compute: func(s series, p paramSet) (map[string]float64, error) {
+			return map[string]float64{"value": last(fn(s, p.int("period")))}, nil
+		},
+	}
+}
+
+// ParseIndicatorName splits "MACD.histogram" into its canonical indicator
+// name and output. The output is empty when the name doesn't select one.
+func ParseIndicatorName(name string) (string, string) {
+	base, output, _ := strings.Cut(strings.TrimSpace(name), ".")
+	base = strings.ToUpper(base)
+	if alias, ok := indicatorAliases[base]; ok {
+		base = alias
+	}
+	return base, strings.ToLower(output)
+}
+
+// resolveIndicator looks up the indicator definition and output selected by name.
+func resolveIndicator(name string) (indicatorDef, string, error) {
+	base, output := ParseIndicatorName(name)
+	def, ok := indicators[base]
+	if !ok {
+		return indicatorDef{}, "", fmt.Errorf("unknown indicator: %s", name)
+	}
+	if output == "" {
+		return def, def.Outputs[0], nil
+	}
+	for _, o := range def.Outputs {
+		if o == output {
+			return def, output, nil
+		}
+	}
+	return indicatorDef{}, "", fmt.Errorf("indicator %s has no output %q (available: %s)",
+		base, output, strings.Join(def.Outputs, ", "))
+}
+
+// resolveParams applies defaults and the parameter checks to the request
+// parameters.
+func resolveParams(def indicatorDef, params map[string]interface{}) (paramSet, error) {
+	var errs fieldErrors
+	p := checkParams(def, params, "parameters", &errs)
+	return p, errs.err()
+}
+
+// checkParams resolves the parameters of an indicator, adding a field
+// error under path for every parameter that isn't a number, isn't whole
+// where the schema wants an integer, is out of range or isn't in the
+// schema at all, and for violated constraints between parameters.
+func checkParams(def indicatorDef, params map[string]interface{}, path string, errs *fieldErrors) paramSet {
+	n := len(*errs)
+	p := make(paramSet, len(def.Params))
+	for _, spec := range def.Params {
+		p[spec.Name] = spec.Default
+		raw, given := params[spec.Name]
+		if !given {
+			continue
+		}
+		field := fieldPath(path, spec.Name)
+		f, isNum := raw.(float64)
+		switch {
+		case !isNum:
+			errs.add(field, "must be a number")
+		case spec.Integer && f != math.Trunc(f):
+			errs.add(field, "must be an integer")
+		case f < spec.Min || f > spec.Max:
+			errs.add(field, "must be between %v and %v", spec.Min, spec.Max)
+		default:
+			p[spec.Name] = f
+		}
+	}
+	given := make([]string, 0, len(params))
+	for param := range params {
+		given = append(given, param)
+	}
+	sort.Strings(given)
+	for _, param := range given {
+		switch {
+		case slices.ContainsFunc(def.Params, func(s ParamSpec) bool { return s.Name == param }):
+		case len(def.Params) == 0:
+			errs.add(fieldPath(path, param), "the indicator takes no parameters")
+		default:
+			errs.add(fieldPath(path, param), "unknown parameter (supported: %s)", strings.Join(paramNames(def), ", "))
+		}
+	}
+	if len(*errs) == n && def.Check != nil {
+		if err := def.Check(p); err != nil {
+			errs.add(path, "%v", err)
+		}
+	}
+	return p
+}
+
+// compileIndicator checks an indicator reference at path, its name,
+// output and parameters, and returns the minimum window length needed to
+// compute it.
+func compileIndicator(path, name string, params map[string]interface{}, errs *fieldErrors) int {
+	def, _, err := resolveIndicator(name)
+	if err != nil {
+		errs.add(fieldPath(path, "indicator"), "%v", err)
+		return 0
+	}
+	n := len(*errs)
+	p := checkParams(def, params, fieldPath(path, "parameters"), errs)
+	if len(*errs) > n {
+		return 0
+	}
+	return def.Lookback(p)
+}
+
+// ValidateIndicator checks that the indicator, output and parameters are
+// supported and returns the minimum window length needed to compute it.
+func ValidateIndicator(name string, params map[string]interface{}) (int, error) {
+	var errs fieldErrors
+	need := compileIndicator("", name, params, &errs)
+	return need, errs.err()
+}
+
+func newSeries(window []Kline) series {
+	s := series{
+		openTime: make([]int64, len(window)),
+		open:     make([]float64, len(window)),
+		high:     make([]float64, len(window)),
+		low:      make([]float64, len(window)),
+		close:    make([]float64, len(window)),
+		volume:   make([]float64, len(window)),
+	}
+	for i, k := range window {
+		s.openTime[i] = k.OpenTime.UnixMilli()
+		s.open[i] = k.Open
+		s.high[i] = k.High
+		s.low[i] = k.Low
+		s.close[i] = k.Close
+		s.volume[i] = k.Volume
+	}
+	return s
+}
+
+func last(vals []float64) float64 {
+	if len(vals) == 0 {
+		return math.NaN()
+	}
+	return vals[len(vals)-1]
+}
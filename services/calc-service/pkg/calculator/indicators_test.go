@@ -0,0 +1,131 @@
+package calculator
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// klines builds a window with one kline per close, each spanning one unit
+// either side of its close. Volumes default to 1.
+func klines(closes []float64, volumes ...float64) []Kline {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	window := make([]Kline, len(closes))
+	for i, c := range closes {
+		v := 1.0
+		if i < len(volumes) {
+			v = volumes[i]
+		}
+		open := start.Add(time.Duration(i) * time.Minute)
+		window[i] = Kline{
+			OpenTime:  open,
+			Open:      c,
+			High:      c + 1,
+			Low:       c - 1,
+			Close:     c,
+			Volume:    v,
+			CloseTime: open.Add(time.Minute - time.Millisecond),
+			IsClosed:  true,
+		}
+	}
+	return window
+}
+
+// ramp returns the closes 1, 2, ..., n.
+func ramp(n int) []float64 {
+	closes := make([]float64, n)
+	for i := range closes {
+		closes[i] = float64(i + 1)
+	}
+	return closes
+}
+
+// flat returns n closes of c.
+func flat(n int, c float64) []float64 {
+	closes := make([]float64, n)
+	for i := range closes {
+		closes[i] = c
+	}
+	return closes
+}
+
+func TestCalculateIndicator(t *testing.T) {
+	// Expected values are worked out by hand from the talib definitions:
+	// on a ramp of closes with a constant range of 2 every kline moves the
+	// high and low up by one, so TR is 2, +DM is 1 and -DM is 0.
+	tests := []struct {
+		name   string
+		window []Kline
+		params map[string]interface{}
+		want   float64
+	}{
+		{"SMA", klines(ramp(10)), map[string]interface{}{"period": 3.0}, 9},
+		// Seeded with the SMA of the first three closes, then halfway to each close
+		{"EMA", klines(ramp(10)), map[string]interface{}{"period": 3.0}, 9},
+		{"WMA", klines(ramp(10)), map[string]interface{}{"period": 3.0}, (8*1 + 9*2 + 10*3) / 6.0},
+		{"RSI", klines(ramp(20)), map[string]interface{}{"period": 14.0}, 100},
+		{"ROC", klines(ramp(10)), map[string]interface{}{"period": 2.0}, 25},
+		{"ATR", klines(ramp(20)), map[string]interface{}{"period": 5.0}, 2},
+		{"OBV", klines(ramp(10)), nil, 10},
+		{"RVOL", klines(ramp(4), 1, 1, 1, 3), map[string]interface{}{"period": 3.0}, 3},
+		{"VWAP", klines(ramp(4), 1, 1, 1, 3), map[string]interface{}{"period": 2.0}, (3*1 + 4*3) / 4.0},
+		{"MACD", klines(flat(40, 5)), nil, 0},
+		{"MACD.signal", klines(flat(40, 5)), nil, 0},
+		{"MACD.histogram", klines(flat(40, 5)), nil, 0},
+		// Population standard deviation of 1, 2, 3 is sqrt(2/3)
+		{"BBANDS", klines(ramp(3)), map[string]interface{}{"period": 3.0}, 2},
+		{"BBANDS.upper", klines(ramp(3)), map[string]interface{}{"period": 3.0}, 2 + 2*math.Sqrt(2.0/3)},
+		{"BB.lower", klines(ramp(3)), map[string]interface{}{"period": 3.0}, 2 - 2*math.Sqrt(2.0/3)},
+		{"BOLLINGER.percentb", klines(ramp(3)), map[string]interface{}{"period": 3.0}, 0.5 + 0.5/(2*math.Sqrt(2.0/3))},
+		// The close sits 3 above the lowest low of the last three klines, in a range of 4
+		{"STOCH", klines(ramp(20)), map[string]interface{}{"kPeriod": 3.0}, 75},
+		{"STOCHASTIC.d", klines(ramp(20)), map[string]interface{}{"kPeriod": 3.0}, 75},
+		{"ADX", klines(ramp(40)), map[string]interface{}{"period": 5.0}, 100},
+		{"ADX.plusdi", klines(ramp(40)), map[string]interface{}{"period": 5.0}, 50},
+		{"ADX.minusdi", klines(ramp(40)), map[string]interface{}{"period": 5.0}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CalculateIndicator(tt.window, tt.name, "BTCUSDT", "1m", tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("%s = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalculateIndicatorErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		window  []Kline
+		params  map[string]interface{}
+		wantErr string
+	}{
+		{"SMA", klines(ramp(2)), map[string]interface{}{"period": 3.0}, ErrNotEnoughData.Error()},
+		{"MACD", klines(ramp(33)), nil, ErrNotEnoughData.Error()},
+		{"NOPE", klines(ramp(10)), nil, "unknown indicator"},
+		{"MACD.upper", klines(ramp(40)), nil, `has no output "upper"`},
+		{"SMA", klines(ramp(10)), map[string]interface{}{"period": 2.5}, "must be an integer"},
+		{"SMA", klines(ramp(10)), map[string]interface{}{"period": "3"}, "must be a number"},
+		{"SMA", klines(ramp(10)), map[string]interface{}{"length": 3.0}, "unknown parameter"},
+		{"MACD", klines(ramp(40)), map[string]interface{}{"fastPeriod": 26.0, "slowPeriod": 12.0}, "fastPeriod must be smaller"},
+		// %B is undefined on a flat band
+		{"BBANDS.percentb", klines(flat(20, 5)), nil, `no value for output "percentb"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CalculateIndicator(tt.window, tt.name, "BTCUSDT", "1m", tt.params)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("CalculateIndicator = %v, %v; want error containing %q", got, err, tt.wantErr)
+			}
+			if tt.wantErr == ErrNotEnoughData.Error() && !errors.Is(err, ErrNotEnoughData) {
+				t.Errorf("error %v does not wrap ErrNotEnoughData", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package calculator
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// klineFields lists the raw kline fields an operand can reference.
+var klineFields = map[string]func(k Kline) float64{
+	"open":   func(k Kline) float64 { return k.Open },
+	"high":   func(k Kline) float64 { return k.High },
+	"low":    func(k Kline) float64 { return k.Low },
+	"close":  func(k Kline) float64 { return k.Close },
+	"volume": func(k Kline) float64 { return k.Volume },
+}
+
+// Operand is one side of a comparison. Exactly one of Value, Field or
+// Indicator is set: a constant, a raw field of the latest kline
+// (open/high/low/close/volume) or an indicator with its own parameters.
+type Operand struct {
+	Value      *float64               `json:"value,omitempty"`
+	Field      string                 `json:"field,omitempty"`
+	Indicator  string                 `json:"indicator,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// Constant returns an operand holding a fixed value.
+func Constant(v float64) Operand { return Operand{Value: &v} }
+
+// Validate checks that exactly one kind is set and that it is supported.
+func (o Operand) Validate() error {
+	var errs fieldErrors
+	o.compile("", &errs)
+	return errs.err()
+}
+
+// compile adds the problems of the operand at path to errs.
+func (o Operand) compile(path string, errs *fieldErrors) {
+	kinds := 0
+	if o.Value != nil {
+		kinds++
+	}
+	if o.Field != "" {
+		kinds++
+		if _, ok := klineFields[strings.ToLower(o.Field)]; !ok {
+			errs.add(fieldPath(path, "field"), "unknown kline field %q (supported: %s)", o.Field, strings.Join(fieldNames(), ", "))
+		}
+	}
+	if o.Indicator != "" {
+		kinds++
+		compileIndicator(path, o.Indicator, o.Parameters, errs)
+	}
+	if kinds != 1 {
+		errs.add(path, "must set exactly one of value, field or indicator")
+	}
+}
+
+// IsConstant reports whether the operand is a fixed value.
+func (o Operand) IsConstant() bool { return o.Value != nil }
+
+// Lookback returns the number of klines the operand needs in the window.
+func (o Operand) Lookback() int {
+	if o.Indicator == "" {
+		return 1
+	}
+	need, _ := ValidateIndicator(o.Indicator, o.Parameters)
+	return need
+}
+
+// Resolve computes the operand's current value on the window.
+func (o Operand) Resolve(window []Kline, symbol, interval string) (float64, error) {
+	switch {
+	case o.Value != nil:
+		return *o.Value, nil
+	case o.Field != "":
+		if len(window) == 0 {
+			return 0, ErrNotEnoughData
+		}
+		return klineFields[strings.ToLower(o.Field)](window[len(window)-1]), nil
+	default:
+		return CalculateIndicator(window, o.Indicator, symbol, interval, o.Parameters)
+	}
+}
+
+// Key identifies the operand for previous-value tracking. Indicators with
+// different parameters get different keys, e.g. "EMA(period=9)".
+func (o Operand) Key() string {
+	switch {
+	case o.Value != nil:
+		return fmt.Sprintf("%g", *o.Value)
+	case o.Field != "":
+		return strings.ToLower(o.Field)
+	}
+	base, output := ParseIndicatorName(o.Indicator)
+	if output != "" {
+		base += "." + output
+	}
+	names := make([]string, 0, len(o.Parameters))
+	for name := range o.Parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s=%v", name, o.Parameters[name])
+	}
+	return base + "(" + strings.Join(parts, ",") + ")"
+}
+
+// Operators lists the comparison operators EvaluateComparison supports.
+var Operators = []string{"GREATER THAN", "LESS THAN", "CROSSING", "CROSSING UP", "CROSSING DOWN"}
+
+// EvaluateComparison applies the operator to both sides of a comparison,
+// using their previous values for crossings. A NaN previous value means
+// there is no history yet, so crossings can't be met.
+func EvaluateComparison(left, right, prevLeft, prevRight float64, operator string) (bool, error) {
+	switch strings.ToUpper(operator) {
+	case "GREATER THAN":
+		return left > right, nil
+	case "LESS THAN":
+		return left < right, nil
+	case "CROSSING":
+		return (prevLeft < prevRight && left >= right) || (prevLeft > prevRight && left <= right), nil
+	case "CROSSING UP":
+		return prevLeft < prevRight && left >= right, nil
+	case "CROSSING DOWN":
+		return prevLeft > prevRight && left <= right, nil
+	default:
+		return false, errors.New("unknown operator: " + operator)
+	}
+}
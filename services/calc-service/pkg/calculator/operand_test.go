@@ -0,0 +1,123 @@
+package calculator
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEvaluateComparison(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		name                string
+		operator            string
+		left, right         float64
+		prevLeft, prevRight float64
+		want                bool
+	}{
+		{"greater", "GREATER THAN", 2, 1, nan, nan, true},
+		{"not greater when equal", "greater than", 1, 1, nan, nan, false},
+		{"less", "LESS THAN", 1, 2, nan, nan, true},
+		{"not less", "LESS THAN", 2, 1, 3, 1, false},
+
+		// The first bar has no previous values: crossings can't be met even
+		// when the current values are on the far side
+		{"first bar crossing", "CROSSING", 2, 1, nan, nan, false},
+		{"first bar crossing up", "CROSSING UP", 2, 1, nan, nan, false},
+		{"first bar crossing down", "CROSSING DOWN", 1, 2, nan, nan, false},
+		{"first bar crossing up a constant", "CROSSING UP", 31, 30, nan, 30, false},
+		{"first bar crossing down a constant", "CROSSING DOWN", 29, 30, nan, 30, false},
+
+		{"crosses up", "CROSSING UP", 2, 1, 0, 1, true},
+		{"crosses up onto", "CROSSING UP", 1, 1, 0, 1, true},
+		{"stays above", "CROSSING UP", 3, 1, 2, 1, false},
+		{"was on the line", "CROSSING UP", 2, 1, 1, 1, false},
+		{"up is not down", "CROSSING DOWN", 2, 1, 0, 1, false},
+		{"crosses down", "CROSSING DOWN", 0, 1, 2, 1, true},
+		{"crosses down onto", "CROSSING DOWN", 1, 1, 2, 1, true},
+		{"stays below", "CROSSING DOWN", 0, 1, 0.5, 1, false},
+		{"crossing either way up", "CROSSING", 2, 1, 0, 1, true},
+		{"crossing either way down", "CROSSING", 0, 1, 2, 1, true},
+		{"crossing of a moving line", "crossing up", 10, 9, 8, 9.5, true},
+		{"no crossing", "CROSSING", 2, 1, 3, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := EvaluateComparison(tt.left, tt.right, tt.prevLeft, tt.prevRight, tt.operator)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("EvaluateComparison(%v, %v, %v, %v, %q) = %v, want %v",
+					tt.left, tt.right, tt.prevLeft, tt.prevRight, tt.operator, got, tt.want)
+			}
+		})
+	}
+	if _, err := EvaluateComparison(1, 0, 0, 0, "ABOVE"); err == nil {
+		t.Error("unknown operator accepted")
+	}
+}
+
+// TestCrossingFirstBar follows a crossing job's previous values: the first
+// bar only records them, the second can cross.
+func TestCrossingFirstBar(t *testing.T) {
+	c := NewCalculator(nil, nil)
+	const job, sym, key = "job-1", "BTCUSDT", "RSI()"
+	bars := []struct {
+		rsi  float64
+		want bool
+	}{
+		{35, false}, // already above 30, but there is nothing to cross from
+		{25, false},
+		{31, true},
+		{40, false},
+	}
+	for i, bar := range bars {
+		prev := c.GetPrevious(job, sym, key)
+		if i == 0 && !math.IsNaN(prev) {
+			t.Fatalf("previous value before the first bar = %v, want NaN", prev)
+		}
+		met, err := EvaluateComparison(bar.rsi, 30, prev, 30, "CROSSING UP")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if met != bar.want {
+			t.Errorf("bar %d: RSI %v CROSSING UP 30 = %v, want %v", i, bar.rsi, met, bar.want)
+		}
+		c.SetPrevious(job, sym, key, bar.rsi)
+	}
+
+	// Replacing the job starts it over from a first bar
+	c.resetPrevious(job)
+	if prev := c.GetPrevious(job, sym, key); !math.IsNaN(prev) {
+		t.Errorf("previous value after reset = %v, want NaN", prev)
+	}
+}
+
+func TestOperandResolve(t *testing.T) {
+	window := klines(ramp(10))
+	tests := []struct {
+		name    string
+		operand Operand
+		want    float64
+		wantKey string
+	}{
+		{"constant", Constant(30), 30, "30"},
+		{"field", Operand{Field: "High"}, 11, "high"},
+		{"indicator", Operand{Indicator: "sma", Parameters: map[string]interface{}{"period": 3.0}}, 9, "SMA(period=3)"},
+		{"indicator output", Operand{Indicator: "bb.middle", Parameters: map[string]interface{}{"period": 3.0, "stdDev": 1.0}}, 9, "BBANDS.middle(period=3,stdDev=1)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.operand.Resolve(window, "BTCUSDT", "1m")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Resolve = %v, want %v", got, tt.want)
+			}
+			if key := tt.operand.Key(); key != tt.wantKey {
+				t.Errorf("Key = %q, want %q", key, tt.wantKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package calculator
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/events"
+	kafka "github.com/segmentio/kafka-go"
+)
+
+const (
+	defaultScreenerLimit = 10
+	maxScreenerLimit     = 100
+	// screenerSettle is how long a screener round waits after its first
+	// closed candle for the candles of the other symbols.
+	screenerSettle = 10 * time.Second
+)
+
+// Screener turns a job into a market screener: instead of alerting per
+// symbol, on every candle close it ranks the job's symbols by Rank and
+// publishes the top Limit as one snapshot. The job's indicators and
+// conditions, if any, filter which symbols are ranked.
+type Screener struct {
+	Rank  Operand `json:"rank"`            // e.g. {"indicator": "RSI"} or {"field": "volume"}
+	Order string  `json:"order,omitempty"` // DESC (default) or ASC
+	Limit int     `json:"limit,omitempty"` // default 10, at most 100
+}
+
+// screenerRules is the compiled form of a Screener.
+type screenerRules struct {
+	rank  Operand
+	order string
+	limit int
+}
+
+// compileScreener compiles the screener, adding its problems to errs.
+func compileScreener(s *Screener, errs *fieldErrors) *screenerRules {
+	if s == nil {
+		return nil
+	}
+	s.Rank.compile("screener.rank", errs)
+	if s.Rank.IsConstant() {
+		errs.add("screener.rank", "must be a field or an indicator")
+	}
+	r := &screenerRules{rank: s.Rank, order: strings.ToUpper(s.Order), limit: s.Limit}
+	switch r.order {
+	case "":
+		r.order = events.ScreenerDesc
+	case events.ScreenerDesc, events.ScreenerAsc:
+	default:
+		errs.add("screener.order", "unknown order %q (supported: %s, %s)", s.Order, events.ScreenerDesc, events.ScreenerAsc)
+	}
+	switch {
+	case r.limit == 0:
+		r.limit = defaultScreenerLimit
+	case r.limit < 0 || r.limit > maxScreenerLimit:
+		errs.add("screener.limit", "must be between 1 and %d", maxScreenerLimit)
+	}
+	return r
+}
+
+// IsScreener reports whether the job publishes screener snapshots rather
+// than alerts.
+func (j *Job) IsScreener() bool { return j.screener != nil }
+
+// screenerRound collects the closed candles of one open time for a
+// screener job.
+type screenerRound struct {
+	job        *Job
+	openTime   time.Time
+	closeTime  time.Time
+	expected   int   // owned symbols of the job
+	partitions []int // owned kline.raw partitions holding symbols of the job
+	all        []int // kline.raw partitions holding symbols of the job
+	seen       map[string]struct{}
+	entries    map[string]events.ScreenerEntry
+	timer      *time.Timer
+	published  bool
+}
+
+// SetScreenerWriter sets the writer screener snapshots are published
+// with; without one they are only logged.
+func (c *Calculator) SetScreenerWriter(w *kafka.Writer) { c.screenerWriter = w }
+
+// SetPartitions records the kline.raw partitions this instance owns and
+// the partition each symbol lands on. Screener snapshots carry them so
+// that the snapshots of all instances can be merged into one ranking.
+func (c *Calculator) SetPartitions(assigned []int, partitionOf func(symbol string) int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.partitions = slices.Clone(assigned)
+	c.partitionOf = partitionOf
+}
+
+// RecordScreen adds a symbol's closed candle, the last of window, to the
+// job's screener round; passed is whether the symbol met the job's
+// conditions. A round is published once every owned symbol of the job has
+// reported, when a candle of the next open time arrives or screenerSettle
+// after its first candle, whichever comes first. Candles arriving after
+// their round was published are dropped.
+func (c *Calculator) RecordScreen(ctx context.Context, job *Job, sym string, window []Kline, passed bool) {
+	k := window[len(window)-1]
+	value, err := job.screener.rank.Resolve(window, sym, job.Interval)
+	if err != nil {
+		log.Printf("[screener] job %s: rank of %s error: %v", job.ID, sym, err)
+	}
+
+	var due []*screenerRound
+	c.screenMu.Lock()
+	r := c.rounds[job.ID]
+	switch {
+	case r == nil || r.job != job || k.OpenTime.After(r.openTime):
+		if r != nil && !r.published {
+			r.published = true
+			r.timer.Stop()
+			due = append(due, r)
+		}
+		r = c.newRound(job, k)
+		c.rounds[job.ID] = r
+	case k.OpenTime.Before(r.openTime) || r.published:
+		c.screenMu.Unlock()
+		log.Printf("[screener] job %s: dropping late candle %s of %s", job.ID, k.OpenTime.Format(time.RFC3339), sym)
+		return
+	}
+	r.seen[sym] = struct{}{}
+	if passed && err == nil && !math.IsNaN(value) && !math.IsInf(value, 0) {
+		r.entries[sym] = events.ScreenerEntry{Symbol: sym, Value: value, Price: k.Close}
+	}
+	if len(r.seen) >= r.expected {
+		r.published = true
+		r.timer.Stop()
+		due = append(due, r)
+	}
+	c.screenMu.Unlock()
+
+	for _, r := range due {
+		c.publishRound(ctx, r)
+	}
+}
+
+// newRound starts the round of the candle k. Callers hold c.screenMu.
+func (c *Calculator) newRound(job *Job, k Kline) *screenerRound {
+	r := &screenerRound{
+		job:       job,
+		openTime:  k.OpenTime,
+		closeTime: k.CloseTime,
+		seen:      make(map[string]struct{}),
+		entries:   make(map[string]events.ScreenerEntry),
+	}
+	c.mu.Lock()
+	for _, sym := range job.Symbols {
+		if c.owns(sym) {
+			r.expected++
+		}
+		if c.partitionOf == nil {
+			continue
+		}
+		if p := c.partitionOf(sym); !slices.Contains(r.all, p) {
+			r.all = append(r.all, p)
+			if slices.Contains(c.partitions, p) {
+				r.partitions = append(r.partitions, p)
+			}
+		}
+	}
+	c.mu.Unlock()
+	slices.Sort(r.all)
+	slices.Sort(r.partitions)
+	r.timer = time.AfterFunc(screenerSettle, func() {
+		c.screenMu.Lock()
+		if r.published || c.rounds[job.ID] != r {
+			c.screenMu.Unlock()
+			return
+		}
+		r.published = true
+		c.screenMu.Unlock()
+		c.publishRound(context.Background(), r)
+	})
+	return r
+}
+
+// dropScreenerRound discards the pending round of a deleted job.
+func (c *Calculator) dropScreenerRound(jobID string) {
+	c.screenMu.Lock()
+	defer c.screenMu.Unlock()
+	if r, ok := c.rounds[jobID]; ok {
+		r.timer.Stop()
+		delete(c.rounds, jobID)
+	}
+}
+
+// publishRound ranks the round's symbols and publishes the snapshot to
+// screener.snapshot keyed by job ID.
+func (c *Calculator) publishRound(ctx context.Context, r *screenerRound) {
+	entries := make([]events.ScreenerEntry, 0, len(r.entries))
+	for _, e := range r.entries {
+		entries = append(entries, e)
+	}
+	rules := r.job.screener
+	snap := events.ScreenerSnapshot{
+		Version:       events.ScreenerVersion,
+		JobID:         r.job.ID,
+		Owner:         r.job.Request.Owner,
+		Exchange:      r.job.Exchange,
+		Market:        r.job.Market,
+		Interval:      r.job.Interval,
+		Rank:          rules.rank.Key(),
+		Order:         rules.order,
+		Limit:         rules.limit,
+		OpenTime:      r.openTime,
+		CloseTime:     r.closeTime,
+		Partitions:    r.partitions,
+		JobPartitions: r.all,
+		Evaluated:     len(r.seen),
+		Matched:       len(entries),
+		Entries:       events.RankScreenerEntries(entries, rules.order, rules.limit),
+		Time:          time.Now(),
+	}
+	log.Printf("[screener] job %s: %d of %d symbols ranked for %s", r.job.ID, snap.Matched, snap.Evaluated, r.openTime.Format(time.RFC3339))
+	if c.screenerWriter == nil {
+		return
+	}
+	b, err := json.Marshal(snap)
+	if err != nil {
+		log.Printf("[screener] encode error: %v", err)
+		return
+	}
+	if err := c.screenerWriter.WriteMessages(ctx, kafka.Message{Key: []byte(r.job.ID), Value: b}); err != nil {
+		log.Printf("[screener] publish error: %v", err)
+	}
+}
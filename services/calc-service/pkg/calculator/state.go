@@ -0,0 +1,179 @@
+package calculator
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// JobRecord is the persisted definition of a job. Symbols holds the
+// resolved symbol list so an "ALL" job can be restored without asking the
+// exchange again; Offset is the analysis.request offset of the command
+// that last changed the job, so replayed older commands can be skipped.
+type JobRecord struct {
+	ID      string          `json:"id"`
+	Request AnalysisRequest `json:"request"`
+	Symbols []string        `json:"symbols"`
+	Offset  int64           `json:"offset"`
+}
+
+// StateStore persists jobs, kline windows and previous values so a
+// restarted or rebalanced calc-service can pick up where another stopped.
+// Windows are keyed by exchange:market:symbol:interval; previous values by
+// job and symbol.
+type StateStore interface {
+	SaveJob(ctx context.Context, rec JobRecord) error
+	DeleteJob(ctx context.Context, id string) error
+	LoadJobs(ctx context.Context) ([]JobRecord, error)
+
+	SaveWindow(ctx context.Context, key string, window []Kline) error
+	DeleteWindow(ctx context.Context, key string) error
+	LoadWindow(ctx context.Context, key string) ([]Kline, error)
+
+	SavePrevious(ctx context.Context, jobID, symbol string, values map[string]float64) error
+	DeletePrevious(ctx context.Context, jobID string) error
+	LoadPrevious(ctx context.Context, jobID, symbol string) (map[string]float64, error)
+}
+
+func (c *Calculator) saveJob(ctx context.Context, rec JobRecord) {
+	if c.store == nil {
+		return
+	}
+	if err := c.store.SaveJob(ctx, rec); err != nil {
+		log.Printf("[state] save job %s error: %v", rec.ID, err)
+	}
+}
+
+func (c *Calculator) removeJob(id string) {
+	if c.store == nil {
+		return
+	}
+	if err := c.store.DeleteJob(context.Background(), id); err != nil {
+		log.Printf("[state] delete job %s error: %v", id, err)
+	}
+}
+
+// Restore loads the job definitions from the state store. Windows and
+// previous values are loaded per symbol once Rebalance assigns them.
+func (c *Calculator) Restore(ctx context.Context) error {
+	if c.store == nil {
+		return nil
+	}
+	recs, err := c.store.LoadJobs(ctx)
+	if err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		if err := c.installJob(ctx, &rec); err != nil {
+			log.Printf("[state] skipping stored job %s: %v", rec.ID, err)
+		}
+	}
+	log.Printf("[state] restored %d jobs", len(recs))
+	return nil
+}
+
+// loadPrevious loads a job's previous values for the given symbols unless
+// they are already in memory.
+func (c *Calculator) loadPrevious(ctx context.Context, jobID string, symbols []string) {
+	if c.store == nil {
+		return
+	}
+	for _, sym := range symbols {
+		c.prevMu.Lock()
+		_, ok := c.prevValues[jobID][sym]
+		c.prevMu.Unlock()
+		if ok {
+			continue
+		}
+		values, err := c.store.LoadPrevious(ctx, jobID, sym)
+		if err != nil {
+			log.Printf("[state] load previous values of %s/%s error: %v", jobID, sym, err)
+			continue
+		}
+		if len(values) == 0 {
+			continue
+		}
+		c.prevMu.Lock()
+		if _, ok := c.prevValues[jobID]; !ok {
+			c.prevValues[jobID] = make(map[string]map[string]float64)
+		}
+		c.prevValues[jobID][sym] = values
+		c.prevMu.Unlock()
+	}
+}
+
+// RunFlusher writes changed windows and previous values to the state store
+// every interval until ctx is cancelled, then flushes one last time.
+func (c *Calculator) RunFlusher(ctx context.Context, every time.Duration) {
+	if c.store == nil {
+		return
+	}
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			c.Flush(context.Background())
+			return
+		case <-ticker.C:
+			c.Flush(ctx)
+		}
+	}
+}
+
+// Flush writes every window and previous-value set changed since the last
+// flush; windows that no longer exist and reset jobs are deleted from the store.
+func (c *Calculator) Flush(ctx context.Context) {
+	if c.store == nil {
+		return
+	}
+
+	c.mu.Lock()
+	windows := make(map[string][]Kline, len(c.dirtyWindows))
+	for key := range c.dirtyWindows {
+		windows[key] = c.windows[key]
+	}
+	c.dirtyWindows = make(map[string]struct{})
+	c.mu.Unlock()
+
+	type prevKey struct{ jobID, symbol string }
+	c.prevMu.Lock()
+	resets := c.prevResets
+	c.prevResets = make(map[string]struct{})
+	prev := make(map[prevKey]map[string]float64)
+	for id, syms := range c.dirtyPrev {
+		for sym := range syms {
+			m := c.prevValues[id][sym]
+			cp := make(map[string]float64, len(m))
+			for k, v := range m {
+				cp[k] = v
+			}
+			prev[prevKey{id, sym}] = cp
+		}
+	}
+	c.dirtyPrev = make(map[string]map[string]struct{})
+	c.prevMu.Unlock()
+
+	for key, w := range windows {
+		var err error
+		if w == nil {
+			err = c.store.DeleteWindow(ctx, key)
+		} else {
+			err = c.store.SaveWindow(ctx, key, w)
+		}
+		if err != nil {
+			log.Printf("[state] flush window %s error: %v", key, err)
+		}
+	}
+	// Resets go first so values written after a reset survive it
+	for id := range resets {
+		if err := c.store.DeletePrevious(ctx, id); err != nil {
+			log.Printf("[state] reset previous values of %s error: %v", id, err)
+		}
+	}
+	for key, m := range prev {
+		if err := c.store.SavePrevious(ctx, key.jobID, key.symbol, m); err != nil {
+			log.Printf("[state] flush previous values of %s/%s error: %v", key.jobID, key.symbol, err)
+		}
+	}
+}
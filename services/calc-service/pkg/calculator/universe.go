@@ -0,0 +1,63 @@
+package calculator
+
+import (
+	"context"
+	"log"
+	"slices"
+
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/events"
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/exchange"
+)
+
+// ApplySymbolEvent adds a newly listed symbol to every "ALL" job on the
+// event's exchange and market and removes a delisted one from them. A
+// delisted symbol's previous values and alert state are dropped from the
+// jobs it leaves, and its window once no job watches it. Events that don't
+// change a job are ignored, so replaying symbol.events is harmless.
+func (c *Calculator) ApplySymbolEvent(ctx context.Context, evt events.SymbolEvent) {
+	c.ctrlMu.Lock()
+	defer c.ctrlMu.Unlock()
+
+	// Events from before adapters and market types existed were all
+	// Binance USDⓈ-M's
+	evtExchange := evt.Exchange
+	if evtExchange == "" {
+		evtExchange = exchange.Default
+	}
+	evtMarket := exchange.NormalizeMarket(evt.Market)
+
+	c.mu.Lock()
+	var recs []JobRecord
+	for _, job := range c.jobs {
+		if job.Request.WebsocketKlineOptions.Symbol != "ALL" || job.Exchange != evtExchange || job.Market != evtMarket {
+			continue
+		}
+		has := slices.Contains(job.Symbols, evt.Symbol)
+		var syms []string
+		switch {
+		case evt.Type == events.SymbolListed && !has:
+			syms = append(slices.Clone(job.Symbols), evt.Symbol)
+		case evt.Type == events.SymbolDelisted && has:
+			syms = slices.DeleteFunc(slices.Clone(job.Symbols), func(s string) bool { return s == evt.Symbol })
+		default:
+			continue
+		}
+		if len(syms) == 0 {
+			continue
+		}
+		recs = append(recs, JobRecord{ID: job.ID, Request: job.Request, Symbols: syms, Offset: job.Offset})
+	}
+	c.mu.Unlock()
+
+	for _, rec := range recs {
+		if err := c.installJob(ctx, &rec); err != nil {
+			log.Printf("[symbols] updating job %s for %s %s: %v", rec.ID, evt.Type, evt.Symbol, err)
+			continue
+		}
+		if evt.Type == events.SymbolDelisted {
+			c.dropPrevious(rec.ID, evt.Symbol)
+		}
+		c.saveJob(ctx, rec)
+		log.Printf("[symbols] job %s: %s %s, now %d symbols", rec.ID, evt.Type, evt.Symbol, len(rec.Symbols))
+	}
+}
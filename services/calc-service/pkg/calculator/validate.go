@@ -0,0 +1,166 @@
+package calculator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/events"
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/exchange"
+)
+
+// FieldError is a validation error of one field of an analysis request.
+// Field is the JSON path of the field, e.g. "indicators[0].parameters.period"
+// or "conditions.conditions[1].operator".
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// symbolCacheTTL is how long the symbols of an exchange market are trusted
+// when checking that a request's symbol exists.
+const symbolCacheTTL = 10 * time.Minute
+
+// symbolListing is the cached listing of one exchange market. Its mutex is
+// held while the listing is fetched, so concurrent lookups of the market
+// wait for one fetch while other markets aren't held up.
+type symbolListing struct {
+	sync.Mutex
+	symbols map[string]struct{}
+	fetched time.Time
+}
+
+var symbolCache = struct {
+	sync.Mutex
+	listings map[string]*symbolListing // exchange:market -> listing
+}{
+	listings: make(map[string]*symbolListing),
+}
+
+// fieldErrors is the error CompileJob returns for an invalid request:
+// every problem found, each under the JSON path of its field. The compile
+// helpers add to it rather than stopping at the first problem, so the same
+// checks serve CompileJob and ValidateRequest.
+type fieldErrors []FieldError
+
+func (e fieldErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Message
+		if fe.Field != "" {
+			msgs[i] = fe.Field + ": " + fe.Message
+		}
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *fieldErrors) add(field, format string, args ...interface{}) {
+	*e = append(*e, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// err returns the collected errors, or nil if there are none.
+func (e fieldErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// fieldPath appends a field name to a JSON path.
+func fieldPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+// ValidateRequest checks every field of an analysis request against what
+// calc-service supports and returns every problem found rather than the
+// first one; an empty result means CompileJob accepts the request. On top
+// of CompileJob's checks the symbol is looked up in the exchange's listing,
+// unless it can't be fetched.
+func ValidateRequest(ctx context.Context, req AnalysisRequest) []FieldError {
+	var errs fieldErrors
+	if _, err := CompileJob("", req, nil); err != nil && !errors.As(err, &errs) {
+		errs = fieldErrors{{Message: err.Error()}}
+	}
+
+	// The listing is left out of CompileJob, which also runs when stored
+	// jobs are restored and must not depend on the exchange being reachable
+	opts := req.WebsocketKlineOptions
+	if opts.Symbol == "" || opts.Symbol == "ALL" {
+		return errs
+	}
+	ex, err := exchange.Get(opts.Exchange, opts.Market)
+	if err != nil {
+		return errs
+	}
+	ok, err := symbolExists(ctx, ex, opts.Symbol)
+	if err != nil {
+		log.Printf("[validate] could not check symbol %s: %v", opts.Symbol, err)
+	} else if !ok {
+		errs.add("websocketKlineOptions.symbol", "unknown symbol %q on %s %s", opts.Symbol, ex.Name(), ex.Market())
+	}
+	return errs
+}
+
+// symbolExists reports whether the symbol trades on the exchange's market.
+func symbolExists(ctx context.Context, ex exchange.Adapter, symbol string) (bool, error) {
+	key := ex.Name() + ":" + ex.Market()
+	symbolCache.Lock()
+	l, ok := symbolCache.listings[key]
+	if !ok {
+		l = &symbolListing{}
+		symbolCache.listings[key] = l
+	}
+	symbolCache.Unlock()
+
+	l.Lock()
+	defer l.Unlock()
+	if time.Since(l.fetched) > symbolCacheTTL {
+		syms, err := ex.Symbols(ctx)
+		if err != nil {
+			return false, err
+		}
+		l.symbols = make(map[string]struct{}, len(syms))
+		for _, s := range syms {
+			l.symbols[s] = struct{}{}
+		}
+		l.fetched = time.Now()
+	}
+	_, ok = l.symbols[symbol]
+	return ok, nil
+}
+
+// marketNames lists the markets the exchange has an adapter for.
+func marketNames(name string) []string {
+	var out []string
+	for _, m := range events.Markets {
+		if _, err := exchange.Get(name, m); err == nil {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+func fieldNames() []string {
+	names := make([]string, 0, len(klineFields))
+	for name := range klineFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func paramNames(def indicatorDef) []string {
+	names := make([]string, len(def.Params))
+	for i, s := range def.Params {
+		names[i] = s.Name
+	}
+	return names
+}
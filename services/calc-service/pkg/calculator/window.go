@@ -0,0 +1,264 @@
+package calculator
+
+import (
+	"context"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+// windowKey identifies the window shared by all jobs on an exchange,
+// market, symbol and interval. The same symbol on spot and futures are
+// separate windows.
+func windowKey(exchange, market, symbol, interval string) string {
+	return exchange + ":" + market + ":" + symbol + ":" + interval
+}
+
+// parseWindowKey splits a windowKey into its parts.
+func parseWindowKey(key string) (exchange, market, symbol, interval string) {
+	parts := strings.SplitN(key, ":", 4)
+	for len(parts) < 4 {
+		parts = append(parts, "")
+	}
+	return parts[0], parts[1], parts[2], parts[3]
+}
+
+// subscribe indexes the job under each of its symbols. Callers hold c.mu.
+func (c *Calculator) subscribe(job *Job) {
+	for _, sym := range job.Symbols {
+		key := windowKey(job.Exchange, job.Market, sym, job.Interval)
+		if c.subs[key] == nil {
+			c.subs[key] = make(map[string]struct{})
+		}
+		c.subs[key][job.ID] = struct{}{}
+	}
+}
+
+// unsubscribe removes the job from the index. Callers hold c.mu.
+func (c *Calculator) unsubscribe(job *Job) {
+	for _, sym := range job.Symbols {
+		key := windowKey(job.Exchange, job.Market, sym, job.Interval)
+		delete(c.subs[key], job.ID)
+		if len(c.subs[key]) == 0 {
+			delete(c.subs, key)
+		}
+	}
+}
+
+// dropUnusedWindows releases the windows of the job's symbols that no job
+// subscribes to anymore. Callers hold c.mu.
+func (c *Calculator) dropUnusedWindows(job *Job) {
+	for _, sym := range job.Symbols {
+		key := windowKey(job.Exchange, job.Market, sym, job.Interval)
+		if _, ok := c.subs[key]; !ok {
+			delete(c.windows, key)
+			c.dirtyWindows[key] = struct{}{}
+		}
+	}
+}
+
+// ownsSymbol reports whether this instance evaluates the symbol.
+func (c *Calculator) ownsSymbol(symbol string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.owns(symbol)
+}
+
+// loadWindow makes sure an owned symbol has a window of at least size
+// klines, preferring the copy in the state store over the REST API.
+func (c *Calculator) loadWindow(ctx context.Context, exchange, market, symbol, interval string, size int) {
+	key := windowKey(exchange, market, symbol, interval)
+	if c.windowLen(key) >= size {
+		return
+	}
+	if c.store != nil {
+		w, err := c.store.LoadWindow(ctx, key)
+		if err != nil {
+			log.Printf("[window] load %s from store error: %v", key, err)
+		} else if len(w) >= size {
+			c.mu.Lock()
+			c.windows[key] = w
+			c.mu.Unlock()
+			return
+		}
+	}
+	arr, err := c.FetchLatest(ctx, exchange, market, symbol, interval, size)
+	if err != nil {
+		log.Printf("Hist fetch error for %s: %v", key, err)
+		return
+	}
+	log.Printf("[window] fetched %d historical klines for %s", len(arr), key)
+	c.setWindow(key, arr)
+}
+
+func (c *Calculator) windowLen(key string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.windows[key])
+}
+
+// Window returns a copy of the live window of an exchange, market, symbol
+// and interval, or nil if this instance doesn't keep one.
+func (c *Calculator) Window(exchange, market, symbol, interval string) []Kline {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.owns(symbol) {
+		return nil
+	}
+	return slices.Clone(c.windows[windowKey(exchange, market, symbol, interval)])
+}
+
+func (c *Calculator) setWindow(key string, window []Kline) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.windows[key] = window
+	c.dirtyWindows[key] = struct{}{}
+}
+
+// UpdateWindow applies a kline update to the shared window of an exchange,
+// market, symbol and interval and returns a snapshot of the window together with every job
+// subscribed to it. An update for the candle at the end of the window
+// replaces it; a newer candle is appended and the oldest one dropped once
+// the window is as long as the most demanding job needs. Stale updates and
+// symbols nobody watches return no jobs.
+func (c *Calculator) UpdateWindow(exchange, market, symbol, interval string, k Kline) ([]Kline, []*Job) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := windowKey(exchange, market, symbol, interval)
+	ids := c.subs[key]
+	if len(ids) == 0 || !c.owns(symbol) {
+		return nil, nil
+	}
+	jobs := make([]*Job, 0, len(ids))
+	size := 0
+	for id := range ids {
+		jobs = append(jobs, c.jobs[id])
+		size = max(size, c.jobs[id].WindowSize)
+	}
+
+	window := c.windows[key]
+	n := len(window)
+	var next []Kline
+	switch {
+	case n > 0 && k.OpenTime.Equal(window[n-1].OpenTime):
+		next = make([]Kline, n)
+		copy(next, window)
+		next[n-1] = k
+	case n == 0 || k.OpenTime.After(window[n-1].OpenTime):
+		drop := 0
+		if n >= size {
+			drop = n - size + 1
+		}
+		next = make([]Kline, 0, n-drop+1)
+		next = append(next, window[drop:]...)
+		next = append(next, k)
+		log.Printf("[window] appended kline for %s, window size now %d", key, len(next))
+	default:
+		log.Printf("[window] ignoring stale kline for %s at %s", key, k.OpenTime)
+		return nil, nil
+	}
+	c.windows[key] = next
+	c.dirtyWindows[key] = struct{}{}
+	return next, jobs
+}
+
+// MissingFrom reports whether applying k to the window of an exchange,
+// market, symbol and interval would leave a hole in it: candles between the
+// end of the window and k never arrived, or the last candle never got its
+// closing update. from is the open time of the first candle to backfill,
+// up to k.OpenTime, no further back than the window holds. Monthly
+// intervals vary in length and are not checked.
+func (c *Calculator) MissingFrom(exchange, market, symbol, interval string, k Kline) (from time.Time, missing bool) {
+	step, err := IntervalDuration(interval)
+	if err != nil || strings.HasSuffix(interval, "M") {
+		return time.Time{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := windowKey(exchange, market, symbol, interval)
+	window := c.windows[key]
+	if len(c.subs[key]) == 0 || !c.owns(symbol) || len(window) == 0 {
+		return time.Time{}, false
+	}
+	last := window[len(window)-1]
+	switch {
+	case !k.OpenTime.After(last.OpenTime):
+		return time.Time{}, false
+	case !last.IsClosed:
+		from = last.OpenTime
+	case k.OpenTime.After(last.OpenTime.Add(step)):
+		from = last.OpenTime.Add(step)
+	default:
+		return time.Time{}, false
+	}
+	// Candles older than the window holds would be dropped right away
+	size := 0
+	for id := range c.subs[key] {
+		size = max(size, c.jobs[id].WindowSize)
+	}
+	if oldest := k.OpenTime.Add(-time.Duration(size) * step); from.Before(oldest) {
+		from = oldest
+	}
+	return from, true
+}
+
+// Rebalance switches the set of symbols this instance owns. State is
+// flushed first so the new owners of symbols moving away find it in the
+// store; windows and previous values of those symbols are then dropped,
+// and those of newly owned symbols loaded from the store (or, for windows,
+// fetched from the REST API).
+func (c *Calculator) Rebalance(ctx context.Context, owns func(symbol string) bool) {
+	c.Flush(ctx)
+
+	type need struct {
+		exchange, market, symbol, interval string
+		size                               int
+	}
+	var needs []need
+	owned := make(map[string][]string) // job ID -> owned symbols
+
+	c.mu.Lock()
+	c.owns = owns
+	for key := range c.windows {
+		if _, _, sym, _ := parseWindowKey(key); !owns(sym) {
+			// Not marked dirty: the window now belongs to another instance
+			delete(c.windows, key)
+			delete(c.dirtyWindows, key)
+		}
+	}
+	for key, ids := range c.subs {
+		ex, market, sym, interval := parseWindowKey(key)
+		if !owns(sym) {
+			continue
+		}
+		n := need{exchange: ex, market: market, symbol: sym, interval: interval}
+		for id := range ids {
+			n.size = max(n.size, c.jobs[id].WindowSize)
+			owned[id] = append(owned[id], sym)
+		}
+		needs = append(needs, n)
+	}
+	c.mu.Unlock()
+
+	c.prevMu.Lock()
+	for id, bySym := range c.prevValues {
+		for sym := range bySym {
+			if !owns(sym) {
+				delete(bySym, sym)
+				delete(c.dirtyPrev[id], sym)
+			}
+		}
+	}
+	c.prevMu.Unlock()
+
+	for _, n := range needs {
+		c.loadWindow(ctx, n.exchange, n.market, n.symbol, n.interval, n.size)
+	}
+	for id, syms := range owned {
+		c.loadPrevious(ctx, id, syms)
+	}
+	log.Printf("[window] rebalanced: %d windows owned", len(needs))
+}
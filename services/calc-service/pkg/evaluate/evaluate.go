@@ -0,0 +1,213 @@
+// Package evaluate runs an analysis request once, right now, against the
+// current windows of a list of symbols without registering a job.
+package evaluate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ae144de/sonarbot-service-infra2/services/calc-service/pkg/calculator"
+	"github.com/ae144de/sonarbot-service-infra2/services/calc-service/pkg/processor"
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/events"
+)
+
+const (
+	// maxSymbols bounds the symbols one request evaluates.
+	maxSymbols = 100
+	// concurrency bounds the symbols evaluated, and fetched from the REST
+	// API, at the same time.
+	concurrency = 8
+	// DefaultTimeout and MaxTimeout bound a request's Timeout.
+	DefaultTimeout = 10 * time.Second
+	MaxTimeout     = time.Minute
+)
+
+// Sources of the window a symbol was evaluated on.
+const (
+	SourceLive    = "live"    // the window this instance keeps for running jobs
+	SourceHistory = "history" // fetched from the exchange's REST API
+)
+
+// Request is an AnalysisRequest evaluated on each of Symbols; without
+// Symbols its websocketKlineOptions.symbol is. Symbols not evaluated within
+// Timeout ("5s", default DefaultTimeout) report an error.
+type Request struct {
+	calculator.AnalysisRequest
+	Symbols []string `json:"symbols,omitempty"`
+	Timeout string   `json:"timeout,omitempty"`
+}
+
+// SymbolResult is the outcome for one symbol. Kline is the latest kline of
+// the window, which may still be open.
+type SymbolResult struct {
+	Symbol     string                  `json:"symbol"`
+	Source     string                  `json:"source,omitempty"`
+	Kline      *calculator.Kline       `json:"kline,omitempty"`
+	Met        bool                    `json:"met"`
+	Conditions *events.ConditionResult `json:"conditions,omitempty"`
+	Satisfied  []string                `json:"satisfied,omitempty"`
+	Error      string                  `json:"error,omitempty"`
+}
+
+// Result is the outcome of a request, one entry per symbol in request
+// order.
+type Result struct {
+	Exchange string         `json:"exchange"`
+	Market   string         `json:"market"`
+	Interval string         `json:"interval"`
+	Time     time.Time      `json:"time"`
+	Symbols  []SymbolResult `json:"symbols"`
+}
+
+func (req Request) symbols() []string {
+	if len(req.Symbols) > 0 {
+		return req.Symbols
+	}
+	return []string{req.WebsocketKlineOptions.Symbol}
+}
+
+// Validate checks the request like calculator.ValidateRequest, with every
+// entry of Symbols in place of websocketKlineOptions.symbol.
+func Validate(ctx context.Context, req Request) []calculator.FieldError {
+	var errs []calculator.FieldError
+	if req.Timeout != "" {
+		if d, err := time.ParseDuration(req.Timeout); err != nil || d <= 0 || d > MaxTimeout {
+			errs = append(errs, calculator.FieldError{Field: "timeout", Message: fmt.Sprintf("must be a duration up to %s", MaxTimeout)})
+		}
+	}
+	if req.Screener != nil {
+		errs = append(errs, calculator.FieldError{Field: "screener", Message: "screeners can't be evaluated on demand"})
+	}
+	symbols := req.symbols()
+	if len(symbols) > maxSymbols {
+		return append(errs, calculator.FieldError{Field: "symbols", Message: fmt.Sprintf("at most %d symbols", maxSymbols)})
+	}
+	for i, sym := range symbols {
+		field := "websocketKlineOptions.symbol"
+		if len(req.Symbols) > 0 {
+			field = fmt.Sprintf("symbols[%d]", i)
+		}
+		if sym == "ALL" {
+			errs = append(errs, calculator.FieldError{Field: field, Message: "ALL can't be evaluated on demand, list the symbols"})
+			continue
+		}
+		r := req.AnalysisRequest
+		r.WebsocketKlineOptions.Symbol = sym
+		for _, e := range calculator.ValidateRequest(ctx, r) {
+			switch {
+			case e.Field == "websocketKlineOptions.symbol":
+				e.Field = field
+				errs = append(errs, e)
+			case i == 0:
+				// The rest of the request is the same for every symbol
+				errs = append(errs, e)
+			}
+		}
+	}
+	return errs
+}
+
+// Run evaluates a validated request on every symbol. Windows come from
+// live, the calculator running the jobs, when it keeps a current one long
+// enough for the request, and from the exchange's REST API otherwise.
+// Crossings compare the latest kline against the one before it.
+func Run(ctx context.Context, live *calculator.Calculator, req Request) (Result, error) {
+	timeout := DefaultTimeout
+	if req.Timeout != "" {
+		timeout, _ = time.ParseDuration(req.Timeout)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	symbols := req.symbols()
+	job, err := calculator.CompileJob("evaluate", req.AnalysisRequest, symbols)
+	if err != nil {
+		return Result{}, err
+	}
+	step, err := calculator.IntervalDuration(job.Interval)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// A calculator of its own keeps the previous values crossings compare
+	// against apart from the live jobs
+	calc := calculator.NewCalculator(nil, nil)
+	res := Result{
+		Exchange: job.Exchange,
+		Market:   job.Market,
+		Interval: job.Interval,
+		Time:     time.Now(),
+		Symbols:  make([]SymbolResult, len(symbols)),
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, sym := range symbols {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				res.Symbols[i] = SymbolResult{Symbol: sym, Error: timeoutError(ctx.Err())}
+				return
+			}
+			res.Symbols[i] = evaluate(ctx, live, calc, job, sym, step)
+		}()
+	}
+	wg.Wait()
+	return res, nil
+}
+
+// evaluate evaluates the job on one symbol.
+func evaluate(ctx context.Context, live, calc *calculator.Calculator, job *calculator.Job, sym string, step time.Duration) SymbolResult {
+	out := SymbolResult{Symbol: sym, Source: SourceLive}
+	window := live.Window(job.Exchange, job.Market, sym, job.Interval)
+	if !current(window, job.WindowSize, step) {
+		var err error
+		out.Source = SourceHistory
+		window, err = calc.FetchLatest(ctx, job.Exchange, job.Market, sym, job.Interval, job.WindowSize)
+		if err != nil {
+			out.Error = timeoutError(err)
+			return out
+		}
+	}
+	if len(window) < 2 {
+		out.Error = calculator.ErrNotEnoughData.Error()
+		return out
+	}
+	if len(window) > job.WindowSize {
+		window = window[len(window)-job.WindowSize:]
+	}
+
+	// The kline before the latest seeds the previous values
+	processor.Snapshot(calc, job, sym, job.Interval, window[:len(window)-1])
+	met, conditions, satisfied := processor.Snapshot(calc, job, sym, job.Interval, window)
+	k := window[len(window)-1]
+	out.Kline = &k
+	out.Met = met
+	out.Conditions = &conditions
+	out.Satisfied = satisfied
+	return out
+}
+
+// current reports whether a live window is long enough and has seen the
+// candle open now or the one before it.
+func current(window []calculator.Kline, size int, step time.Duration) bool {
+	if len(window) < size {
+		return false
+	}
+	last := window[len(window)-1]
+	return time.Since(last.OpenTime) < 2*step
+}
+
+// timeoutError is the error reported for a symbol.
+func timeoutError(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timed out"
+	}
+	return err.Error()
+}
@@ -0,0 +1,78 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/ae144de/sonarbot-service-infra2/services/calc-service/pkg/calculator"
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/events"
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// backfillTimeout bounds the REST calls of a backfill. It runs inline in
+// HandleKline, so a slow or rate-limited exchange would otherwise stall
+// the partition's klines behind it.
+const backfillTimeout = 3 * time.Second
+
+// backfill fills the hole between the window of evt's symbol and the candle
+// opening at k.OpenTime with closed candles from the exchange's REST API,
+// so indicators never run over a window with missing candles. Whatever
+// can't be recovered within backfillTimeout is reported as a data quality
+// event; the window then carries on with the candles it has.
+func backfill(calcSvc *calculator.Calculator, ctx context.Context, evt events.KlineEvent, from time.Time, k calculator.Kline) {
+	step, err := calculator.IntervalDuration(evt.Interval)
+	if err != nil {
+		return
+	}
+	missing := int(k.OpenTime.Sub(from) / step)
+	log.Printf("processor: %d klines missing for %s:%s:%s:%s since %s, backfilling",
+		missing, evt.Exchange, evt.Market, evt.Symbol, evt.Interval, from)
+
+	fetchCtx, cancel := context.WithTimeout(ctx, backfillTimeout)
+	ks, err := calcSvc.FetchRange(fetchCtx, evt.Exchange, evt.Market, evt.Symbol, evt.Interval, from, k.OpenTime)
+	cancel()
+	filled := 0
+	for _, bk := range ks {
+		if !bk.IsClosed {
+			continue
+		}
+		calcSvc.UpdateWindow(evt.Exchange, evt.Market, evt.Symbol, evt.Interval, bk)
+		filled++
+	}
+	if err == nil && filled >= missing {
+		return
+	}
+
+	q := events.QualityEvent{
+		Version:  events.QualityVersion,
+		Type:     events.QualityGap,
+		Exchange: evt.Exchange,
+		Market:   evt.Market,
+		Symbol:   evt.Symbol,
+		Interval: evt.Interval,
+		From:     from,
+		To:       k.OpenTime,
+		Missing:  missing,
+		Filled:   filled,
+		Time:     time.Now(),
+	}
+	if err != nil {
+		q.Error = err.Error()
+	}
+	log.Printf("processor: gap in %s:%s:%s:%s not filled (%d/%d klines): %v",
+		evt.Exchange, evt.Market, evt.Symbol, evt.Interval, filled, missing, err)
+	w := calcSvc.QualityWriter()
+	if w == nil {
+		return
+	}
+	b, err := json.Marshal(q)
+	if err != nil {
+		log.Printf("processor: data quality event encode error: %v", err)
+		return
+	}
+	if err := w.WriteMessages(ctx, kafka.Message{Key: []byte(evt.Symbol), Value: b}); err != nil {
+		log.Printf("processor: data quality event publish error: %v", err)
+	}
+}
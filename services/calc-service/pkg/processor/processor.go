@@ -5,147 +5,266 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/ae144de/sonarbot-service-infra2/services/calc-service/pkg/calculator"
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/events"
 	kafka "github.com/segmentio/kafka-go"
 )
 
-// RawEvent represents the kline.raw message structure
-type RawEvent struct {
-	Data struct {
-		Symbol string `json:"s"`
-		K      struct {
-			Interval  string      `json:"i"`
-			IsClosed  bool        `json:"x"`
-			OpenTime  int64       `json:"t"`
-			Open      json.Number `json:"o"`
-			High      json.Number `json:"h"`
-			Low       json.Number `json:"l"`
-			Close     json.Number `json:"c"`
-			Volume    json.Number `json:"v"`
-			CloseTime int64       `json:"T"`
-		} `json:"k"`
-	} `json:"data"`
-}
-
-// HandleKline processes a single raw kline message:
-// - updates sliding window
-// - asynchronously computes all indicators
-// - evaluates alerts and publishes if conditions met
+// HandleKline processes a single kline.raw message:
+// - backfills candles missing between the window and the kline
+// - updates the sliding window shared by all jobs on the symbol/interval
+// - fans the kline out to every subscribed job
+// - evaluates each job's condition tree and publishes if its alert policy allows
+// - adds closed candles to the rounds of screener jobs
 func HandleKline(calcSvc *calculator.Calculator, ctx context.Context, raw []byte) {
-	// 1) Ham event’i parse et
-	// log.Printf("[processor] raw kline event: %s", string(raw))
-	var evt RawEvent
-	if err := json.Unmarshal(raw, &evt); err != nil {
-		log.Printf("processor: invalid raw event: %v", err)
+	// 1) Exchange-neutral kline event’i parse et (eski Binance formatı da okunur)
+	evt, err := events.DecodeKline(raw)
+	if err != nil {
+		log.Printf("processor: invalid kline event: %v", err)
 		return
 	}
 
-	sym := evt.Data.Symbol
-	interval := evt.Data.K.Interval
-	key := fmt.Sprintf("%s:%s", sym, interval)
+	sym := evt.Symbol
+	interval := evt.Interval
+
+	newK := calculator.Kline{
+		OpenTime:  evt.OpenTime,
+		Open:      evt.Open,
+		High:      evt.High,
+		Low:       evt.Low,
+		Close:     evt.Close,
+		Volume:    evt.Volume,
+		CloseTime: evt.CloseTime,
+		IsClosed:  evt.Closed,
+	}
+
+	// Fetcher reconnects and dropped messages leave holes; fill them from
+	// the REST API before the window is evaluated
+	if from, missing := calcSvc.MissingFrom(evt.Exchange, evt.Market, sym, interval, newK); missing {
+		backfill(calcSvc, ctx, evt, from, newK)
+	}
 
-	// Retrieve job
-	calcSvc.Mutex().Lock()
-	job, ok := calcSvc.Jobs()[key]
-	calcSvc.Mutex().Unlock()
-	if !ok {
-		// log.Printf("[processor] no active job for key %s, skipping", key)
+	// Update sliding window and retrieve the jobs watching it
+	window, jobs := calcSvc.UpdateWindow(evt.Exchange, evt.Market, sym, interval, newK)
+	if len(jobs) == 0 {
+		// log.Printf("[processor] no active job for %s:%s, skipping", sym, interval)
 		return
 	}
 
-	// Current window for this symbol
-	window := job.Windows[sym]
+	for _, job := range jobs {
+		// Screeners rank the symbols once their candles close
+		if job.IsScreener() {
+			if newK.IsClosed {
+				screenJob(calcSvc, ctx, job, sym, interval, window)
+			}
+			continue
+		}
+		// Jobs evaluating on candle close skip intrabar ticks, so their
+		// crossings compare close against close
+		if !job.EvaluatesOn(newK) {
+			continue
+		}
+		evaluateJob(calcSvc, ctx, job, sym, interval, window)
+	}
+}
 
-	// Build new Kline using raw timestamps
-	newK := calculator.Kline{
-		OpenTime:  time.UnixMilli(evt.Data.K.OpenTime),
-		Open:      calculator.ParseFloat(evt.Data.K.Open),
-		High:      calculator.ParseFloat(evt.Data.K.High),
-		Low:       calculator.ParseFloat(evt.Data.K.Low),
-		Close:     calculator.ParseFloat(evt.Data.K.Close),
-		Volume:    calculator.ParseFloat(evt.Data.K.Volume),
-		CloseTime: time.UnixMilli(evt.Data.K.CloseTime),
-		IsClosed:  evt.Data.K.IsClosed,
-	}
-
-	// Update sliding window
-	calcSvc.Mutex().Lock()
-	if newK.IsClosed {
-		window = append(window[1:], newK)
-		log.Printf("[processor] appended closed kline for %s, window size now %d", sym, len(job.Windows[sym]))
-	} else {
-		window[len(window)-1] = newK
-		log.Printf("[processor] updated open kline for %s", sym)
-	}
-	job.Windows[sym] = window
-	calcSvc.Mutex().Unlock()
-
-	// Asynchronous indicator computations
+// evaluateJob evaluates the job on the window and publishes an alert when
+// one is due.
+func evaluateJob(calcSvc *calculator.Calculator, ctx context.Context, job *calculator.Job, sym, interval string, window []calculator.Kline) {
+	evt, publish := Evaluate(calcSvc, job, sym, interval, window, time.Now())
+	if !publish {
+		return
+	}
+	log.Printf("processor: Conditions of job %s met for %s:%s, publishing alert", job.ID, sym, interval)
+	b, err := json.Marshal(evt)
+	if err != nil {
+		log.Printf("processor: alert encode error: %v", err)
+		return
+	}
+	msg := kafka.Message{Key: []byte(job.ID), Value: b}
+	if err := calcSvc.Writer().WriteMessages(ctx, msg); err != nil {
+		log.Printf("processor: alert publish error: %v", err)
+	}
+}
+
+// screenJob adds the symbol to the screener job's round; the job's
+// conditions, if any, decide whether it is ranked.
+func screenJob(calcSvc *calculator.Calculator, ctx context.Context, job *calculator.Job, sym, interval string, window []calculator.Kline) {
+	passed := true
+	if job.Conditions != nil {
+		_, result := evaluateConditions(calcSvc, job, sym, interval, window)
+		passed = result.Met
+	}
+	calcSvc.RecordScreen(ctx, job, sym, window, passed)
+}
+
+// Evaluate computes the job's condition leaves on the window, updating the
+// previous values and alert state kept in calcSvc, and reports whether the
+// job's alert policy lets an alert out at time now. Backtests replay
+// history through the same function.
+func Evaluate(calcSvc *calculator.Calculator, job *calculator.Job, sym, interval string, window []calculator.Kline, now time.Time) (events.AlertEvent, bool) {
+	leaves, result := evaluateConditions(calcSvc, job, sym, interval, window)
+
+	// Let the job's alert policy (re-arm, cooldown, rate limit) decide on
+	// publishing
+	if !calcSvc.ShouldAlert(job, sym, result.Met, now) {
+		if result.Met {
+			log.Printf("processor: Conditions of job %s met for %s:%s, alert suppressed by policy", job.ID, sym, interval)
+		}
+		return events.AlertEvent{}, false
+	}
+	return alertEvent(job, sym, interval, window, leaves, result, now), true
+}
+
+// Snapshot evaluates the job's conditions on the window like Evaluate but
+// leaves its alert policy out: it reports whether the conditions are met,
+// how each node evaluated and the satisfied leaves.
+func Snapshot(calcSvc *calculator.Calculator, job *calculator.Job, sym, interval string, window []calculator.Kline) (bool, events.ConditionResult, []string) {
+	leaves, result := evaluateConditions(calcSvc, job, sym, interval, window)
+	return result.Met, conditionTree(job, leaves, result), result.Satisfied()
+}
+
+// evaluateConditions computes the job's condition leaves on the window,
+// updating the previous values kept in calcSvc, and aggregates them
+// through the condition tree.
+func evaluateConditions(calcSvc *calculator.Calculator, job *calculator.Job, sym, interval string, window []calculator.Kline) ([]calculator.LeafResult, calculator.ConditionResult) {
+	// Asynchronous indicator computations, one per condition leaf
 	var wg sync.WaitGroup
-	resultsCh := make(chan bool, len(job.Indicators))
-	messagesCh := make(chan string, len(job.Indicators))
+	leaves := make([]calculator.LeafResult, len(job.Indicators))
 
-	for _, cfg := range job.Indicators {
+	for i, cfg := range job.Indicators {
 		wg.Add(1)
-		go func(cfg calculator.IndicatorConfig) {
+		go func(i int, cfg calculator.IndicatorConfig) {
 			defer wg.Done()
-			// Compute indicator value
-			log.Printf("[processor] computing %s for %s:%s", cfg.Name, sym, interval)
-			val := calculator.CalculateIndicator(window, cfg.Name, sym, interval, cfg.Params)
-			log.Printf("[processor] %s result for %s:%s = %.4f", cfg.Name, sym, interval, val)
-			// Previous value
-			prev := calcSvc.GetPrevious(sym, cfg.Name)
-			// Evaluate alert condition
-			met, err := calculator.EvaluateAlert(val, cfg.Threshold, cfg.Operator, prev)
+			// Compute both sides of the comparison
+			log.Printf("[processor] job %s: computing %s for %s:%s", job.ID, cfg.Name, sym, interval)
+			left, err := cfg.Left.Resolve(window, sym, interval)
 			if err != nil {
-				log.Printf("processor: EvaluateAlert error: %v", err)
-				resultsCh <- false
-				messagesCh <- fmt.Sprintf("%s error: %v", cfg.Name, err)
+				log.Printf("processor: left operand error: %v", err)
+				leaves[i] = errorLeaf(cfg, err)
 				return
 			}
-			// Log if individual indicator condition met
-			if met {
-				log.Printf("processor: Indicator '%s' met condition for %s:%s (value=%.4f, threshold=%.4f, operator=%s)", cfg.Name, sym, interval, val, cfg.Threshold, cfg.Operator)
+			right, err := cfg.Right.Resolve(window, sym, interval)
+			if err != nil {
+				log.Printf("processor: right operand error: %v", err)
+				leaves[i] = errorLeaf(cfg, err)
+				return
 			}
-			// Send details
-			messagesCh <- fmt.Sprintf("%s => current: %.4f, prev: %.4f, op: %s, thr: %.4f, met: %v",
-				cfg.Name, val, prev, cfg.Operator, cfg.Threshold, met)
-			resultsCh <- met
-			// Store for next iteration
-			calcSvc.SetPrevious(sym, cfg.Name, val)
-		}(cfg)
+			leaves[i] = evaluateLeaf(calcSvc, job.ID, cfg, sym, interval, left, right)
+		}(i, cfg)
 	}
 	wg.Wait()
-	close(resultsCh)
-	close(messagesCh)
-
-	// Aggregate results
-	allMet := true
-	for met := range resultsCh {
-		if !met {
-			allMet = false
-			break
-		}
+
+	return leaves, job.Conditions.Evaluate(leaves)
+}
+
+// alertEvent builds the alert.trigger event for a job whose conditions are
+// met on the latest kline of the window.
+func alertEvent(job *calculator.Job, sym, interval string, window []calculator.Kline, leaves []calculator.LeafResult, result calculator.ConditionResult, now time.Time) events.AlertEvent {
+	k := window[len(window)-1]
+	return events.AlertEvent{
+		Version:    events.AlertVersion,
+		JobID:      job.ID,
+		Owner:      job.Request.Owner,
+		Exchange:   job.Exchange,
+		Market:     job.Market,
+		Symbol:     sym,
+		Interval:   interval,
+		OpenTime:   k.OpenTime,
+		CloseTime:  k.CloseTime,
+		Closed:     k.IsClosed,
+		Price:      k.Close,
+		Evaluation: job.EvaluationMode(),
+		Conditions: conditionTree(job, leaves, result),
+		Satisfied:  result.Satisfied(),
+		Timestamp:  now,
 	}
-	if allMet {
-		log.Printf("processor: All indicators met for %s:%s, publishing alert", sym, interval)
-		details := ""
-		for msg := range messagesCh {
-			details += msg + ""
-		}
+}
 
-		alertPayload := map[string]interface{}{ // use map[string]interface{} to encode JSON
-			"symbol":     sym,
-			"interval":   interval,
-			"indicators": details,
-			"timestamp":  time.Now().Unix(),
-		}
-		b, _ := json.Marshal(alertPayload)
-		if err := calcSvc.Writer().WriteMessages(ctx, kafka.Message{Value: b}); err != nil {
-			log.Printf("processor: alert publish error: %v", err)
+// conditionTree converts the evaluated condition tree into its event form,
+// with the operand values of every leaf.
+func conditionTree(job *calculator.Job, leaves []calculator.LeafResult, result calculator.ConditionResult) events.ConditionResult {
+	byPath := make(map[string]int, len(job.Indicators))
+	for i, cfg := range job.Indicators {
+		byPath[cfg.ID] = i
+	}
+	return conditionEvent(job, byPath, leaves, result)
+}
+
+func conditionEvent(job *calculator.Job, byPath map[string]int, leaves []calculator.LeafResult, r calculator.ConditionResult) events.ConditionResult {
+	out := events.ConditionResult{Path: r.Path, Type: r.Type, Met: r.Met}
+	if i, ok := byPath[r.Path]; ok && r.Type == "LEAF" {
+		cfg, leaf := job.Indicators[i], leaves[i]
+		out.Name = cfg.Name
+		out.Operator = strings.ToUpper(cfg.Operator)
+		out.Value = finite(leaf.Left)
+		out.Threshold = finite(leaf.Right)
+		out.Prev = finite(leaf.PrevLeft)
+		out.PrevThreshold = finite(leaf.PrevRight)
+		if leaf.Err != nil {
+			out.Error = leaf.Err.Error()
 		}
 	}
+	for _, c := range r.Children {
+		out.Children = append(out.Children, conditionEvent(job, byPath, leaves, c))
+	}
+	return out
+}
+
+// finite returns nil for values JSON can't carry.
+func finite(v float64) *float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return &v
+}
+
+// errorLeaf is the result of a leaf whose operands couldn't be computed.
+func errorLeaf(cfg calculator.IndicatorConfig, err error) calculator.LeafResult {
+	nan := math.NaN()
+	return calculator.LeafResult{
+		Detail:    fmt.Sprintf("%s error: %v", cfg.Name, err),
+		Left:      nan,
+		Right:     nan,
+		PrevLeft:  nan,
+		PrevRight: nan,
+		Err:       err,
+	}
+}
+
+// evaluateLeaf compares the resolved operands against their previous values
+// and stores them for the next comparison.
+func evaluateLeaf(calcSvc *calculator.Calculator, jobID string, cfg calculator.IndicatorConfig, sym, interval string, left, right float64) calculator.LeafResult {
+	log.Printf("[processor] %s result for %s:%s = %.4f vs %.4f", cfg.Name, sym, interval, left, right)
+	// Previous values, tracked per job and per leaf
+	leftKey, rightKey := cfg.StateKeys()
+	prevLeft := calcSvc.GetPrevious(jobID, sym, leftKey)
+	prevRight := calcSvc.GetPrevious(jobID, sym, rightKey)
+	// Evaluate alert condition
+	met, err := calculator.EvaluateComparison(left, right, prevLeft, prevRight, cfg.Operator)
+	if err != nil {
+		log.Printf("processor: EvaluateComparison error: %v", err)
+		return errorLeaf(cfg, err)
+	}
+	// Log if individual indicator condition met
+	if met {
+		log.Printf("processor: Condition '%s' met for %s:%s (left=%.4f, right=%.4f)", cfg.Name, sym, interval, left, right)
+	}
+	// Store for next iteration
+	calcSvc.SetPrevious(jobID, sym, leftKey, left)
+	calcSvc.SetPrevious(jobID, sym, rightKey, right)
+	return calculator.LeafResult{
+		Met: met,
+		Detail: fmt.Sprintf("%s => current: %.4f/%.4f, prev: %.4f/%.4f, met: %v",
+			cfg.Name, left, right, prevLeft, prevRight, met),
+		Left:      left,
+		Right:     right,
+		PrevLeft:  prevLeft,
+		PrevRight: prevRight,
+	}
 }
@@ -0,0 +1,199 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/ae144de/sonarbot-service-infra2/services/calc-service/pkg/calculator"
+)
+
+// SchemaVersion is the version of the persisted state layout. Every key is
+// namespaced by it ("calc:v1:..."), so during a rolling upgrade instances
+// running different versions never read or overwrite each other's data.
+// Values also carry the version in their envelope and are skipped on mismatch.
+const SchemaVersion = 1
+
+// envelope wraps every stored value with the schema version that wrote it.
+type envelope struct {
+	Version int             `json:"v"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// Store persists calculator state in Redis.
+type Store struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewStore returns a Store on the given client.
+func NewStore(client *redis.Client) *Store {
+	return &Store{client: client, prefix: fmt.Sprintf("calc:v%d:", SchemaVersion)}
+}
+
+func (s *Store) key(parts ...string) string {
+	k := s.prefix
+	for i, p := range parts {
+		if i > 0 {
+			k += ":"
+		}
+		k += p
+	}
+	return k
+}
+
+func encode(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(envelope{Version: SchemaVersion, Data: data})
+}
+
+func decode(raw []byte, v interface{}) error {
+	var env envelope
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return err
+	}
+	if env.Version != SchemaVersion {
+		return fmt.Errorf("unsupported state version %d (want %d)", env.Version, SchemaVersion)
+	}
+	return json.Unmarshal(env.Data, v)
+}
+
+// SaveJob stores a job definition and adds it to the job index.
+func (s *Store) SaveJob(ctx context.Context, rec calculator.JobRecord) error {
+	b, err := encode(rec)
+	if err != nil {
+		return err
+	}
+	pipe := s.client.TxPipeline()
+	pipe.Set(ctx, s.key("job", rec.ID), b, 0)
+	pipe.SAdd(ctx, s.key("jobs"), rec.ID)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// DeleteJob removes a job definition and its previous values.
+func (s *Store) DeleteJob(ctx context.Context, id string) error {
+	pipe := s.client.TxPipeline()
+	pipe.Del(ctx, s.key("job", id))
+	pipe.SRem(ctx, s.key("jobs"), id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	return s.DeletePrevious(ctx, id)
+}
+
+// LoadJobs returns every stored job definition, skipping undecodable ones.
+func (s *Store) LoadJobs(ctx context.Context) ([]calculator.JobRecord, error) {
+	ids, err := s.client.SMembers(ctx, s.key("jobs")).Result()
+	if err != nil {
+		return nil, err
+	}
+	var recs []calculator.JobRecord
+	for _, id := range ids {
+		raw, err := s.client.Get(ctx, s.key("job", id)).Bytes()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var rec calculator.JobRecord
+		if err := decode(raw, &rec); err != nil {
+			log.Printf("[redis] skipping job %s: %v", id, err)
+			continue
+		}
+		recs = append(recs, rec)
+	}
+	return recs, nil
+}
+
+// SaveWindow stores the kline window of an exchange:market:symbol:interval.
+func (s *Store) SaveWindow(ctx context.Context, key string, window []calculator.Kline) error {
+	b, err := encode(window)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, s.key("window", key), b, 0).Err()
+}
+
+// DeleteWindow removes the kline window of an exchange:market:symbol:interval.
+func (s *Store) DeleteWindow(ctx context.Context, key string) error {
+	return s.client.Del(ctx, s.key("window", key)).Err()
+}
+
+// LoadWindow returns the stored window of an exchange:market:symbol:interval, or nil.
+func (s *Store) LoadWindow(ctx context.Context, key string) ([]calculator.Kline, error) {
+	raw, err := s.client.Get(ctx, s.key("window", key)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var w []calculator.Kline
+	if err := decode(raw, &w); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// SavePrevious replaces a job's previous values on one symbol; an empty set
+// removes them. Values are stored in a hash as plain floats; the hash key
+// carries the schema version.
+func (s *Store) SavePrevious(ctx context.Context, jobID, symbol string, values map[string]float64) error {
+	pipe := s.client.TxPipeline()
+	pipe.Del(ctx, s.key("prev", jobID, symbol))
+	if len(values) > 0 {
+		fields := make([]interface{}, 0, 2*len(values))
+		for k, v := range values {
+			fields = append(fields, k, strconv.FormatFloat(v, 'g', -1, 64))
+		}
+		pipe.HSet(ctx, s.key("prev", jobID, symbol), fields...)
+		pipe.SAdd(ctx, s.key("prevs", jobID), symbol)
+	} else {
+		pipe.SRem(ctx, s.key("prevs", jobID), symbol)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// DeletePrevious removes a job's previous values on every symbol.
+func (s *Store) DeletePrevious(ctx context.Context, jobID string) error {
+	syms, err := s.client.SMembers(ctx, s.key("prevs", jobID)).Result()
+	if err != nil {
+		return err
+	}
+	keys := []string{s.key("prevs", jobID)}
+	for _, sym := range syms {
+		keys = append(keys, s.key("prev", jobID, sym))
+	}
+	return s.client.Del(ctx, keys...).Err()
+}
+
+// LoadPrevious returns a job's previous values on one symbol.
+func (s *Store) LoadPrevious(ctx context.Context, jobID, symbol string) (map[string]float64, error) {
+	fields, err := s.client.HGetAll(ctx, s.key("prev", jobID, symbol)).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]float64, len(fields))
+	for k, v := range fields {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			log.Printf("[redis] skipping previous value %s of %s/%s: %v", k, jobID, symbol, err)
+			continue
+		}
+		out[k] = f
+	}
+	return out, nil
+}
+
+// Ensure Store satisfies the calculator's interface.
+var _ calculator.StateStore = (*Store)(nil)
@@ -0,0 +1,157 @@
+package shard
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+
+	"github.com/ae144de/sonarbot-service-infra2/services/calc-service/pkg/calculator"
+	"github.com/ae144de/sonarbot-service-infra2/services/calc-service/pkg/processor"
+)
+
+// PartitionFor returns the kline.raw partition a symbol's klines land on.
+// kline-fetcher keys every message by symbol and writes with kafka.Hash,
+// so this must use the same balancer.
+func PartitionFor(symbol string, partitions int) int {
+	ids := make([]int, partitions)
+	for i := range ids {
+		ids[i] = i
+	}
+	return (&kafka.Hash{}).Balance(kafka.Message{Key: []byte(symbol)}, ids...)
+}
+
+// Config configures the kline.raw consumer group.
+type Config struct {
+	Brokers     []string
+	GroupID     string
+	Topic       string
+	CommitEvery time.Duration
+}
+
+// Run joins the consumer group on kline.raw and feeds the klines of the
+// partitions assigned to this instance into the processor. On every
+// rebalance the calculator is switched to the symbols of the new
+// partitions before any of their klines are read. It returns when ctx is
+// cancelled.
+func Run(ctx context.Context, cfg Config, calcSvc *calculator.Calculator) error {
+	group, err := kafka.NewConsumerGroup(kafka.ConsumerGroupConfig{
+		ID:          cfg.GroupID,
+		Brokers:     cfg.Brokers,
+		Topics:      []string{cfg.Topic},
+		StartOffset: kafka.FirstOffset,
+	})
+	if err != nil {
+		return err
+	}
+	defer group.Close()
+
+	for {
+		// Next only returns once every reader of the previous generation
+		// has stopped, so no kline of a partition that moved away is
+		// processed after the rebalance below
+		gen, err := group.Next(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Printf("[shard] join error: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		n, err := partitionCount(cfg.Brokers, cfg.Topic)
+		for err != nil {
+			log.Printf("[shard] reading partitions of %s (will retry): %v", cfg.Topic, err)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(time.Second):
+			}
+			n, err = partitionCount(cfg.Brokers, cfg.Topic)
+		}
+		assigned := make(map[int]struct{})
+		for _, a := range gen.Assignments[cfg.Topic] {
+			assigned[a.ID] = struct{}{}
+		}
+		log.Printf("[shard] generation %d: %d of %d partitions assigned", gen.ID, len(assigned), n)
+		ids := make([]int, 0, len(assigned))
+		for id := range assigned {
+			ids = append(ids, id)
+		}
+		calcSvc.SetPartitions(ids, func(symbol string) int { return PartitionFor(symbol, n) })
+		calcSvc.Rebalance(ctx, func(symbol string) bool {
+			_, ok := assigned[PartitionFor(symbol, n)]
+			return ok
+		})
+
+		for _, a := range gen.Assignments[cfg.Topic] {
+			partition, offset := a.ID, a.Offset
+			gen.Start(func(ctx context.Context) {
+				consume(ctx, cfg, gen, partition, offset, calcSvc)
+			})
+		}
+	}
+}
+
+// consume reads one partition until the generation ends, committing the
+// offset at most every cfg.CommitEvery.
+func consume(ctx context.Context, cfg Config, gen *kafka.Generation, partition int, offset int64, calcSvc *calculator.Calculator) {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   cfg.Brokers,
+		Topic:     cfg.Topic,
+		Partition: partition,
+	})
+	defer reader.Close()
+	if err := reader.SetOffset(offset); err != nil {
+		log.Printf("[shard] partition %d: set offset %d: %v", partition, offset, err)
+		return
+	}
+
+	next := offset
+	commit := func() {
+		if next < 0 {
+			return
+		}
+		err := gen.CommitOffsets(map[string]map[int]int64{cfg.Topic: {partition: next}})
+		if err != nil {
+			log.Printf("[shard] partition %d: commit offset %d: %v", partition, next, err)
+		}
+	}
+	lastCommit := time.Now()
+	for {
+		m, err := reader.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				commit()
+				return
+			}
+			log.Printf("[shard] partition %d: read error: %v", partition, err)
+			continue
+		}
+		processor.HandleKline(calcSvc, ctx, m.Value)
+		next = m.Offset + 1
+		if time.Since(lastCommit) >= cfg.CommitEvery {
+			commit()
+			lastCommit = time.Now()
+		}
+	}
+}
+
+func partitionCount(brokers []string, topic string) (int, error) {
+	conn, err := kafka.Dial("tcp", brokers[0])
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	parts, err := conn.ReadPartitions(topic)
+	if err != nil {
+		return 0, err
+	}
+	if len(parts) == 0 {
+		return 0, fmt.Errorf("topic %s has no partitions", topic)
+	}
+	return len(parts), nil
+}
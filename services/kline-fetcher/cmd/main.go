@@ -2,62 +2,225 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"expvar"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/ae144de/sonarbot-service-infra2/services/kline-fetcher/pkg/fetcher"
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/events"
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/exchange"
 	kafka "github.com/segmentio/kafka-go"
 )
 
 func main() {
 	// 1) Load config from env
-	interval := os.Getenv("INTERVAL")
-	if interval == "" {
-		interval = "1m"
+	// EXCHANGE: binance (varsayılan), bybit ya da okx
+	// MARKET: usdm (USDⓈ-M futures, varsayılan), spot ya da coinm (COIN-M)
+	ex, err := exchange.Get(os.Getenv("EXCHANGE"), os.Getenv("MARKET"))
+	if err != nil {
+		log.Fatalf("Invalid exchange: %v", err)
+	}
+	// INTERVALS virgülle ayrılmış liste (ör. 1m,5m,1h); tüm interval’ların
+	// stream’leri aynı bağlantı havuzunu paylaşır. Eski INTERVAL da geçerli
+	intervals := fetcher.EnvAsList("INTERVALS", fetcher.EnvAsList("INTERVAL", []string{"1m"}))
+	if err := fetcher.ValidateIntervals(ex, intervals); err != nil {
+		log.Fatalf("Invalid intervals: %v", err)
+	}
+	// Gruplar opsiyonel: tek process tüm sembolleri bir bağlantı havuzunda
+	// taşır. Sembolleri process’lere bölmek için ya SYMBOL_GROUPS (ör. 1,3)
+	// / TOTAL_GROUPS verilir ya da SHARDING=kafka ile gruplar Kafka consumer
+	// group üyeliğiyle otomatik paylaşılır
+	totalGroups := fetcher.EnvAsInt("TOTAL_GROUPS", 1)
+	groups, err := fetcher.EnvAsInts("SYMBOL_GROUPS", []int{fetcher.EnvAsInt("SYMBOL_GROUP", 1)})
+	if err != nil {
+		log.Fatalf("Invalid group config: %v", err)
+	}
+	sharding := os.Getenv("SHARDING")
+	switch sharding {
+	case "", "static":
+		if err := fetcher.ValidateGroups(groups, totalGroups); err != nil {
+			log.Fatalf("Invalid group config: %v", err)
+		}
+	case "kafka":
+	default:
+		log.Fatalf("Unknown SHARDING mode %q (static or kafka)", sharding)
 	}
-	groupIdx := fetcher.EnvAsInt("SYMBOL_GROUP", 1)
-	totalGroups := fetcher.EnvAsInt("TOTAL_GROUPS", 3)
+	opts := fetcher.OptionsFor(ex)
+	opts.MaxStreamsPerConn = fetcher.EnvAsInt("MAX_STREAMS_PER_CONN", opts.MaxStreamsPerConn)
 
 	kafkaAddr := os.Getenv("KAFKA_ADDR")
 	topic := os.Getenv("KAFKA_TOPIC")
 
-	// 2) Kafka writer: async ve ACK beklemeden
-	writer := kafka.NewWriter(kafka.WriterConfig{
-		Brokers:      []string{kafkaAddr},
-		Topic:        topic,
-		Async:        true,                   // hemen döner
-		RequiredAcks: int(kafka.RequireNone), // ACK beklemez
-	})
+	// 2) Kafka writer: ACK seviyesi, batch, sıkıştırma ve backpressure
+	// PRODUCER_* / BACKPRESSURE env’leriyle ayarlanır; varsayılan ACK
+	// beklemeden
+	prod, err := fetcher.ProducerConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid producer config: %v", err)
+	}
+	writer := prod.Writer([]string{kafkaAddr}, topic)
 	defer writer.Close()
 
-	// 3) Buffered channel: WebSocket’ten gelen raw mesajları buraya it
-	msgCh := make(chan kafka.Message, 10_000)
+	// 3) Sınırlı kuyruk: WebSocket’ten gelen kline mesajlarını buraya it.
+	// Kafka yavaşlayınca WS okuması durup borsa bağlantıyı kesmesin diye
+	// dolu kuyrukta tick’ler stratejiye göre atılır ya da birleştirilir
+	queue := fetcher.NewQueue(prod.QueueSize, prod.Backpressure)
+	fetcher.Stats.Set("queueLength", expvar.Func(func() any { return queue.Len() }))
 
-	// 4) Tek goroutine: kanaldan okuyup Kafka’ya yazar
+	// 4) Tek goroutine: kuyruktan okuyup Kafka’ya yazar
+	produced := make(chan struct{})
 	go func() {
-		for msg := range msgCh {
-			if err := writer.WriteMessages(context.Background(), msg); err != nil {
-				log.Printf("[kafka-producer] write error: %v", err)
-			}
-		}
+		fetcher.Produce(queue, writer, prod)
+		close(produced)
 	}()
 
-	// 5) Sembolleri çek ve gruplandır
-	symbols, err := fetcher.GetAllFuturesSymbols()
+	// 5) Sembolleri çek
+	symbols, err := ex.Symbols(context.Background())
 	if err != nil {
 		log.Fatalf("Failed to fetch symbols: %v", err)
 	}
-	mySymbols := fetcher.GetSymbolsForGroup(symbols, groupIdx, totalGroups)
-	log.Printf("[%s group %d] subscribing to %d symbols", interval, groupIdx, len(mySymbols))
 
-	// 6) Shutdown context
+	// 6) İzleme: reconnect, drop ve coalesce sayaçları /debug/vars altında (expvar)
+	metricsAddr := os.Getenv("METRICS_ADDR")
+	if metricsAddr == "" {
+		metricsAddr = ":9100"
+	}
+	go func() {
+		if err := http.ListenAndServe(metricsAddr, nil); err != nil {
+			log.Printf("metrics server error: %v", err)
+		}
+	}()
+
+	// 7) Shutdown context
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	// 7) WS subscription & publish loop
-	if err := fetcher.SubscribeAndPublish(ctx, mySymbols, interval, msgCh); err != nil {
-		log.Fatalf("Subscription error: %v", err)
+	// 8) WS bağlantı havuzu; stream seti sembol listesi ya da sahip olunan
+	// gruplar her değiştiğinde yeniden hesaplanır
+	pool := fetcher.NewPool(ctx, queue, opts)
+	shards := &shardState{pool: pool, ex: ex, intervals: intervals, all: symbols, groups: groups, total: totalGroups}
+	label := ex.Name() + " " + ex.Market() + " " + strings.Join(intervals, ",")
+	if sharding == "kafka" {
+		// Grup ataması gelene kadar hiçbir sembol dinlenmez
+		shards.groups = nil
+		coordTopic := os.Getenv("COORDINATION_TOPIC")
+		if coordTopic == "" {
+			coordTopic = "kline-fetcher.groups"
+		}
+		go func() {
+			err := fetcher.Coordinate(ctx, []string{kafkaAddr}, "kline-fetcher-"+ex.Name()+"-"+ex.Market()+"-"+strings.Join(intervals, "-"), coordTopic,
+				func(groups []int, total int) {
+					mine := shards.setGroups(groups, total)
+					log.Printf("[%s groups %v/%d] streaming %d symbols", label, groups, total, len(mine))
+				})
+			if err != nil {
+				log.Fatalf("Coordination error: %v", err)
+			}
+		}()
+	} else {
+		mine := shards.setAll(symbols)
+		log.Printf("[%s groups %v/%d] subscribing to %d symbols", label, groups, totalGroups, len(mine))
+	}
+
+	// 9) Sembol listesini periyodik yenile; yeni listelenenlere abone ol,
+	// delist olanlardan çık. SYMBOL_EVENTS_TOPIC verilmişse değişiklikler
+	// symbol.events’e de yayınlanır (tek bir container’da açık olması yeterli)
+	var symbolWriter *kafka.Writer
+	if t := os.Getenv("SYMBOL_EVENTS_TOPIC"); t != "" {
+		symbolWriter = kafka.NewWriter(kafka.WriterConfig{
+			Brokers: []string{kafkaAddr},
+			Topic:   t,
+		})
+		defer symbolWriter.Close()
+	}
+	refresh := time.Duration(fetcher.EnvAsInt("SYMBOL_REFRESH_MINUTES", 10)) * time.Minute
+	go fetcher.WatchSymbols(ctx, ex, symbols, refresh, func(diff fetcher.SymbolDiff) {
+		mine := shards.setAll(diff.All)
+		log.Printf("[%s] now streaming %d symbols", label, len(mine))
+		if symbolWriter != nil {
+			publishSymbolEvents(ctx, symbolWriter, ex, diff)
+		}
+	})
+
+	<-ctx.Done()
+	pool.Wait()
+	// Kuyrukta kalanlar writer kapanmadan önce yazılır
+	queue.Close()
+	<-produced
+	log.Printf("[kline-fetcher] context done, exiting")
+}
+
+// shardState tüm semboller ile bu process’in sahip olduğu grupları tutar ve
+// havuzu bunlara eşitler. Sembol listesi ve grup ataması ayrı
+// goroutine’lerden değişir; hesaplama ile pool.Set aynı kilit altında
+// yapılır ki eski bir hesap yenisinin üstüne yazılmasın.
+type shardState struct {
+	mu        sync.Mutex
+	pool      *fetcher.Pool
+	ex        exchange.Adapter
+	intervals []string
+	all       []string
+	groups    []int
+	total     int
+}
+
+// setAll sembol listesini günceller ve bu process’in sembollerini döner.
+func (s *shardState) setAll(all []string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.all = all
+	return s.apply()
+}
+
+// setGroups sahip olunan grupları günceller ve bu process’in sembollerini döner.
+func (s *shardState) setGroups(groups []int, total int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.groups, s.total = groups, total
+	return s.apply()
+}
+
+// apply havuzu bu process’in sembollerinin interval’lardaki stream’lerine
+// eşitler. Interval’lar başlangıçta doğrulandığı için hata beklenmez.
+// s.mu tutulurken çağrılır.
+func (s *shardState) apply() []string {
+	mine := fetcher.SymbolsForGroups(s.all, s.groups, s.total)
+	streams, err := fetcher.KlineStreams(s.ex, mine, s.intervals)
+	if err != nil {
+		log.Printf("[kline-fetcher] %v", err)
+		return mine
+	}
+	s.pool.Set(streams)
+	return mine
+}
+
+// publishSymbolEvents sembol değişikliklerini symbol.events’e yazar.
+func publishSymbolEvents(ctx context.Context, w *kafka.Writer, ex exchange.Adapter, diff fetcher.SymbolDiff) {
+	now := time.Now()
+	var msgs []kafka.Message
+	add := func(typ string, syms []string) {
+		for _, sym := range syms {
+			b, _ := json.Marshal(events.SymbolEvent{
+				Version:  events.SymbolVersion,
+				Type:     typ,
+				Symbol:   sym,
+				Exchange: ex.Name(),
+				Market:   ex.Market(),
+				Time:     now,
+			})
+			msgs = append(msgs, kafka.Message{Key: []byte(sym), Value: b})
+		}
+	}
+	add(events.SymbolListed, diff.Listed)
+	add(events.SymbolDelisted, diff.Delisted)
+	if err := w.WriteMessages(ctx, msgs...); err != nil {
+		log.Printf("[symbols] publish error: %v", err)
 	}
 }
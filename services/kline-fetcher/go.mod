@@ -3,6 +3,7 @@ module github.com/ae144de/sonarbot-service-infra2/services/kline-fetcher
 go 1.24.1
 
 require (
+	github.com/ae144de/sonarbot-service-infra2/services/services v0.0.0-20250516170218-c5f096f9e3b6
 	github.com/gorilla/websocket v1.5.3
 	github.com/segmentio/kafka-go v0.4.48
 )
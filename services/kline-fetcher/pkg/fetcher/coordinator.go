@@ -0,0 +1,77 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// Coordinate lets fetchers of the same interval share the symbol groups
+// through Kafka consumer group membership instead of static env vars. Every
+// partition of topic stands for one group; each time membership changes,
+// onAssign gets the groups (1-based) this process owns and the total
+// number of groups. It returns when ctx is cancelled.
+//
+// Nothing is read from topic; it only carries the partition count.
+func Coordinate(ctx context.Context, brokers []string, groupID, topic string, onAssign func(groups []int, total int)) error {
+	group, err := kafka.NewConsumerGroup(kafka.ConsumerGroupConfig{
+		ID:      groupID,
+		Brokers: brokers,
+		Topics:  []string{topic},
+	})
+	if err != nil {
+		return err
+	}
+	defer group.Close()
+
+	for {
+		// Next returns once the previous generation has ended, i.e. after
+		// a member joined or left
+		gen, err := group.Next(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Printf("[coordinator] join error: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		total, err := partitionCount(brokers, topic)
+		for err != nil {
+			log.Printf("[coordinator] reading partitions of %s (will retry): %v", topic, err)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(time.Second):
+			}
+			total, err = partitionCount(brokers, topic)
+		}
+		var groups []int
+		for _, a := range gen.Assignments[topic] {
+			groups = append(groups, a.ID+1)
+		}
+		sort.Ints(groups)
+		log.Printf("[coordinator] generation %d: owning groups %v of %d", gen.ID, groups, total)
+		onAssign(groups, total)
+	}
+}
+
+func partitionCount(brokers []string, topic string) (int, error) {
+	conn, err := kafka.Dial("tcp", brokers[0])
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	parts, err := conn.ReadPartitions(topic)
+	if err != nil {
+		return 0, err
+	}
+	if len(parts) == 0 {
+		return 0, fmt.Errorf("topic %s has no partitions", topic)
+	}
+	return len(parts), nil
+}
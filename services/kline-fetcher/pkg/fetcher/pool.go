@@ -0,0 +1,116 @@
+package fetcher
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// Pool stream’leri, her biri en fazla MaxStreamsPerConn stream taşıyan
+// bağlantılara dağıtır. Stream seti çalışırken değiştirilebilir; yeni
+// stream’ler boş yeri olan bağlantılara eklenir, gerekirse yeni bağlantı açılır.
+type Pool struct {
+	ctx  context.Context
+	opts Options
+	out  *Queue
+	wg   sync.WaitGroup
+
+	mu    sync.Mutex
+	conns []*streamConn
+	owner map[string]*streamConn // stream -> bağlantı
+	count map[*streamConn]int    // bağlantı -> stream sayısı
+}
+
+// NewPool ctx bitene kadar yaşayan boş bir havuz döner.
+func NewPool(ctx context.Context, out *Queue, opts Options) *Pool {
+	return &Pool{
+		ctx:   ctx,
+		opts:  opts,
+		out:   out,
+		owner: make(map[string]*streamConn),
+		count: make(map[*streamConn]int),
+	}
+}
+
+// Set havuzdaki stream setini verilen sete eşitler.
+func (p *Pool) Set(streams []string) {
+	p.mu.Lock()
+	want := make(map[string]struct{}, len(streams))
+	var add []string
+	for _, st := range streams {
+		want[st] = struct{}{}
+		if _, ok := p.owner[st]; !ok {
+			add = append(add, st)
+		}
+	}
+	var remove []string
+	for st := range p.owner {
+		if _, ok := want[st]; !ok {
+			remove = append(remove, st)
+		}
+	}
+	p.mu.Unlock()
+	p.Unsubscribe(remove)
+	p.Subscribe(add)
+}
+
+// Subscribe stream’leri boş yeri olan bağlantılara ekler.
+func (p *Pool) Subscribe(streams []string) {
+	p.mu.Lock()
+	plan := make(map[*streamConn][]string)
+	for _, st := range streams {
+		if _, ok := p.owner[st]; ok {
+			continue
+		}
+		conn := p.freeConn()
+		p.owner[st] = conn
+		p.count[conn]++
+		plan[conn] = append(plan[conn], st)
+	}
+	p.mu.Unlock()
+	for conn, add := range plan {
+		conn.update(add, nil)
+	}
+}
+
+// freeConn boş yeri olan ilk bağlantıyı döner, yoksa yenisini açar.
+// Callers hold p.mu.
+func (p *Pool) freeConn() *streamConn {
+	for _, c := range p.conns {
+		if p.count[c] < p.opts.MaxStreamsPerConn {
+			return c
+		}
+	}
+	c := newStreamConn(len(p.conns)+1, p.out, p.opts)
+	p.conns = append(p.conns, c)
+	Stats.Add("connections", 1)
+	log.Printf("[kline-fetcher] opening connection %d", c.id)
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		c.run(p.ctx)
+	}()
+	return c
+}
+
+// Unsubscribe stream’leri bağlı oldukları bağlantılardan çıkarır.
+func (p *Pool) Unsubscribe(streams []string) {
+	p.mu.Lock()
+	plan := make(map[*streamConn][]string)
+	for _, st := range streams {
+		conn, ok := p.owner[st]
+		if !ok {
+			continue
+		}
+		delete(p.owner, st)
+		p.count[conn]--
+		plan[conn] = append(plan[conn], st)
+	}
+	p.mu.Unlock()
+	for conn, remove := range plan {
+		conn.update(nil, remove)
+	}
+}
+
+// Wait havuzdaki tüm bağlantıların kapanmasını bekler.
+func (p *Pool) Wait() { p.wg.Wait() }
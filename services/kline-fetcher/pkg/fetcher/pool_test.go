@@ -0,0 +1,220 @@
+package fetcher
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/exchange"
+	"github.com/gorilla/websocket"
+)
+
+// testExchange DefaultOptions’ın borsasını test sunucusuna bağlar.
+type testExchange struct {
+	exchange.Adapter
+	url string
+}
+
+func (e testExchange) WebsocketURL() string { return e.url }
+
+// request sunucunun bir bağlantıdan aldığı SUBSCRIBE/UNSUBSCRIBE isteği.
+type request struct {
+	conn    int // sunucunun bağlantıya verdiği sıra numarası
+	method  string
+	streams []string
+}
+
+// wsServer aldığı kontrol isteklerini requests’e yazan bir WebSocket sunucusu.
+type wsServer struct {
+	*httptest.Server
+	requests chan request
+}
+
+func newWSServer(t *testing.T) *wsServer {
+	t.Helper()
+	s := &wsServer{requests: make(chan request, 100)}
+	var (
+		mu    sync.Mutex
+		conns int
+	)
+	upgrader := websocket.Upgrader{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		mu.Lock()
+		conns++
+		id := conns
+		mu.Unlock()
+		for {
+			_, msg, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			var req struct {
+				Method string   `json:"method"`
+				Params []string `json:"params"`
+			}
+			if err := json.Unmarshal(msg, &req); err != nil {
+				t.Errorf("unparsable request %s", msg)
+				continue
+			}
+			slices.Sort(req.Params)
+			s.requests <- request{conn: id, method: req.Method, streams: req.Params}
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// next n isteği bekler.
+func (s *wsServer) next(t *testing.T, n int) []request {
+	t.Helper()
+	var out []request
+	for len(out) < n {
+		select {
+		case r := <-s.requests:
+			out = append(out, r)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d requests, want %d: %+v", len(out), n, out)
+		}
+	}
+	return out
+}
+
+// none başka istek gelmediğini doğrular.
+func (s *wsServer) none(t *testing.T) {
+	t.Helper()
+	select {
+	case r := <-s.requests:
+		t.Errorf("unexpected request %+v", r)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func testPool(t *testing.T, s *wsServer, maxStreams, perRequest int) *Pool {
+	t.Helper()
+	opts := DefaultOptions
+	opts.Exchange = testExchange{Adapter: DefaultOptions.Exchange, url: "ws" + strings.TrimPrefix(s.URL, "http")}
+	opts.MaxStreamsPerConn = maxStreams
+	opts.StreamsPerRequest = perRequest
+	opts.RequestInterval = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	p := NewPool(ctx, NewQueue(10, BackpressureDrop), opts)
+	t.Cleanup(func() {
+		cancel()
+		p.Wait()
+	})
+	return p
+}
+
+// byConn istekleri bağlantılarına göre gruplar.
+func byConn(reqs []request) map[int][]request {
+	out := make(map[int][]request)
+	for _, r := range reqs {
+		out[r.conn] = append(out[r.conn], r)
+	}
+	return out
+}
+
+func TestPoolSetDiffs(t *testing.T) {
+	s := newWSServer(t)
+	p := testPool(t, s, 2, 50)
+
+	// İlk set bağlantı başına MaxStreamsPerConn’luk parçalara bölünür ve
+	// bağlanınca tek SUBSCRIBE ile gönderilir
+	p.Set([]string{"a", "b", "c"})
+	initial := byConn(s.next(t, 2))
+	if len(initial) != 2 {
+		t.Fatalf("streams sent on %d connections, want 2: %+v", len(initial), initial)
+	}
+	var full, half int
+	for id, reqs := range initial {
+		if len(reqs) != 1 || reqs[0].method != "SUBSCRIBE" {
+			t.Fatalf("conn %d got %+v, want one SUBSCRIBE", id, reqs)
+		}
+		switch streams := reqs[0].streams; {
+		case reflect.DeepEqual(streams, []string{"a", "b"}):
+			full = id
+		case reflect.DeepEqual(streams, []string{"c"}):
+			half = id
+		default:
+			t.Fatalf("conn %d subscribed to %q", id, streams)
+		}
+	}
+	if full == 0 || half == 0 {
+		t.Fatalf("streams split as %+v, want [a b] and [c]", initial)
+	}
+
+	// Sadece fark gönderilir: a’dan çıkılır, d a’nın boşalttığı yere eklenir
+	p.Set([]string{"b", "c", "d"})
+	want := []request{
+		{conn: full, method: "UNSUBSCRIBE", streams: []string{"a"}},
+		{conn: full, method: "SUBSCRIBE", streams: []string{"d"}},
+	}
+	if got := s.next(t, 2); !reflect.DeepEqual(got, want) {
+		t.Errorf("diff requests = %+v, want %+v", got, want)
+	}
+	s.none(t)
+
+	// Aynı set hiçbir istek üretmez
+	p.Set([]string{"d", "c", "b"})
+	s.none(t)
+
+	// Boş set her bağlantıdaki stream’lerden çıkar
+	p.Set(nil)
+	got := byConn(s.next(t, 2))
+	wantUnsub := map[int][]request{
+		full: {{conn: full, method: "UNSUBSCRIBE", streams: []string{"b", "d"}}},
+		half: {{conn: half, method: "UNSUBSCRIBE", streams: []string{"c"}}},
+	}
+	if !reflect.DeepEqual(got, wantUnsub) {
+		t.Errorf("unsubscribe requests = %+v, want %+v", got, wantUnsub)
+	}
+	s.none(t)
+}
+
+func TestPoolSetSplitsRequests(t *testing.T) {
+	s := newWSServer(t)
+	p := testPool(t, s, 10, 2)
+
+	// İlk abonelik StreamsPerRequest’lik isteklere bölünür
+	p.Set([]string{"a", "b", "c"})
+	reqs := s.next(t, 2)
+	var streams []string
+	for _, r := range reqs {
+		if r.method != "SUBSCRIBE" || len(r.streams) > 2 {
+			t.Errorf("request %+v, want SUBSCRIBE of at most 2 streams", r)
+		}
+		streams = append(streams, r.streams...)
+	}
+	slices.Sort(streams)
+	if !reflect.DeepEqual(streams, []string{"a", "b", "c"}) {
+		t.Errorf("subscribed to %q, want [a b c]", streams)
+	}
+
+	// Canlı değişiklikler de bölünür
+	p.Set([]string{"a", "b", "c", "d", "e", "f"})
+	reqs = s.next(t, 2)
+	streams = nil
+	for _, r := range reqs {
+		if r.method != "SUBSCRIBE" || len(r.streams) > 2 {
+			t.Errorf("request %+v, want SUBSCRIBE of at most 2 streams", r)
+		}
+		streams = append(streams, r.streams...)
+	}
+	slices.Sort(streams)
+	if !reflect.DeepEqual(streams, []string{"d", "e", "f"}) {
+		t.Errorf("subscribed to %q, want [d e f]", streams)
+	}
+	s.none(t)
+}
@@ -0,0 +1,320 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// Backpressure stratejileri: Kafka yetişemeyip kuyruk dolduğunda WS okuma
+// döngüsünün ne yapacağı. Kapanmış mumlar hiçbir stratejide atılmaz; yer
+// açılana kadar beklerler.
+const (
+	// BackpressureBlock her mesaj için yer açılmasını bekler. Uzun süren
+	// beklemede borsa okunmayan bağlantıyı keser.
+	BackpressureBlock = "block"
+	// BackpressureDrop kuyruk doluyken gelen kapanmamış tick’leri atar.
+	BackpressureDrop = "drop"
+	// BackpressureCoalesce kuyrukta bekleyen tick’i aynı stream’in yeni
+	// tick’iyle değiştirir; birleştirilemeyen tick’ler kuyruk doluyken atılır.
+	BackpressureCoalesce = "coalesce"
+)
+
+// Teslim garantileri: Kafka yazması MaxAttempts denemede başarısız olursa
+// batch’e ne olacağı.
+const (
+	// DeliveryBestEffort başarısız batch’i sayar ve atar.
+	DeliveryBestEffort = "best-effort"
+	// DeliveryAtLeastOnce ACK’i all’a çeker ve başarısız batch’i yazılana
+	// kadar artan beklemeyle yeniden dener; bu sırada yeni mesajlar Queue’da
+	// birikir. kafka-go idempotent producer’ı (producer ID ve sequence
+	// number) desteklemediği için retry’lar mesajları çoğaltabilir. Bu
+	// zararsızdır: her kline mesajı mumun tam hâlidir ve calc-service mumları
+	// açılış zamanına göre değiştirir. Havada tek batch olduğundan sıra
+	// bozulmaz.
+	DeliveryAtLeastOnce = "at-least-once"
+)
+
+// Yeniden deneme beklemesinin alt ve üst sınırı.
+const (
+	retryMin = 100 * time.Millisecond
+	retryMax = 5 * time.Second
+)
+
+// ProducerConfig kline.raw producer’ının teslim garantileri, batch ayarları
+// ve backpressure stratejisi. Sıfır değerler kafka-go varsayılanlarıdır.
+//
+// Yazmalar senkrondur: Produce kuyruktan en fazla BatchSize mesaj alır ve
+// yazılana kadar bekler. kafka-go’nun async writer’ı mesajları sınırsız iç
+// kuyruğuna alıp hemen döndüğü için Queue hiç dolmaz, backpressure
+// stratejileri devreye girmez ve Kafka yavaşken bellek sınırsız büyürdü.
+type ProducerConfig struct {
+	Acks         kafka.RequiredAcks
+	BatchSize    int // Produce’un kuyruktan bir seferde aldığı mesaj sayısı
+	BatchBytes   int64
+	BatchTimeout time.Duration
+	Compression  kafka.Compression // 0: sıkıştırma yok
+	MaxAttempts  int
+	Delivery     string // Delivery* garantilerinden biri
+
+	QueueSize    int    // WS okuma döngüleri ile producer arasındaki kuyruk
+	Backpressure string // Backpressure* stratejilerinden biri
+}
+
+// DefaultProducerConfig ACK beklemeyen writer ve 10.000’lik kuyruk; kuyruk
+// dolunca tick’ler birleştirilir. Kısa BatchTimeout, BatchSize’dan küçük
+// batch’lerin kafka-go’nun 1 saniyelik varsayılanını beklemesini önler.
+var DefaultProducerConfig = ProducerConfig{
+	Acks:         kafka.RequireNone,
+	BatchSize:    100,
+	BatchTimeout: 10 * time.Millisecond,
+	QueueSize:    10_000,
+	Delivery:     DeliveryBestEffort,
+	Backpressure: BackpressureCoalesce,
+}
+
+var compressions = map[string]kafka.Compression{
+	"none":   0,
+	"gzip":   kafka.Gzip,
+	"snappy": kafka.Snappy,
+	"lz4":    kafka.Lz4,
+	"zstd":   kafka.Zstd,
+}
+
+// ProducerConfigFromEnv DefaultProducerConfig’i PRODUCER_ACKS (none, one,
+// all), PRODUCER_BATCH_SIZE, PRODUCER_BATCH_BYTES, PRODUCER_BATCH_TIMEOUT,
+// PRODUCER_COMPRESSION (none, gzip, snappy, lz4, zstd),
+// PRODUCER_MAX_ATTEMPTS, PRODUCER_DELIVERY (best-effort, at-least-once),
+// PRODUCER_QUEUE_SIZE ve BACKPRESSURE (block, drop, coalesce) env’leriyle
+// günceller.
+func ProducerConfigFromEnv() (ProducerConfig, error) {
+	c := DefaultProducerConfig
+	if v := os.Getenv("PRODUCER_ACKS"); v != "" {
+		switch strings.ToLower(v) {
+		case "none", "0":
+			c.Acks = kafka.RequireNone
+		case "one", "leader", "1":
+			c.Acks = kafka.RequireOne
+		case "all", "-1":
+			c.Acks = kafka.RequireAll
+		default:
+			return c, fmt.Errorf("PRODUCER_ACKS: unknown level %q (none, one or all)", v)
+		}
+	}
+	var err error
+	c.BatchSize = EnvAsInt("PRODUCER_BATCH_SIZE", c.BatchSize)
+	if c.BatchSize <= 0 {
+		return c, fmt.Errorf("PRODUCER_BATCH_SIZE must be positive")
+	}
+	c.BatchBytes = int64(EnvAsInt("PRODUCER_BATCH_BYTES", int(c.BatchBytes)))
+	if v := os.Getenv("PRODUCER_BATCH_TIMEOUT"); v != "" {
+		if c.BatchTimeout, err = time.ParseDuration(v); err != nil {
+			return c, fmt.Errorf("PRODUCER_BATCH_TIMEOUT: %w", err)
+		}
+	}
+	if v := os.Getenv("PRODUCER_COMPRESSION"); v != "" {
+		codec, ok := compressions[strings.ToLower(v)]
+		if !ok {
+			return c, fmt.Errorf("PRODUCER_COMPRESSION: unknown codec %q", v)
+		}
+		c.Compression = codec
+	}
+	c.MaxAttempts = EnvAsInt("PRODUCER_MAX_ATTEMPTS", c.MaxAttempts)
+	if v := os.Getenv("PRODUCER_DELIVERY"); v != "" {
+		c.Delivery = strings.ToLower(v)
+	}
+	switch c.Delivery {
+	case DeliveryBestEffort:
+	case DeliveryAtLeastOnce:
+		if os.Getenv("PRODUCER_ACKS") != "" && c.Acks != kafka.RequireAll {
+			return c, fmt.Errorf("PRODUCER_DELIVERY=at-least-once requires PRODUCER_ACKS=all")
+		}
+		c.Acks = kafka.RequireAll
+	default:
+		return c, fmt.Errorf("PRODUCER_DELIVERY: unknown guarantee %q (best-effort or at-least-once)", c.Delivery)
+	}
+	c.QueueSize = EnvAsInt("PRODUCER_QUEUE_SIZE", c.QueueSize)
+	if c.QueueSize <= 0 {
+		return c, fmt.Errorf("PRODUCER_QUEUE_SIZE must be positive")
+	}
+	if v := os.Getenv("BACKPRESSURE"); v != "" {
+		c.Backpressure = strings.ToLower(v)
+	}
+	switch c.Backpressure {
+	case BackpressureBlock, BackpressureDrop, BackpressureCoalesce:
+	default:
+		return c, fmt.Errorf("BACKPRESSURE: unknown strategy %q (block, drop or coalesce)", c.Backpressure)
+	}
+	return c, nil
+}
+
+// Writer topic’e sembol key’iyle senkron yazan writer’ı döner; aynı sembol
+// hep aynı partition’a gider.
+func (c ProducerConfig) Writer(brokers []string, topic string) *kafka.Writer {
+	return &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: c.Acks,
+		BatchSize:    c.BatchSize,
+		BatchBytes:   c.BatchBytes,
+		BatchTimeout: c.BatchTimeout,
+		Compression:  c.Compression,
+		MaxAttempts:  c.MaxAttempts,
+	}
+}
+
+// queued kuyruktaki bir mesaj.
+type queued struct {
+	msg    kafka.Message
+	stream string // birleştirilebilir tick’in stream’i; diğerlerinde boş
+}
+
+// Queue WS okuma döngüleri ile Kafka producer’ı arasındaki sınırlı kuyruk.
+// Mesajlar sırasıyla çıkar; birleştirilen tick kuyruktaki yerini korur.
+type Queue struct {
+	size     int
+	strategy string
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	items  []queued
+	base   int64            // items[0]’ın sıra numarası
+	ticks  map[string]int64 // stream -> birleştirilebilir tick’in sıra numarası
+	closed bool
+}
+
+// NewQueue size mesajlık, Backpressure* stratejilerinden biriyle çalışan
+// bir kuyruk döner.
+func NewQueue(size int, strategy string) *Queue {
+	q := &Queue{size: size, strategy: strategy, ticks: make(map[string]int64)}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// Push bir stream’in (sembol ve interval) kline mesajını kuyruğa ekler;
+// closed mumun kapanıp kapanmadığıdır. Kuyruk doluysa stratejiye göre
+// tick’i atar, birleştirir ya da yer açılmasını bekler. Kapatılmış kuyruk
+// mesajı atar.
+func (q *Queue) Push(msg kafka.Message, stream string, closed bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !closed && q.strategy == BackpressureCoalesce {
+		if seq, ok := q.ticks[stream]; ok {
+			q.items[seq-q.base].msg = msg
+			Stats.Add("coalesced", 1)
+			return
+		}
+	}
+	if len(q.items) >= q.size {
+		if !closed && q.strategy != BackpressureBlock {
+			Stats.Add("dropped", 1)
+			return
+		}
+		Stats.Add("blocked", 1)
+		for len(q.items) >= q.size && !q.closed {
+			q.cond.Wait()
+		}
+	}
+	if q.closed {
+		return
+	}
+	item := queued{msg: msg}
+	if q.strategy == BackpressureCoalesce {
+		if closed {
+			// Sonraki mumun tick’leri kapanıştan önceye birleşmesin
+			delete(q.ticks, stream)
+		} else {
+			item.stream = stream
+			q.ticks[stream] = q.base + int64(len(q.items))
+		}
+	}
+	q.items = append(q.items, item)
+	q.cond.Broadcast()
+}
+
+// Pop en fazla max mesajı sırasıyla çıkarır, kuyruk boşsa bekler.
+// Kuyruk kapatılmış ve boşalmışsa nil döner.
+func (q *Queue) Pop(max int) []kafka.Message {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.items) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	n := len(q.items)
+	if max > 0 {
+		n = min(n, max)
+	}
+	if n == 0 {
+		return nil
+	}
+	out := make([]kafka.Message, n)
+	for i, it := range q.items[:n] {
+		out[i] = it.msg
+		if it.stream != "" && q.ticks[it.stream] == q.base+int64(i) {
+			delete(q.ticks, it.stream)
+		}
+	}
+	q.items = q.items[n:]
+	q.base += int64(n)
+	q.cond.Broadcast()
+	return out
+}
+
+// Len kuyrukta bekleyen mesaj sayısı.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// isClosed kuyruğun kapatılıp kapatılmadığını döner.
+func (q *Queue) isClosed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.closed
+}
+
+// Close kuyruğu kapatır; bekleyen Push’lar döner, Pop kalanları boşaltır.
+func (q *Queue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// Produce kuyruk kapatılıp boşalana kadar mesajları en fazla BatchSize’lık
+// gruplar hâlinde w’ye yazar. Her yazma bitmeden sonraki grup alınmaz;
+// Kafka yavaşlarsa mesajlar Queue’da birikir ve backpressure stratejisi
+// devreye girer. At-least-once’ta başarısız grup, kuyruk kapatılana kadar
+// yeniden denenir.
+func Produce(q *Queue, w *kafka.Writer, c ProducerConfig) {
+	for {
+		msgs := q.Pop(c.BatchSize)
+		if msgs == nil {
+			return
+		}
+		wait := retryMin
+		for {
+			err := w.WriteMessages(context.Background(), msgs...)
+			if err == nil {
+				Stats.Add("published", int64(len(msgs)))
+				break
+			}
+			log.Printf("[kafka-producer] write error: %v", err)
+			if c.Delivery != DeliveryAtLeastOnce || q.isClosed() {
+				Stats.Add("failedMessages", int64(len(msgs)))
+				break
+			}
+			Stats.Add("retries", 1)
+			time.Sleep(wait)
+			wait = min(2*wait, retryMax)
+		}
+	}
+}
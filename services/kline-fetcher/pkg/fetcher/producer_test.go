@@ -0,0 +1,236 @@
+package fetcher
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+func msg(v string) kafka.Message { return kafka.Message{Value: []byte(v)} }
+
+func values(msgs []kafka.Message) []string {
+	out := make([]string, len(msgs))
+	for i, m := range msgs {
+		out[i] = string(m.Value)
+	}
+	return out
+}
+
+// pushed p’yi ayrı goroutine’de çalıştırır; dönen kanal Push dönünce kapanır.
+func pushed(p func()) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		p()
+		close(done)
+	}()
+	return done
+}
+
+func waiting(t *testing.T, done <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+		t.Fatal("Push returned on a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func returned(t *testing.T, done <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Push still blocked")
+	}
+}
+
+func TestQueueAtCapacity(t *testing.T) {
+	// Her adım bir tick (kapanmamış mum) ya da kapanmış mum iter; kuyruk
+	// iki mesajlık
+	type push struct {
+		value, stream string
+		closed        bool
+	}
+	tests := []struct {
+		strategy string
+		pushes   []push
+		want     []string
+	}{
+		{
+			// Dolu kuyrukta tick’ler atılır
+			strategy: BackpressureDrop,
+			pushes:   []push{{"a1", "a", false}, {"b1", "b", false}, {"a2", "a", false}, {"c1", "c", false}},
+			want:     []string{"a1", "b1"},
+		},
+		{
+			// Bekleyen tick aynı stream’in yenisiyle, yerinde değiştirilir
+			strategy: BackpressureCoalesce,
+			pushes:   []push{{"a1", "a", false}, {"b1", "b", false}, {"a2", "a", false}, {"b2", "b", false}, {"a3", "a", false}},
+			want:     []string{"a3", "b2"},
+		},
+		{
+			// Yeni stream’in tick’i birleşecek yer bulamaz, atılır
+			strategy: BackpressureCoalesce,
+			pushes:   []push{{"a1", "a", false}, {"b1", "b", false}, {"c1", "c", false}},
+			want:     []string{"a1", "b1"},
+		},
+		{
+			// Kapanmış mum bekleyen tick’e birleşmez; sonraki tick de
+			// kapanıştan önceye birleşmez ve dolu kuyrukta atılır
+			strategy: BackpressureCoalesce,
+			pushes:   []push{{"a1", "a", false}, {"a-close", "a", true}, {"a2", "a", false}},
+			want:     []string{"a1", "a-close"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			q := NewQueue(2, tt.strategy)
+			for _, p := range tt.pushes {
+				q.Push(msg(p.value), p.stream, p.closed)
+			}
+			if got := values(q.Pop(0)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("queued %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueueCoalesceAfterPop(t *testing.T) {
+	// Pop edilmiş tick’e birleştirme yapılmaz
+	q := NewQueue(4, BackpressureCoalesce)
+	q.Push(msg("a1"), "a", false)
+	if got := values(q.Pop(1)); !reflect.DeepEqual(got, []string{"a1"}) {
+		t.Fatalf("Pop = %q", got)
+	}
+	q.Push(msg("b1"), "b", false)
+	q.Push(msg("a2"), "a", false)
+	q.Push(msg("a3"), "a", false)
+	if got, want := values(q.Pop(0)), []string{"b1", "a3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("queued %q, want %q", got, want)
+	}
+}
+
+func TestQueueBlocks(t *testing.T) {
+	// block her mesajda, drop ve coalesce sadece kapanmış mumda bekler
+	tests := []struct {
+		strategy string
+		closed   bool
+	}{
+		{BackpressureBlock, false},
+		{BackpressureBlock, true},
+		{BackpressureDrop, true},
+		{BackpressureCoalesce, true},
+	}
+	for _, tt := range tests {
+		name := tt.strategy + " tick"
+		if tt.closed {
+			name = tt.strategy + " closed"
+		}
+		t.Run(name, func(t *testing.T) {
+			q := NewQueue(1, tt.strategy)
+			q.Push(msg("a1"), "a", false)
+			done := pushed(func() { q.Push(msg("b"), "b", tt.closed) })
+			waiting(t, done)
+			if got := values(q.Pop(1)); !reflect.DeepEqual(got, []string{"a1"}) {
+				t.Fatalf("Pop = %q", got)
+			}
+			returned(t, done)
+			if got := values(q.Pop(1)); !reflect.DeepEqual(got, []string{"b"}) {
+				t.Errorf("Pop = %q, want the blocked message", got)
+			}
+		})
+	}
+}
+
+func TestQueueClose(t *testing.T) {
+	q := NewQueue(2, BackpressureBlock)
+	q.Push(msg("a"), "a", true)
+	q.Push(msg("b"), "b", true)
+	done := pushed(func() { q.Push(msg("c"), "c", true) })
+	waiting(t, done)
+
+	// Close bekleyen Push’u bırakır; kalanlar boşaltılır, sonra Pop nil döner
+	q.Close()
+	returned(t, done)
+	if got, want := values(q.Pop(1)), []string{"a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Pop = %q, want %q", got, want)
+	}
+	if got, want := values(q.Pop(10)), []string{"b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Pop = %q, want %q", got, want)
+	}
+	if got := q.Pop(10); got != nil {
+		t.Errorf("Pop on a closed, drained queue = %q, want nil", values(got))
+	}
+	q.Push(msg("d"), "d", true)
+	if n := q.Len(); n != 0 {
+		t.Errorf("closed queue accepted a message, Len = %d", n)
+	}
+}
+
+func TestProducerConfigFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    func(c *ProducerConfig)
+		wantErr string
+	}{
+		{"defaults", nil, func(c *ProducerConfig) {}, ""},
+		{"tuned", map[string]string{
+			"PRODUCER_ACKS":          "one",
+			"PRODUCER_BATCH_SIZE":    "500",
+			"PRODUCER_BATCH_TIMEOUT": "50ms",
+			"PRODUCER_COMPRESSION":   "zstd",
+			"PRODUCER_QUEUE_SIZE":    "100",
+			"BACKPRESSURE":           "Block",
+		}, func(c *ProducerConfig) {
+			c.Acks = kafka.RequireOne
+			c.BatchSize = 500
+			c.BatchTimeout = 50 * time.Millisecond
+			c.Compression = kafka.Zstd
+			c.QueueSize = 100
+			c.Backpressure = BackpressureBlock
+		}, ""},
+		{"at-least-once waits for all replicas", map[string]string{"PRODUCER_DELIVERY": "at-least-once"}, func(c *ProducerConfig) {
+			c.Delivery = DeliveryAtLeastOnce
+			c.Acks = kafka.RequireAll
+		}, ""},
+		{"at-least-once with acks all", map[string]string{"PRODUCER_DELIVERY": "AT-LEAST-ONCE", "PRODUCER_ACKS": "all"}, func(c *ProducerConfig) {
+			c.Delivery = DeliveryAtLeastOnce
+			c.Acks = kafka.RequireAll
+		}, ""},
+		{"at-least-once without acks", map[string]string{"PRODUCER_DELIVERY": "at-least-once", "PRODUCER_ACKS": "none"}, nil, "requires PRODUCER_ACKS=all"},
+		{"unknown delivery", map[string]string{"PRODUCER_DELIVERY": "exactly-once"}, nil, "unknown guarantee"},
+		{"unknown acks", map[string]string{"PRODUCER_ACKS": "some"}, nil, "unknown level"},
+		{"unknown backpressure", map[string]string{"BACKPRESSURE": "spill"}, nil, "unknown strategy"},
+		{"zero batch", map[string]string{"PRODUCER_BATCH_SIZE": "0"}, nil, "must be positive"},
+		{"zero queue", map[string]string{"PRODUCER_QUEUE_SIZE": "0"}, nil, "must be positive"},
+		{"bad timeout", map[string]string{"PRODUCER_BATCH_TIMEOUT": "soon"}, nil, "PRODUCER_BATCH_TIMEOUT"},
+		{"unknown codec", map[string]string{"PRODUCER_COMPRESSION": "brotli"}, nil, "unknown codec"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range []string{"PRODUCER_ACKS", "PRODUCER_BATCH_SIZE", "PRODUCER_BATCH_BYTES", "PRODUCER_BATCH_TIMEOUT",
+				"PRODUCER_COMPRESSION", "PRODUCER_MAX_ATTEMPTS", "PRODUCER_DELIVERY", "PRODUCER_QUEUE_SIZE", "BACKPRESSURE"} {
+				t.Setenv(k, tt.env[k])
+			}
+			got, err := ProducerConfigFromEnv()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := DefaultProducerConfig
+			tt.want(&want)
+			if got != want {
+				t.Errorf("config = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
@@ -1,58 +1,103 @@
 package fetcher
 
 import (
-	"encoding/json"
-	"io/ioutil"
-	"net/http"
+	"fmt"
+	"hash/fnv"
 	"os"
 	"strconv"
+	"strings"
 )
 
-const restURL = "https://fapi.binance.com/fapi/v1/exchangeInfo"
+// ValidateGroups checks every group of a SYMBOL_GROUPS list.
+func ValidateGroups(groups []int, total int) error {
+	if len(groups) == 0 {
+		return fmt.Errorf("no symbol group given")
+	}
+	for _, g := range groups {
+		if err := ValidateGroup(g, total); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-// GetAllFuturesSymbols fetches all USDT futures symbols.
-func GetAllFuturesSymbols() ([]string, error) {
-	resp, err := http.Get(restURL)
-	if err != nil {
-		return nil, err
+// ValidateGroup checks the SYMBOL_GROUP/TOTAL_GROUPS settings.
+func ValidateGroup(group, total int) error {
+	if total < 1 {
+		return fmt.Errorf("TOTAL_GROUPS must be at least 1, got %d", total)
+	}
+	if group < 1 || group > total {
+		return fmt.Errorf("SYMBOL_GROUP must be between 1 and %d, got %d", total, group)
 	}
-	defer resp.Body.Close()
+	return nil
+}
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+// GroupOf returns the group (1..total) a symbol belongs to. It uses
+// rendezvous hashing on the symbol, so a symbol keeps its group no matter
+// which other symbols are listed, and changing total only moves the
+// symbols of added or removed groups.
+func GroupOf(symbol string, total int) int {
+	best, bestScore := 1, uint64(0)
+	for g := 1; g <= total; g++ {
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%s#%d", symbol, g)
+		if score := h.Sum64(); g == 1 || score > bestScore {
+			best, bestScore = g, score
+		}
 	}
+	return best
+}
 
-	var info struct {
-		Symbols []struct {
-			Symbol     string `json:"symbol"`
-			QuoteAsset string `json:"quoteAsset"`
-			Status     string `json:"status"`
-		} `json:"symbols"`
+// GetSymbolsForGroup returns the symbols that belong to the group, in
+// their original order. Callers validate the group with ValidateGroup.
+func GetSymbolsForGroup(symbols []string, group, total int) []string {
+	return SymbolsForGroups(symbols, []int{group}, total)
+}
+
+// SymbolsForGroups returns the symbols that belong to any of the groups.
+func SymbolsForGroups(symbols []string, groups []int, total int) []string {
+	mine := make(map[int]bool, len(groups))
+	for _, g := range groups {
+		mine[g] = true
 	}
-	if err := json.Unmarshal(body, &info); err != nil {
-		return nil, err
+	var out []string
+	for _, s := range symbols {
+		if mine[GroupOf(s, total)] {
+			out = append(out, s)
+		}
 	}
+	return out
+}
 
-	var symbols []string
-	for _, s := range info.Symbols {
-		if s.QuoteAsset == "USDT" && s.Status == "TRADING" {
-			symbols = append(symbols, s.Symbol)
+// EnvAsList reads a comma-separated env var or returns def.
+func EnvAsList(key string, def []string) []string {
+	var out []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
 		}
 	}
-	return symbols, nil
+	if len(out) == 0 {
+		return def
+	}
+	return out
 }
 
-// GetSymbolsForGroup splits symbols into groups of roughly equal size.
-func GetSymbolsForGroup(symbols []string, group, total int) []string {
-	n := len(symbols)
-	size := n / total
-	start := (group - 1) * size
-	end := start + size
-	if group == total {
-		end = n
+// EnvAsInts reads a comma-separated list of ints or returns def.
+func EnvAsInts(key string, def []int) ([]int, error) {
+	list := EnvAsList(key, nil)
+	if list == nil {
+		return def, nil
+	}
+	out := make([]int, len(list))
+	for i, v := range list {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		out[i] = n
 	}
-	return symbols[start:end]
+	return out, nil
 }
 
 // EnvAsInt reads an env var or returns default.
@@ -0,0 +1,64 @@
+package fetcher
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/exchange"
+)
+
+// SymbolDiff, iki sembol taraması arasındaki fark.
+type SymbolDiff struct {
+	Listed   []string
+	Delisted []string
+	All      []string // borsada işlem gören güncel USDT sembolleri
+}
+
+// WatchSymbols borsanın sembol listesini her aralıkta tarar ve USDT
+// sembolleri değiştiğinde onChange’i çağırır. initial başlangıçta bilinen
+// settir. ctx bitene kadar döner.
+func WatchSymbols(ctx context.Context, ex exchange.Adapter, initial []string, every time.Duration, onChange func(SymbolDiff)) {
+	known := make(map[string]struct{}, len(initial))
+	for _, s := range initial {
+		known[s] = struct{}{}
+	}
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		all, err := ex.Symbols(ctx)
+		if err != nil {
+			log.Printf("[symbols] %s symbols error (keeping current set): %v", ex.Name(), err)
+			continue
+		}
+		if len(all) == 0 {
+			// Boş cevap muhtemelen geçici bir hata; her şeyi delist etme
+			log.Printf("[symbols] %s returned no symbols, ignoring", ex.Name())
+			continue
+		}
+		diff := SymbolDiff{All: all}
+		current := make(map[string]struct{}, len(all))
+		for _, s := range all {
+			current[s] = struct{}{}
+			if _, ok := known[s]; !ok {
+				diff.Listed = append(diff.Listed, s)
+			}
+		}
+		for s := range known {
+			if _, ok := current[s]; !ok {
+				diff.Delisted = append(diff.Delisted, s)
+			}
+		}
+		known = current
+		if len(diff.Listed) == 0 && len(diff.Delisted) == 0 {
+			continue
+		}
+		log.Printf("[symbols] listed %v, delisted %v", diff.Listed, diff.Delisted)
+		onChange(diff)
+	}
+}
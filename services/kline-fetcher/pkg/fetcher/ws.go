@@ -3,65 +3,348 @@ package fetcher
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
 	"log"
-	"strings"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
 
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/events"
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/exchange"
 	"github.com/gorilla/websocket"
 	kafka "github.com/segmentio/kafka-go"
 )
 
-// WSKlineResponse yalnızca sembolü çıkarmak için
-type WSKlineResponse struct {
-	Data struct {
-		Symbol string `json:"s"`
-	} `json:"data"`
+// Stats, izleme için sayaçlar; expvar üzerinden /debug/vars altında yayınlanır.
+var Stats = expvar.NewMap("kline_fetcher")
+
+// Options WebSocket bağlantılarının borsası, keepalive, yeniden bağlanma ve
+// stream limitleri.
+type Options struct {
+	Exchange exchange.Adapter // stream adları, mesaj formatı ve WS adresi
+
+	PingInterval time.Duration // bu aralıkla ping gönderilir
+	ReadTimeout  time.Duration // bu süre hiçbir şey gelmezse bağlantı ölü sayılır
+	MaxConnAge   time.Duration // borsa kesmeden önce bağlantı yenilenir
+	MinBackoff   time.Duration
+	MaxBackoff   time.Duration
+
+	MaxStreamsPerConn int           // bir bağlantıdaki en fazla stream sayısı
+	StreamsPerRequest int           // tek SUBSCRIBE/UNSUBSCRIBE mesajındaki stream sayısı
+	RequestInterval   time.Duration // kontrol mesajları arası bekleme
 }
 
-// SubscribeAndPublish, verilen sembollerin interval'ındaki kline stream'lerini açar
-// ve her ham mesajı msgCh kanalına iter.
-func SubscribeAndPublish(
-	ctx context.Context,
-	symbols []string,
-	interval string,
-	msgCh chan<- kafka.Message,
-) error {
-	// 1) Stream parametresini oluştur
-	streams := make([]string, len(symbols))
-	for i, sym := range symbols {
-		streams[i] = fmt.Sprintf("%s@kline_%s", strings.ToLower(sym), interval)
-	}
-	url := fmt.Sprintf("wss://fstream.binance.com/stream?streams=%s", strings.Join(streams, "/"))
-
-	// 2) WebSocket'e bağlan
-	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
-	if err != nil {
-		return err
+// binanceUSDM DefaultOptions’ın borsası: Binance USDⓈ-M futures.
+var binanceUSDM, _ = exchange.NewBinance(events.MarketUSDM)
+
+// DefaultOptions Binance USDⓈ-M futures stream’leri için makul varsayılanlar.
+var DefaultOptions = Options{
+	Exchange:          binanceUSDM,
+	PingInterval:      time.Minute,
+	ReadTimeout:       3 * time.Minute,
+	MaxConnAge:        23 * time.Hour,
+	MinBackoff:        time.Second,
+	MaxBackoff:        time.Minute,
+	MaxStreamsPerConn: 200,
+	StreamsPerRequest: 50,
+	RequestInterval:   250 * time.Millisecond,
+}
+
+// OptionsFor borsanın limitlerini DefaultOptions’a uygular.
+func OptionsFor(ex exchange.Adapter) Options {
+	opts := DefaultOptions
+	opts.Exchange = ex
+	l := ex.Limits()
+	if l.PingInterval > 0 {
+		opts.PingInterval = l.PingInterval
 	}
-	defer conn.Close()
-	log.Printf("[kline-fetcher] WS connected: %s", url)
+	if l.MaxConnAge > 0 {
+		opts.MaxConnAge = l.MaxConnAge
+	}
+	if l.MaxStreamsPerConn > 0 {
+		opts.MaxStreamsPerConn = l.MaxStreamsPerConn
+	}
+	if l.StreamsPerRequest > 0 {
+		opts.StreamsPerRequest = l.StreamsPerRequest
+	}
+	if l.RequestInterval > 0 {
+		opts.RequestInterval = l.RequestInterval
+	}
+	return opts
+}
+
+const writeWait = 10 * time.Second
+
+// errConnExpired bağlantı MaxConnAge’e ulaşıp bilerek kapatıldığında döner.
+var errConnExpired = errors.New("connection reached max age")
+
+// KlineStreams sembollerin her interval’ı için borsadaki stream adlarını
+// döner; aynı bağlantı havuzunda 1m, 5m, 1h … stream’leri birlikte
+// taşınabilir. Borsanın sunmadığı bir interval hata döner.
+func KlineStreams(ex exchange.Adapter, symbols, intervals []string) ([]string, error) {
+	streams := make([]string, 0, len(symbols)*len(intervals))
+	for _, sym := range symbols {
+		for _, interval := range intervals {
+			st, err := ex.Stream(sym, interval)
+			if err != nil {
+				return nil, err
+			}
+			streams = append(streams, st)
+		}
+	}
+	return streams, nil
+}
+
+// ValidateIntervals borsanın sunmadığı bir interval için hata döner.
+func ValidateIntervals(ex exchange.Adapter, intervals []string) error {
+	for _, interval := range intervals {
+		if !slices.Contains(ex.Intervals(), interval) {
+			return fmt.Errorf("%s: %w: %q", ex.Name(), exchange.ErrUnsupportedInterval, interval)
+		}
+	}
+	return nil
+}
+
+// streamConn tek bir WebSocket bağlantısı ve üzerinde olması gereken
+// stream’ler. Bağlantı koparsa jitter’lı exponential backoff ile yeniden
+// kurulur ve aynı stream’lere tekrar abone olunur.
+type streamConn struct {
+	id   int
+	opts Options
+	out  *Queue
+
+	mu      sync.Mutex
+	streams map[string]struct{} // olması gereken stream’ler
+	conn    *websocket.Conn     // bağlı değilken nil
+	writeMu sync.Mutex          // WriteMessage aynı anda tek goroutine’den
+	nextID  int64
+}
+
+func newStreamConn(id int, out *Queue, opts Options) *streamConn {
+	return &streamConn{id: id, opts: opts, out: out, streams: make(map[string]struct{})}
+}
+
+// update stream’leri ekler/çıkarır; bağlıysa değişikliği canlı olarak
+// SUBSCRIBE/UNSUBSCRIBE ile gönderir, değilse bir sonraki bağlantıda uygulanır.
+func (s *streamConn) update(add, remove []string) {
+	s.mu.Lock()
+	for _, st := range add {
+		s.streams[st] = struct{}{}
+	}
+	for _, st := range remove {
+		delete(s.streams, st)
+	}
+	conn := s.conn
+	s.mu.Unlock()
+	if conn == nil {
+		return
+	}
+	if err := s.send(conn, true, add); err != nil {
+		log.Printf("[kline-fetcher] conn %d: subscribe error: %v", s.id, err)
+		conn.Close() // yeniden bağlanınca tüm set tekrar gönderilir
+		return
+	}
+	if err := s.send(conn, false, remove); err != nil {
+		log.Printf("[kline-fetcher] conn %d: unsubscribe error: %v", s.id, err)
+		conn.Close()
+	}
+}
 
-	// 3) Mesajları oku ve kanala iter
+// send abone olma/çıkma isteklerini StreamsPerRequest’lik parçalar
+// hâlinde, borsanın mesaj limitine takılmadan gönderir.
+func (s *streamConn) send(conn *websocket.Conn, subscribe bool, streams []string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	for len(streams) > 0 {
+		n := min(len(streams), s.opts.StreamsPerRequest)
+		s.nextID++
+		req, err := s.opts.Exchange.ControlMessage(s.nextID, subscribe, streams[:n])
+		if err != nil {
+			return err
+		}
+		conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := conn.WriteMessage(websocket.TextMessage, req); err != nil {
+			return err
+		}
+		streams = streams[n:]
+		time.Sleep(s.opts.RequestInterval)
+	}
+	return nil
+}
+
+// run bağlantıyı ctx bitene kadar açık tutar.
+func (s *streamConn) run(ctx context.Context) {
+	backoff := s.opts.MinBackoff
 	for {
+		start := time.Now()
+		err := s.readStream(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		Stats.Add("reconnects", 1)
+		if errors.Is(err, errConnExpired) {
+			log.Printf("[kline-fetcher] conn %d: %v, reconnecting", s.id, err)
+			backoff = s.opts.MinBackoff
+			continue
+		}
+		// Bir süre sağlıklı çalışmış bir bağlantıdan sonra backoff sıfırlanır
+		if time.Since(start) > s.opts.MaxBackoff {
+			backoff = s.opts.MinBackoff
+		}
+		wait := jitter(backoff)
+		log.Printf("[kline-fetcher] conn %d: WS error: %v, reconnecting in %s", s.id, err, wait)
 		select {
 		case <-ctx.Done():
-			log.Printf("[kline-fetcher] context done, exiting")
-			return nil
-		default:
-			_, msg, err := conn.ReadMessage()
-			if err != nil {
-				log.Printf("[kline-fetcher] WS read error: %v", err)
-				continue
+			return
+		case <-time.After(wait):
+		}
+		backoff = min(2*backoff, s.opts.MaxBackoff)
+	}
+}
+
+// readStream tek bir bağlantı açar, stream’lere abone olur ve bağlantı
+// kapanana kadar mesajları kuyruğa iter.
+func (s *streamConn) readStream(ctx context.Context) error {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, s.opts.Exchange.WebsocketURL(), nil)
+	if err != nil {
+		Stats.Add("dialErrors", 1)
+		return fmt.Errorf("dial: %w", err)
+	}
+	defer conn.Close()
+	Stats.Add("connects", 1)
+
+	// Keepalive: gelen her ping/pong okuma süresini uzatır
+	extend := func() { conn.SetReadDeadline(time.Now().Add(s.opts.ReadTimeout)) }
+	extend()
+	conn.SetPongHandler(func(string) error {
+		extend()
+		return nil
+	})
+	conn.SetPingHandler(func(data string) error {
+		extend()
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(writeWait))
+	})
+
+	// Ping gönderici ve bağlantı ömrü; ikisi de bağlantıyı kapatarak
+	// ReadMessage’ı sonlandırır
+	done := make(chan struct{})
+	defer close(done)
+	expired := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(s.opts.PingInterval)
+		defer ticker.Stop()
+		age := time.NewTimer(s.opts.MaxConnAge)
+		defer age.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				conn.Close()
+				return
+			case <-age.C:
+				close(expired)
+				conn.Close()
+				return
+			case <-ticker.C:
+				if err := s.ping(conn); err != nil {
+					log.Printf("[kline-fetcher] conn %d: ping error: %v", s.id, err)
+				}
 			}
+		}
+	}()
+
+	// Mevcut stream setine abone ol; bundan sonraki değişiklikler update
+	// ile canlı gönderilir
+	s.mu.Lock()
+	s.conn = conn
+	streams := make([]string, 0, len(s.streams))
+	for st := range s.streams {
+		streams = append(streams, st)
+	}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.conn = nil
+		s.mu.Unlock()
+	}()
+	// Abonelik gönderilirken okuma da yapılmalı ki ping’ler cevapsız kalmasın
+	subErr := make(chan error, 1)
+	go func() { subErr <- s.send(conn, true, streams) }()
+	log.Printf("[kline-fetcher] conn %d: WS connected, subscribing to %d streams", s.id, len(streams))
+
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			select {
+			case <-expired:
+				Stats.Add("expiredConns", 1)
+				return errConnExpired
+			default:
+			}
+			Stats.Add("readErrors", 1)
+			select {
+			case serr := <-subErr:
+				if serr != nil {
+					return fmt.Errorf("subscribe: %w", serr)
+				}
+			default:
+			}
+			return fmt.Errorf("read: %w", err)
+		}
+		extend()
 
-			// İsteğe bağlı: sadece loglamak için sembolü parse et
-			var resp WSKlineResponse
-			if err := json.Unmarshal(msg, &resp); err == nil {
-				log.Printf("[kline-fetcher] fetched kline for %s", resp.Data.Symbol)
+		// Borsa mesajını borsadan bağımsız KlineEvent’lere çevir; sembol
+		// mesaj key’i olarak kullanılır ki calc-service bir sembolün tüm
+		// kline’larını aynı partition’dan okusun. İstek cevapları ve
+		// pong’lar event üretmez
+		klines, err := s.opts.Exchange.ParseMessage(msg)
+		if errors.Is(err, exchange.ErrRequestFailed) {
+			log.Printf("[kline-fetcher] conn %d: %v", s.id, err)
+			Stats.Add("requestErrors", 1)
+			continue
+		}
+		if err != nil {
+			log.Printf("[kline-fetcher] unparsable message skipped: %v", err)
+			continue
+		}
+		now := time.Now()
+		for _, evt := range klines {
+			evt.Version = events.KlineVersion
+			evt.ReceivedAt = now
+			b, err := json.Marshal(evt)
+			if err != nil {
+				log.Printf("[kline-fetcher] kline encode error: %v", err)
+				continue
 			}
+			log.Printf("[kline-fetcher] fetched %s %s kline for %s", evt.Exchange, evt.Interval, evt.Symbol)
+			Stats.Add("messages", 1)
 
-			// 4) Ham mesajı Kafka kanalına it
-			msgCh <- kafka.Message{Value: msg}
+			// Kuyruk doluysa tick’ler BACKPRESSURE stratejisine göre atılır
+			// ya da birleştirilir; block dışında okuma döngüsü sadece
+			// kapanmış mumlarda bekler
+			s.out.Push(kafka.Message{Key: []byte(evt.Symbol), Value: b}, evt.Symbol+":"+evt.Interval, evt.Closed)
 		}
 	}
 }
+
+// ping bağlantıyı canlı tutar: borsa uygulama seviyesinde bir heartbeat
+// istiyorsa onu, yoksa WebSocket ping frame’i gönderir.
+func (s *streamConn) ping(conn *websocket.Conn) error {
+	hb := s.opts.Exchange.Heartbeat()
+	if hb == nil {
+		return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait))
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return conn.WriteMessage(websocket.TextMessage, hb)
+}
+
+// jitter d’yi [d/2, d) aralığında rastgele bir süreye çevirir.
+func jitter(d time.Duration) time.Duration {
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
@@ -8,6 +8,7 @@ import (
 	"syscall"
 
 	"github.com/ae144de/sonarbot-service-infra2/services/notify-service/pkg/notifier"
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/events"
 	kafka "github.com/segmentio/kafka-go"
 )
 
@@ -37,8 +38,14 @@ func main() {
 		if err != nil {
 			break
 		}
-		// TODO: mesajı parse et, gönderilecek içeriği hazırla
-		text := string(m.Value)
+		// Alert’i (eski format dahil) çöz ve okunur mesaja çevir
+		evt, err := events.DecodeAlert(m.Value)
+		if err != nil {
+			log.Printf("Skipping undecodable alert at offset %d: %v", m.Offset, err)
+			reader.CommitMessages(ctx, m)
+			continue
+		}
+		text := notifier.FormatAlert(evt)
 		if err := notifierClient.SendTelegram(text); err != nil {
 			log.Printf("Telegram send error: %v", err)
 		}
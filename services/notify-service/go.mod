@@ -2,7 +2,10 @@ module github.com/ae144de/sonarbot-service-infra2/services/notify-service
 
 go 1.24.1
 
-require github.com/segmentio/kafka-go v0.4.48
+require (
+	github.com/ae144de/sonarbot-service-infra2/services/services v0.0.0-20250516170218-c5f096f9e3b6
+	github.com/segmentio/kafka-go v0.4.48
+)
 
 require (
 	github.com/klauspost/compress v1.15.9 // indirect
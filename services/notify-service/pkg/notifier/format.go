@@ -0,0 +1,54 @@
+package notifier
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/events"
+)
+
+// FormatAlert renders an alert event as a human-readable message.
+func FormatAlert(evt events.AlertEvent) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "🔔 %s %s", evt.Symbol, evt.Interval)
+	if evt.Exchange != "" {
+		venue := evt.Exchange
+		if evt.Market != "" {
+			venue += " " + evt.Market
+		}
+		fmt.Fprintf(&b, " (%s)", venue)
+	}
+	if evt.Price != 0 {
+		fmt.Fprintf(&b, " @ %g", evt.Price)
+	}
+	if !evt.OpenTime.IsZero() {
+		state := "open"
+		if evt.Closed {
+			state = "closed"
+		}
+		fmt.Fprintf(&b, "\nCandle %s (%s)", evt.OpenTime.UTC().Format("2006-01-02 15:04"), state)
+	}
+	for _, leaf := range evt.Conditions.Leaves() {
+		if leaf.Detail != "" {
+			fmt.Fprintf(&b, "\n• %s", leaf.Detail)
+			continue
+		}
+		mark := "✗"
+		if leaf.Met {
+			mark = "✓"
+		}
+		fmt.Fprintf(&b, "\n%s %s: %s vs %s", mark, leaf.Name, value(leaf.Value), value(leaf.Threshold))
+		if leaf.Error != "" {
+			fmt.Fprintf(&b, " (%s)", leaf.Error)
+		}
+	}
+	fmt.Fprintf(&b, "\nJob %s", evt.JobID)
+	return b.String()
+}
+
+func value(v *float64) string {
+	if v == nil {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.4f", *v)
+}
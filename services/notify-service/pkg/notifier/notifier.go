@@ -3,6 +3,7 @@ package notifier
 import (
 	"fmt"
 	"net/http"
+	"net/url"
 )
 
 // Notifier defines methods for sending notifications.
@@ -17,9 +18,9 @@ func New(cfg Config) *Notifier {
 
 // SendTelegram sends a message via Telegram Bot API.
 func (n *Notifier) SendTelegram(msg string) error {
-	url := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage?chat_id=%s&text=%s",
-		n.cfg.TelegramToken, n.cfg.ChatID, msg)
-	resp, err := http.Get(url)
+	q := url.Values{"chat_id": {n.cfg.ChatID}, "text": {msg}}
+	endpoint := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage?%s", n.cfg.TelegramToken, q.Encode())
+	resp, err := http.Get(endpoint)
 	if err != nil {
 		return err
 	}
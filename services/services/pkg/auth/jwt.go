@@ -0,0 +1,130 @@
+// Package auth issues and verifies the JWTs auth-service hands out to
+// users. Tokens are HS256-signed with the key auth-service is configured
+// with (JWT_SIGNING_KEY) and expire after its TOKEN_TTL; the subject is the
+// user ID. Services that accept them verify with the same key.
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Issuer is the iss claim of tokens issued by auth-service.
+const Issuer = "auth-service"
+
+// leeway tolerates clock skew between the issuer and the verifier.
+const leeway = 30 * time.Second
+
+// MinKeyLength is the shortest signing key accepted: an HS256 key shorter
+// than the hash it keys can be brute-forced from a single token.
+const MinKeyLength = 32
+
+var (
+	// ErrInvalidToken is returned for malformed tokens, tokens signed with
+	// another key or algorithm, and tokens of another issuer.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrExpiredToken is returned for tokens past their expiry.
+	ErrExpiredToken = errors.New("token expired")
+)
+
+// Claims are the claims of a user token.
+type Claims struct {
+	Subject   string `json:"sub"` // user ID
+	Issuer    string `json:"iss"`
+	IssuedAt  int64  `json:"iat"` // unix seconds
+	ExpiresAt int64  `json:"exp"` // unix seconds
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+}
+
+var encoding = base64.RawURLEncoding
+
+// CheckSigningKey reports whether key may sign and verify tokens. Services
+// call it at startup so that a missing key, or a short placeholder such as
+// the old "supersecret" default, stops them instead of being used.
+func CheckSigningKey(key []byte) error {
+	switch {
+	case len(key) == 0:
+		return errors.New("signing key is empty")
+	case len(key) < MinKeyLength:
+		return fmt.Errorf("signing key is %d bytes, at least %d required", len(key), MinKeyLength)
+	}
+	return nil
+}
+
+// Issue returns a token for the user, valid for ttl.
+func Issue(key []byte, userID string, ttl time.Duration) (string, error) {
+	if err := CheckSigningKey(key); err != nil {
+		return "", err
+	}
+	if userID == "" {
+		return "", errors.New("empty user ID")
+	}
+	now := time.Now()
+	h, err := json.Marshal(header{Alg: "HS256", Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(Claims{
+		Subject:   userID,
+		Issuer:    Issuer,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	signed := encoding.EncodeToString(h) + "." + encoding.EncodeToString(c)
+	return signed + "." + encoding.EncodeToString(sign(key, signed)), nil
+}
+
+// Verify checks the token's signature, issuer and expiry and returns its
+// claims. Only HS256 is accepted, whatever the token's header asks for.
+func Verify(key []byte, token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, ErrInvalidToken
+	}
+	var h header
+	if err := decodePart(parts[0], &h); err != nil || h.Alg != "HS256" {
+		return Claims{}, ErrInvalidToken
+	}
+	sig, err := encoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, sign(key, parts[0]+"."+parts[1])) {
+		return Claims{}, ErrInvalidToken
+	}
+	var c Claims
+	if err := decodePart(parts[1], &c); err != nil {
+		return Claims{}, ErrInvalidToken
+	}
+	if c.Issuer != Issuer || c.Subject == "" {
+		return Claims{}, ErrInvalidToken
+	}
+	if exp := time.Unix(c.ExpiresAt, 0); c.ExpiresAt == 0 || time.Since(exp) > leeway {
+		return Claims{}, fmt.Errorf("%w at %s", ErrExpiredToken, exp.UTC().Format(time.RFC3339))
+	}
+	return c, nil
+}
+
+func sign(key []byte, signed string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signed))
+	return mac.Sum(nil)
+}
+
+func decodePart(part string, v interface{}) error {
+	b, err := encoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testKey  = []byte("0123456789abcdef0123456789abcdef")
+	otherKey = []byte("fedcba9876543210fedcba9876543210")
+)
+
+// token builds a token from raw header and claims JSON, signed with key;
+// a nil key leaves the signature empty.
+func token(headerJSON, claimsJSON string, key []byte) string {
+	signed := encoding.EncodeToString([]byte(headerJSON)) + "." + encoding.EncodeToString([]byte(claimsJSON))
+	if key == nil {
+		return signed + "."
+	}
+	return signed + "." + encoding.EncodeToString(sign(key, signed))
+}
+
+func claimsJSON(t *testing.T, c Claims) string {
+	t.Helper()
+	b, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestIssueVerify(t *testing.T) {
+	tok, err := Issue(testKey, "user-1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := Verify(testKey, tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Subject != "user-1" || c.Issuer != Issuer {
+		t.Errorf("claims = %+v", c)
+	}
+	if d := time.Unix(c.ExpiresAt, 0).Sub(time.Unix(c.IssuedAt, 0)); d != time.Hour {
+		t.Errorf("token valid for %s, want 1h", d)
+	}
+}
+
+func TestIssueRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    []byte
+		userID string
+	}{
+		{"empty key", nil, "user-1"},
+		{"short key", []byte("supersecret"), "user-1"},
+		{"empty user", testKey, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tok, err := Issue(tt.key, tt.userID, time.Hour); err == nil {
+				t.Errorf("Issue = %q, want error", tok)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Now()
+	valid := Claims{Subject: "user-1", Issuer: Issuer, IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Hour).Unix()}
+	hs256 := `{"alg":"HS256","typ":"JWT"}`
+
+	good := token(hs256, claimsJSON(t, valid), testKey)
+	parts := strings.Split(good, ".")
+	forged := valid
+	forged.Subject = "admin"
+	tampered := parts[0] + "." + encoding.EncodeToString([]byte(claimsJSON(t, forged))) + "." + parts[2]
+
+	expired := valid
+	expired.IssuedAt = now.Add(-2 * time.Hour).Unix()
+	expired.ExpiresAt = now.Add(-time.Hour).Unix()
+	skewed := valid
+	skewed.ExpiresAt = now.Add(-leeway / 2).Unix()
+	noExpiry := valid
+	noExpiry.ExpiresAt = 0
+	otherIssuer := valid
+	otherIssuer.Issuer = "someone-else"
+	noSubject := valid
+	noSubject.Subject = ""
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"valid", good, nil},
+		{"expired within leeway", token(hs256, claimsJSON(t, skewed), testKey), nil},
+		{"tampered payload", tampered, ErrInvalidToken},
+		{"wrong key", token(hs256, claimsJSON(t, valid), otherKey), ErrInvalidToken},
+		{"expired", token(hs256, claimsJSON(t, expired), testKey), ErrExpiredToken},
+		{"no expiry", token(hs256, claimsJSON(t, noExpiry), testKey), ErrExpiredToken},
+		{"alg none", token(`{"alg":"none","typ":"JWT"}`, claimsJSON(t, valid), nil), ErrInvalidToken},
+		{"alg none signed", token(`{"alg":"none","typ":"JWT"}`, claimsJSON(t, valid), testKey), ErrInvalidToken},
+		{"alg HS512", token(`{"alg":"HS512","typ":"JWT"}`, claimsJSON(t, valid), testKey), ErrInvalidToken},
+		{"other issuer", token(hs256, claimsJSON(t, otherIssuer), testKey), ErrInvalidToken},
+		{"no subject", token(hs256, claimsJSON(t, noSubject), testKey), ErrInvalidToken},
+		{"two parts", parts[0] + "." + parts[1], ErrInvalidToken},
+		{"bad signature encoding", parts[0] + "." + parts[1] + ".!!", ErrInvalidToken},
+		{"bad claims JSON", token(hs256, `{"sub":`, testKey), ErrInvalidToken},
+		{"empty", "", ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Verify(testKey, tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verify error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && c.Subject != valid.Subject {
+				t.Errorf("subject = %q, want %q", c.Subject, valid.Subject)
+			}
+		})
+	}
+}
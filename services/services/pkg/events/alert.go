@@ -0,0 +1,125 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AlertVersion is the schema version of AlertEvent written by this package.
+const AlertVersion = 1
+
+// AlertEvent is published to alert.trigger when a job's conditions are met.
+type AlertEvent struct {
+	Version    int             `json:"version"`
+	JobID      string          `json:"jobId"`
+	Owner      string          `json:"owner,omitempty"`
+	Exchange   string          `json:"exchange,omitempty"`
+	Market     string          `json:"market,omitempty"`
+	Symbol     string          `json:"symbol"`
+	Interval   string          `json:"interval"`
+	OpenTime   time.Time       `json:"openTime"`
+	CloseTime  time.Time       `json:"closeTime"`
+	Closed     bool            `json:"closed"` // the candle had closed when evaluated
+	Price      float64         `json:"price"`  // close of the candle
+	Evaluation string          `json:"evaluation"`
+	Conditions ConditionResult `json:"conditions"`
+	Satisfied  []string        `json:"satisfied"`
+	Timestamp  time.Time       `json:"timestamp"`
+}
+
+// ConditionResult is a node of the evaluated condition tree. Groups set
+// Type to AND, OR or NOT; leaves set it to LEAF and carry the compared
+// values. Previous values are nil until the job has seen two evaluations.
+type ConditionResult struct {
+	Path          string            `json:"path"`
+	Type          string            `json:"type"`
+	Met           bool              `json:"met"`
+	Name          string            `json:"name,omitempty"`
+	Operator      string            `json:"operator,omitempty"`
+	Value         *float64          `json:"value,omitempty"`
+	Prev          *float64          `json:"prev,omitempty"`
+	Threshold     *float64          `json:"threshold,omitempty"`
+	PrevThreshold *float64          `json:"prevThreshold,omitempty"`
+	Error         string            `json:"error,omitempty"`
+	Detail        string            `json:"detail,omitempty"` // only set on decoded legacy alerts
+	Children      []ConditionResult `json:"children,omitempty"`
+}
+
+// Leaves returns the leaf conditions of the tree in order.
+func (r ConditionResult) Leaves() []ConditionResult {
+	if r.Type == "LEAF" {
+		return []ConditionResult{r}
+	}
+	var out []ConditionResult
+	for _, c := range r.Children {
+		out = append(out, c.Leaves()...)
+	}
+	return out
+}
+
+// legacyAlert is the untyped map calc-service published before versioning.
+type legacyAlert struct {
+	JobID      string           `json:"jobId"`
+	Symbol     string           `json:"symbol"`
+	Interval   string           `json:"interval"`
+	Indicators string           `json:"indicators"`
+	Conditions *ConditionResult `json:"conditions"`
+	Satisfied  []string         `json:"satisfied"`
+	Timestamp  int64            `json:"timestamp"` // unix seconds
+}
+
+// DecodeAlert decodes an alert.trigger message. Messages without a version
+// are read as the legacy format; their per-condition values only survive
+// as the free-text Detail of each leaf.
+func DecodeAlert(raw []byte) (AlertEvent, error) {
+	var probe struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		return AlertEvent{}, err
+	}
+	switch {
+	case probe.Version > AlertVersion:
+		return AlertEvent{}, fmt.Errorf("%w: %d", ErrUnsupportedVersion, probe.Version)
+	case probe.Version > 0:
+		var evt AlertEvent
+		err := json.Unmarshal(raw, &evt)
+		return evt, err
+	}
+
+	var old legacyAlert
+	if err := json.Unmarshal(raw, &old); err != nil {
+		return AlertEvent{}, err
+	}
+	evt := AlertEvent{
+		JobID:     old.JobID,
+		Symbol:    old.Symbol,
+		Interval:  old.Interval,
+		Satisfied: old.Satisfied,
+		Timestamp: time.Unix(old.Timestamp, 0),
+	}
+	if evt.JobID == "" {
+		// Alerts from before job IDs existed were keyed by symbol:interval
+		evt.JobID = old.Symbol + ":" + old.Interval
+	}
+	if old.Conditions != nil {
+		evt.Conditions = *old.Conditions
+	} else {
+		// Only the concatenated indicator details are known
+		evt.Conditions = ConditionResult{Path: "0", Type: "AND", Met: true}
+		for i, detail := range strings.Split(old.Indicators, ";") {
+			if detail = strings.TrimSpace(detail); detail == "" {
+				continue
+			}
+			evt.Conditions.Children = append(evt.Conditions.Children, ConditionResult{
+				Path:   fmt.Sprintf("0.%d", i),
+				Type:   "LEAF",
+				Met:    true,
+				Detail: detail,
+			})
+		}
+	}
+	return evt, nil
+}
@@ -0,0 +1,7 @@
+// Package events defines the versioned messages services exchange over Kafka.
+package events
+
+import "errors"
+
+// ErrUnsupportedVersion is returned when an event was written by a newer schema.
+var ErrUnsupportedVersion = errors.New("unsupported event version")
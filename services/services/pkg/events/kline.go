@@ -0,0 +1,134 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// KlineVersion is the schema version of KlineEvent written by this package.
+const KlineVersion = 1
+
+// Market types. The same symbol is a separate instrument on each.
+const (
+	MarketSpot  = "spot"
+	MarketUSDM  = "usdm"  // USDⓈ-margined futures
+	MarketCoinM = "coinm" // coin-margined futures
+)
+
+// Markets lists the market types.
+var Markets = []string{MarketSpot, MarketUSDM, MarketCoinM}
+
+// KlineEvent is an exchange-neutral candle published to kline.raw, keyed by
+// symbol. Open candles are published on every update with Closed unset.
+type KlineEvent struct {
+	Version        int       `json:"version"`
+	Exchange       string    `json:"exchange"`
+	Market         string    `json:"market"` // one of the Market* types
+	Symbol         string    `json:"symbol"`
+	Interval       string    `json:"interval"`
+	OpenTime       time.Time `json:"openTime"`
+	CloseTime      time.Time `json:"closeTime"`
+	Open           float64   `json:"open"`
+	High           float64   `json:"high"`
+	Low            float64   `json:"low"`
+	Close          float64   `json:"close"`
+	Volume         float64   `json:"volume"`
+	QuoteVolume    float64   `json:"quoteVolume"`
+	TakerBuyVolume float64   `json:"takerBuyVolume"`
+	Trades         int64     `json:"trades"`
+	Closed         bool      `json:"closed"`
+	ReceivedAt     time.Time `json:"receivedAt"` // when the fetcher got the update
+}
+
+// binanceKline is a kline message of Binance's combined stream, which
+// kline-fetcher forwarded as-is before versioning.
+type binanceKline struct {
+	Data struct {
+		Symbol string `json:"s"`
+		K      struct {
+			Interval       string      `json:"i"`
+			OpenTime       int64       `json:"t"`
+			CloseTime      int64       `json:"T"`
+			Open           json.Number `json:"o"`
+			High           json.Number `json:"h"`
+			Low            json.Number `json:"l"`
+			Close          json.Number `json:"c"`
+			Volume         json.Number `json:"v"`
+			QuoteVolume    json.Number `json:"q"`
+			TakerBuyVolume json.Number `json:"V"`
+			Trades         int64       `json:"n"`
+			IsClosed       bool        `json:"x"`
+			// encoding/json falls back to case-insensitive key matching,
+			// so "L" and "Q" need fields of their own not to overwrite
+			// Low and QuoteVolume
+			LastTradeID         int64       `json:"L"`
+			TakerBuyQuoteVolume json.Number `json:"Q"`
+		} `json:"k"`
+	} `json:"data"`
+}
+
+// DecodeKline decodes a kline.raw message. Messages without a version are
+// read as the legacy Binance USDT futures format (see DecodeBinanceKline).
+func DecodeKline(raw []byte) (KlineEvent, error) {
+	var probe struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		return KlineEvent{}, err
+	}
+	switch {
+	case probe.Version > KlineVersion:
+		return KlineEvent{}, fmt.Errorf("%w: %d", ErrUnsupportedVersion, probe.Version)
+	case probe.Version > 0:
+		var evt KlineEvent
+		if err := json.Unmarshal(raw, &evt); err != nil {
+			return evt, err
+		}
+		if evt.Market == "" || evt.Market == "futures" {
+			// Written before market types; only USDⓈ-M was streamed
+			evt.Market = MarketUSDM
+		}
+		return evt, nil
+	}
+	return DecodeBinanceKline(raw)
+}
+
+// DecodeBinanceKline converts a Binance combined-stream kline message into
+// a KlineEvent. Spot and futures streams share the format, so Market is set
+// to USDⓈ-M, the only market streamed before versioning; callers reading
+// other markets override it. Version and ReceivedAt are left for the caller.
+func DecodeBinanceKline(raw []byte) (KlineEvent, error) {
+	var msg binanceKline
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return KlineEvent{}, err
+	}
+	if msg.Data.Symbol == "" {
+		return KlineEvent{}, fmt.Errorf("not a kline message")
+	}
+	k := msg.Data.K
+	return KlineEvent{
+		Exchange:       "binance",
+		Market:         MarketUSDM,
+		Symbol:         msg.Data.Symbol,
+		Interval:       k.Interval,
+		OpenTime:       time.UnixMilli(k.OpenTime),
+		CloseTime:      time.UnixMilli(k.CloseTime),
+		Open:           number(k.Open),
+		High:           number(k.High),
+		Low:            number(k.Low),
+		Close:          number(k.Close),
+		Volume:         number(k.Volume),
+		QuoteVolume:    number(k.QuoteVolume),
+		TakerBuyVolume: number(k.TakerBuyVolume),
+		Trades:         k.Trades,
+		Closed:         k.IsClosed,
+	}, nil
+}
+
+// number converts an exchange's decimal string, reading junk as 0.
+func number(n json.Number) float64 {
+	f, _ := strconv.ParseFloat(string(n), 64)
+	return f
+}
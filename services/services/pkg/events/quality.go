@@ -0,0 +1,49 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// QualityVersion is the schema version of QualityEvent written by this package.
+const QualityVersion = 1
+
+// Data quality event types.
+const (
+	// QualityGap reports candles missing from a window that could not all
+	// be backfilled from the exchange's REST API.
+	QualityGap = "gap"
+)
+
+// QualityEvent is published to data.quality, keyed by symbol, when the
+// klines a window is built from are known to be incomplete.
+type QualityEvent struct {
+	Version  int       `json:"version"`
+	Type     string    `json:"type"`
+	Exchange string    `json:"exchange"`
+	Market   string    `json:"market"`
+	Symbol   string    `json:"symbol"`
+	Interval string    `json:"interval"`
+	From     time.Time `json:"from"` // open time of the first missing candle
+	To       time.Time `json:"to"`   // open time of the candle after the gap
+	Missing  int       `json:"missing"`
+	Filled   int       `json:"filled"` // missing candles recovered from the REST API
+	Error    string    `json:"error,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// DecodeQualityEvent decodes a data.quality message.
+func DecodeQualityEvent(raw []byte) (QualityEvent, error) {
+	var evt QualityEvent
+	if err := json.Unmarshal(raw, &evt); err != nil {
+		return evt, err
+	}
+	if evt.Version > QualityVersion {
+		return evt, fmt.Errorf("%w: %d", ErrUnsupportedVersion, evt.Version)
+	}
+	if evt.Type != QualityGap {
+		return evt, fmt.Errorf("unknown data quality event type: %q", evt.Type)
+	}
+	return evt, nil
+}
@@ -0,0 +1,101 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
+
+// ScreenerVersion is the schema version of ScreenerSnapshot written by this package.
+const ScreenerVersion = 1
+
+// Screener sort orders.
+const (
+	ScreenerDesc = "DESC" // highest value first
+	ScreenerAsc  = "ASC"  // lowest value first
+)
+
+// ScreenerEntry is a symbol ranked by a screener.
+type ScreenerEntry struct {
+	Rank   int     `json:"rank"` // 1-based
+	Symbol string  `json:"symbol"`
+	Value  float64 `json:"value"` // the screener's rank expression
+	Price  float64 `json:"price"` // close of the candle
+}
+
+// ScreenerSnapshot is published to screener.snapshot, keyed by job ID, when
+// the candles of a screener job close. Each calc-service instance ranks the
+// symbols of the kline.raw partitions it owns: Partitions lists them, and
+// JobPartitions every partition the job's symbols land on. The snapshots of
+// one OpenTime whose Partitions cover JobPartitions together rank all of
+// the job's symbols.
+type ScreenerSnapshot struct {
+	Version       int             `json:"version"`
+	JobID         string          `json:"jobId"`
+	Owner         string          `json:"owner,omitempty"`
+	Exchange      string          `json:"exchange"`
+	Market        string          `json:"market"`
+	Interval      string          `json:"interval"`
+	Rank          string          `json:"rank"` // name of the rank expression, e.g. "RSI"
+	Order         string          `json:"order"`
+	Limit         int             `json:"limit"`
+	OpenTime      time.Time       `json:"openTime"`
+	CloseTime     time.Time       `json:"closeTime"`
+	Partitions    []int           `json:"partitions"`
+	JobPartitions []int           `json:"jobPartitions"`
+	Evaluated     int             `json:"evaluated"` // symbols whose candle was seen
+	Matched       int             `json:"matched"`   // symbols that passed the job's conditions and were ranked
+	Entries       []ScreenerEntry `json:"entries"`
+	Time          time.Time       `json:"time"`
+}
+
+// Complete reports whether the snapshot covers every partition the job's
+// symbols land on.
+func (s ScreenerSnapshot) Complete() bool {
+	for _, p := range s.JobPartitions {
+		if !slices.Contains(s.Partitions, p) {
+			return false
+		}
+	}
+	return len(s.JobPartitions) > 0
+}
+
+// RankScreenerEntries sorts entries by value in the given order, ties by
+// symbol, keeps the first limit and numbers them.
+func RankScreenerEntries(entries []ScreenerEntry, order string, limit int) []ScreenerEntry {
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Value != b.Value {
+			if order == ScreenerAsc {
+				return a.Value < b.Value
+			}
+			return a.Value > b.Value
+		}
+		return a.Symbol < b.Symbol
+	})
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	for i := range entries {
+		entries[i].Rank = i + 1
+	}
+	return entries
+}
+
+// DecodeScreenerSnapshot decodes a screener.snapshot message.
+func DecodeScreenerSnapshot(raw []byte) (ScreenerSnapshot, error) {
+	var s ScreenerSnapshot
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return s, err
+	}
+	if s.Version > ScreenerVersion {
+		return s, fmt.Errorf("%w: %d", ErrUnsupportedVersion, s.Version)
+	}
+	if s.JobID == "" {
+		return s, errors.New("screener snapshot without job ID")
+	}
+	return s, nil
+}
@@ -0,0 +1,44 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// SymbolVersion is the schema version of SymbolEvent written by this package.
+const SymbolVersion = 1
+
+// Symbol event types.
+const (
+	SymbolListed   = "listed"
+	SymbolDelisted = "delisted"
+)
+
+// SymbolEvent is published to symbol.events when a symbol joins or leaves
+// the set of tradable symbols kline-fetcher streams.
+type SymbolEvent struct {
+	Version  int       `json:"version"`
+	Type     string    `json:"type"`
+	Symbol   string    `json:"symbol"`
+	Exchange string    `json:"exchange"`
+	Market   string    `json:"market,omitempty"` // empty on events from before market types: USDⓈ-M
+	Time     time.Time `json:"time"`
+}
+
+// DecodeSymbolEvent decodes a symbol.events message.
+func DecodeSymbolEvent(raw []byte) (SymbolEvent, error) {
+	var evt SymbolEvent
+	if err := json.Unmarshal(raw, &evt); err != nil {
+		return evt, err
+	}
+	if evt.Version > SymbolVersion {
+		return evt, fmt.Errorf("%w: %d", ErrUnsupportedVersion, evt.Version)
+	}
+	switch evt.Type {
+	case SymbolListed, SymbolDelisted:
+	default:
+		return evt, fmt.Errorf("unknown symbol event type: %q", evt.Type)
+	}
+	return evt, nil
+}
@@ -0,0 +1,219 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/events"
+)
+
+var binanceIntervals = []string{"1m", "3m", "5m", "15m", "30m", "1h", "2h", "4h", "6h", "8h", "12h", "1d", "3d", "1w", "1M"}
+
+// binanceAPI holds the endpoints of one Binance market.
+type binanceAPI struct {
+	rest         string
+	exchangeInfo string
+	klines       string
+	ws           string
+	maxKlines    int
+}
+
+var binanceAPIs = map[string]binanceAPI{
+	events.MarketSpot: {
+		rest:         "https://api.binance.com",
+		exchangeInfo: "/api/v3/exchangeInfo",
+		klines:       "/api/v3/klines",
+		ws:           "wss://stream.binance.com:9443/stream",
+		maxKlines:    1000,
+	},
+	events.MarketUSDM: {
+		rest:         "https://fapi.binance.com",
+		exchangeInfo: "/fapi/v1/exchangeInfo",
+		klines:       "/fapi/v1/klines",
+		ws:           "wss://fstream.binance.com/stream",
+		maxKlines:    1500,
+	},
+	events.MarketCoinM: {
+		rest:         "https://dapi.binance.com",
+		exchangeInfo: "/dapi/v1/exchangeInfo",
+		klines:       "/dapi/v1/klines",
+		ws:           "wss://dstream.binance.com/stream",
+		maxKlines:    1500,
+	},
+}
+
+// binance is the adapter of a Binance market.
+type binance struct {
+	market string
+	api    binanceAPI
+}
+
+// NewBinance returns the adapter of a Binance market.
+func NewBinance(market string) (Adapter, error) {
+	api, ok := binanceAPIs[market]
+	if !ok {
+		return nil, unknownMarket("binance", market)
+	}
+	return binance{market: market, api: api}, nil
+}
+
+func (binance) Name() string           { return "binance" }
+func (b binance) Market() string       { return b.market }
+func (binance) Intervals() []string    { return binanceIntervals }
+func (b binance) MaxKlines() int       { return b.api.maxKlines }
+func (b binance) WebsocketURL() string { return b.api.ws }
+
+func (binance) Limits() Limits {
+	return Limits{
+		MaxStreamsPerConn: 200,
+		StreamsPerRequest: 50,
+		RequestInterval:   250 * time.Millisecond, // 5 messages per second on spot, 10 on futures
+		PingInterval:      time.Minute,
+		MaxConnAge:        23 * time.Hour, // connections are dropped after 24h
+	}
+}
+
+func (b binance) Symbols(ctx context.Context) ([]string, error) {
+	var info struct {
+		Symbols []struct {
+			Symbol         string `json:"symbol"`
+			QuoteAsset     string `json:"quoteAsset"`
+			ContractType   string `json:"contractType"`
+			Status         string `json:"status"`
+			ContractStatus string `json:"contractStatus"` // COIN-M
+		} `json:"symbols"`
+	}
+	if err := getJSON(ctx, b.api.rest+b.api.exchangeInfo, &info); err != nil {
+		return nil, fmt.Errorf("binance %s exchangeInfo: %w", b.market, err)
+	}
+	var symbols []string
+	for _, s := range info.Symbols {
+		var ok bool
+		switch b.market {
+		case events.MarketSpot:
+			ok = s.QuoteAsset == "USDT" && s.Status == "TRADING"
+		case events.MarketUSDM:
+			ok = s.QuoteAsset == "USDT" && s.ContractType == "PERPETUAL" && s.Status == "TRADING"
+		case events.MarketCoinM:
+			ok = s.ContractType == "PERPETUAL" && s.ContractStatus == "TRADING"
+		}
+		if ok {
+			symbols = append(symbols, s.Symbol)
+		}
+	}
+	return symbols, nil
+}
+
+func (b binance) Klines(ctx context.Context, symbol, interval string, before time.Time, limit int) ([]events.KlineEvent, error) {
+	if !supports(b, interval) {
+		return nil, fmt.Errorf("binance: %w: %q", ErrUnsupportedInterval, interval)
+	}
+	q := url.Values{
+		"symbol":   {symbol},
+		"interval": {interval},
+		"limit":    {strconv.Itoa(min(limit, b.MaxKlines()))},
+	}
+	if !before.IsZero() {
+		q.Set("endTime", strconv.FormatInt(before.UnixMilli()-1, 10))
+	}
+	// [openTime, open, high, low, close, volume, closeTime, quoteVolume,
+	//  trades, takerBuyVolume, takerBuyQuoteVolume, ignore]; COIN-M
+	// reports volume in contracts and the base asset volume in place of
+	// the quote volume
+	var rows [][]json.RawMessage
+	if err := getJSON(ctx, b.api.rest+b.api.klines+"?"+q.Encode(), &rows); err != nil {
+		return nil, fmt.Errorf("binance %s klines %s:%s: %w", b.market, symbol, interval, err)
+	}
+	now := time.Now()
+	out := make([]events.KlineEvent, 0, len(rows))
+	for _, r := range rows {
+		if len(r) < 10 {
+			return nil, fmt.Errorf("binance %s klines %s:%s: short row", b.market, symbol, interval)
+		}
+		trades, _ := strconv.ParseInt(str(r[8]), 10, 64)
+		out = append(out, finish(events.KlineEvent{
+			OpenTime:       millis(str(r[0])),
+			Open:           number(str(r[1])),
+			High:           number(str(r[2])),
+			Low:            number(str(r[3])),
+			Close:          number(str(r[4])),
+			Volume:         number(str(r[5])),
+			CloseTime:      millis(str(r[6])),
+			QuoteVolume:    number(str(r[7])),
+			Trades:         trades,
+			TakerBuyVolume: number(str(r[9])),
+		}, b, symbol, interval, now))
+	}
+	return out, nil
+}
+
+func (b binance) Stream(symbol, interval string) (string, error) {
+	if !supports(b, interval) {
+		return "", fmt.Errorf("binance: %w: %q", ErrUnsupportedInterval, interval)
+	}
+	return fmt.Sprintf("%s@kline_%s", strings.ToLower(symbol), interval), nil
+}
+
+func (binance) ControlMessage(id int64, subscribe bool, streams []string) ([]byte, error) {
+	method := "UNSUBSCRIBE"
+	if subscribe {
+		method = "SUBSCRIBE"
+	}
+	return json.Marshal(map[string]interface{}{
+		"method": method,
+		"params": streams,
+		"id":     id,
+	})
+}
+
+// Heartbeat is nil: Binance pings the client and answers ping frames.
+func (binance) Heartbeat() []byte { return nil }
+
+func (b binance) ParseMessage(msg []byte) ([]events.KlineEvent, error) {
+	var reply struct {
+		ID    *int64 `json:"id"`
+		Error *struct {
+			Code int    `json:"code"`
+			Msg  string `json:"msg"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(msg, &reply); err != nil {
+		return nil, err
+	}
+	if reply.ID != nil {
+		if reply.Error != nil {
+			return nil, fmt.Errorf("%w: request %d: %d %s", ErrRequestFailed, *reply.ID, reply.Error.Code, reply.Error.Msg)
+		}
+		return nil, nil
+	}
+	k, err := events.DecodeBinanceKline(msg)
+	if err != nil {
+		return nil, err
+	}
+	k.Market = b.market
+	return []events.KlineEvent{k}, nil
+}
+
+// supports reports whether the adapter offers the interval.
+func supports(a Adapter, interval string) bool {
+	for _, iv := range a.Intervals() {
+		if iv == interval {
+			return true
+		}
+	}
+	return false
+}
+
+// str returns a JSON string's contents, or the raw text of other values.
+func str(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) != nil {
+		return string(raw)
+	}
+	return s
+}
@@ -0,0 +1,182 @@
+package exchange
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/events"
+)
+
+func TestBinanceParseMessage(t *testing.T) {
+	tests := []struct {
+		name, market, fixture string
+		want                  []events.KlineEvent
+		wantErr               error
+	}{
+		{
+			name: "usdm open kline", market: events.MarketUSDM, fixture: "binance/ws_kline_usdm.json",
+			want: []events.KlineEvent{{
+				Exchange: "binance", Market: events.MarketUSDM, Symbol: "BTCUSDT", Interval: "1m",
+				OpenTime: ms(1715000040000), CloseTime: ms(1715000099999),
+				Open: 63000.10, High: 63020, Low: 62990, Close: 63010.50,
+				Volume: 12.345, QuoteVolume: 777777.70, TakerBuyVolume: 6.1, Trades: 321,
+			}},
+		},
+		{
+			name: "spot closed kline", market: events.MarketSpot, fixture: "binance/ws_kline_spot.json",
+			want: []events.KlineEvent{{
+				Exchange: "binance", Market: events.MarketSpot, Symbol: "ETHUSDT", Interval: "1h",
+				OpenTime: ms(1715000400000), CloseTime: ms(1715003999999),
+				Open: 3120.55, High: 3150, Low: 3110.01, Close: 3141.20,
+				Volume: 10234.5678, QuoteVolume: 32000000.12, TakerBuyVolume: 5100.1, Trades: 51234,
+				Closed: true,
+			}},
+		},
+		{
+			name: "coinm kline", market: events.MarketCoinM, fixture: "binance/ws_kline_coinm.json",
+			want: []events.KlineEvent{{
+				Exchange: "binance", Market: events.MarketCoinM, Symbol: "BTCUSD_PERP", Interval: "5m",
+				OpenTime: ms(1715000100000), CloseTime: ms(1715000399999),
+				Open: 63005.1, High: 63011.2, Low: 62990, Close: 62998,
+				Volume: 4520, QuoteVolume: 7.17421389, TakerBuyVolume: 2100, Trades: 150,
+			}},
+		},
+		{name: "subscribe ack", market: events.MarketUSDM, fixture: "binance/ws_subscribe_ack.json"},
+		{name: "subscribe error", market: events.MarketUSDM, fixture: "binance/ws_subscribe_error.json", wantErr: ErrRequestFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := adapter(t, "binance", tt.market).ParseMessage(fixture(t, tt.fixture))
+			if checkErr(t, err, tt.wantErr) {
+				checkKlines(t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBinanceSymbols(t *testing.T) {
+	tests := []struct {
+		market, path, fixture string
+		want                  []string
+	}{
+		{events.MarketSpot, "api.binance.com/api/v3/exchangeInfo", "binance/exchangeinfo_spot.json", []string{"BTCUSDT", "ETHUSDT"}},
+		{events.MarketUSDM, "fapi.binance.com/fapi/v1/exchangeInfo", "binance/exchangeinfo_usdm.json", []string{"BTCUSDT", "ETHUSDT"}},
+		{events.MarketCoinM, "dapi.binance.com/dapi/v1/exchangeInfo", "binance/exchangeinfo_coinm.json", []string{"BTCUSD_PERP", "ETHUSD_PERP"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.market, func(t *testing.T) {
+			serveFixtures(t, func(u *url.URL) string {
+				if u.Host+u.Path == tt.path {
+					return tt.fixture
+				}
+				return ""
+			})
+			got, err := adapter(t, "binance", tt.market).Symbols(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Symbols() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBinanceKlines(t *testing.T) {
+	before := ms(1715007600000)
+	tests := []struct {
+		market, symbol, path, fixture string
+		first                         events.KlineEvent
+		wantOpen                      []int64
+	}{
+		{
+			market: events.MarketSpot, symbol: "ETHUSDT", path: "api.binance.com/api/v3/klines", fixture: "binance/klines_spot.json",
+			first: events.KlineEvent{
+				Exchange: "binance", Market: events.MarketSpot, Symbol: "ETHUSDT", Interval: "1h",
+				OpenTime: ms(1714996800000), CloseTime: ms(1715000399999),
+				Open: 3105.12, High: 3125, Low: 3101, Close: 3120.55,
+				Volume: 9876.5432, QuoteVolume: 30750000.10, TakerBuyVolume: 4900, Trades: 40123, Closed: true,
+			},
+			wantOpen: []int64{1714996800000, 1715000400000},
+		},
+		{
+			market: events.MarketUSDM, symbol: "BTCUSDT", path: "fapi.binance.com/fapi/v1/klines", fixture: "binance/klines_usdm.json",
+			first: events.KlineEvent{
+				Exchange: "binance", Market: events.MarketUSDM, Symbol: "BTCUSDT", Interval: "1h",
+				OpenTime: ms(1714996800000), CloseTime: ms(1715000399999),
+				Open: 62850, High: 63120.50, Low: 62700.10, Close: 63000.10,
+				Volume: 1523.412, QuoteVolume: 95800123.45, TakerBuyVolume: 801.2, Trades: 48213, Closed: true,
+			},
+			wantOpen: []int64{1714996800000, 1715000400000, 1715004000000},
+		},
+		{
+			market: events.MarketCoinM, symbol: "BTCUSD_PERP", path: "dapi.binance.com/dapi/v1/klines", fixture: "binance/klines_coinm.json",
+			first: events.KlineEvent{
+				Exchange: "binance", Market: events.MarketCoinM, Symbol: "BTCUSD_PERP", Interval: "1h",
+				OpenTime: ms(1714996800000), CloseTime: ms(1715000399999),
+				Open: 62851.1, High: 63118, Low: 62702.3, Close: 63001.4,
+				Volume: 152340, QuoteVolume: 242.11245870, TakerBuyVolume: 80010, Trades: 9120, Closed: true,
+			},
+			wantOpen: []int64{1714996800000, 1715000400000},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.market, func(t *testing.T) {
+			requests := serveFixtures(t, func(u *url.URL) string {
+				if u.Host+u.Path == tt.path {
+					return tt.fixture
+				}
+				return ""
+			})
+			got, err := adapter(t, "binance", tt.market).Klines(context.Background(), tt.symbol, "1h", before, 5000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkOpenTimes(t, got, tt.wantOpen)
+			checkKlines(t, got[:1], []events.KlineEvent{tt.first})
+
+			q := (*requests)[0].Query()
+			want := url.Values{
+				"symbol":   {tt.symbol},
+				"interval": {"1h"},
+				"limit":    {"1000"},
+				"endTime":  {"1715007599999"}, // klines opening strictly before before
+			}
+			if tt.market != events.MarketSpot {
+				want.Set("limit", "1500")
+			}
+			if !reflect.DeepEqual(q, want) {
+				t.Errorf("query = %v, want %v", q, want)
+			}
+		})
+	}
+}
+
+func TestBinanceKlinesErrors(t *testing.T) {
+	serveFixtures(t, func(u *url.URL) string { return "" })
+	a := adapter(t, "binance", events.MarketUSDM)
+	if _, err := a.Klines(context.Background(), "BTCUSDT", "2d", time.Time{}, 10); !checkErrIs(err, ErrUnsupportedInterval) {
+		t.Errorf("unsupported interval: error = %v", err)
+	}
+	if _, err := a.Klines(context.Background(), "NOPEUSDT", "1h", time.Time{}, 10); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("rejected request: error = %v", err)
+	}
+}
+
+func TestBinanceStream(t *testing.T) {
+	a := adapter(t, "binance", events.MarketUSDM)
+	if got, err := a.Stream("BTCUSDT", "15m"); err != nil || got != "btcusdt@kline_15m" {
+		t.Errorf("Stream() = %q, %v", got, err)
+	}
+	msg, err := a.ControlMessage(7, true, []string{"btcusdt@kline_1m", "ethusdt@kline_1m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"id":7,"method":"SUBSCRIBE","params":["btcusdt@kline_1m","ethusdt@kline_1m"]}`; string(msg) != want {
+		t.Errorf("ControlMessage() = %s, want %s", msg, want)
+	}
+}
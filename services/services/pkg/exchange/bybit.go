@@ -0,0 +1,260 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/events"
+)
+
+const bybitREST = "https://api.bybit.com"
+
+// bybitCategories maps market types to Bybit's product categories.
+var bybitCategories = map[string]string{
+	events.MarketSpot:  "spot",
+	events.MarketUSDM:  "linear",
+	events.MarketCoinM: "inverse",
+}
+
+// bybitIntervals maps interval names to Bybit's.
+var bybitIntervals = map[string]string{
+	"1m": "1", "3m": "3", "5m": "5", "15m": "15", "30m": "30",
+	"1h": "60", "2h": "120", "4h": "240", "6h": "360", "12h": "720",
+	"1d": "D", "1w": "W", "1M": "M",
+}
+
+// bybit is the adapter of a Bybit market.
+type bybit struct {
+	market   string
+	category string
+}
+
+// NewBybit returns the adapter of a Bybit market.
+func NewBybit(market string) (Adapter, error) {
+	category, ok := bybitCategories[market]
+	if !ok {
+		return nil, unknownMarket("bybit", market)
+	}
+	return bybit{market: market, category: category}, nil
+}
+
+func (bybit) Name() string           { return "bybit" }
+func (b bybit) Market() string       { return b.market }
+func (bybit) Intervals() []string    { return keys(bybitIntervals) }
+func (bybit) MaxKlines() int         { return 1000 }
+func (b bybit) WebsocketURL() string { return "wss://stream.bybit.com/v5/public/" + b.category }
+
+func (bybit) Limits() Limits {
+	return Limits{
+		MaxStreamsPerConn: 200,
+		StreamsPerRequest: 10, // the spot limit
+		RequestInterval:   100 * time.Millisecond,
+		PingInterval:      20 * time.Second, // idle connections are dropped after 30s
+	}
+}
+
+// symbolOf converts a Bybit symbol to the Binance form; inverse
+// perpetuals are BTCUSD on Bybit and BTCUSD_PERP on Binance.
+func (b bybit) symbolOf(symbol string) string {
+	if b.market == events.MarketCoinM {
+		return symbol + "_PERP"
+	}
+	return symbol
+}
+
+// apiSymbol converts a symbol in the Binance form to Bybit's.
+func (b bybit) apiSymbol(symbol string) string {
+	return strings.TrimSuffix(symbol, "_PERP")
+}
+
+func (b bybit) Symbols(ctx context.Context) ([]string, error) {
+	var symbols []string
+	cursor := ""
+	for {
+		q := url.Values{"category": {b.category}, "limit": {"1000"}}
+		if cursor != "" {
+			q.Set("cursor", cursor)
+		}
+		var resp struct {
+			bybitStatus
+			Result struct {
+				List []struct {
+					Symbol       string `json:"symbol"`
+					ContractType string `json:"contractType"`
+					Status       string `json:"status"`
+					QuoteCoin    string `json:"quoteCoin"`
+				} `json:"list"`
+				NextPageCursor string `json:"nextPageCursor"`
+			} `json:"result"`
+		}
+		if err := getJSON(ctx, bybitREST+"/v5/market/instruments-info?"+q.Encode(), &resp); err != nil {
+			return nil, fmt.Errorf("bybit %s instruments: %w", b.market, err)
+		}
+		if err := resp.err(); err != nil {
+			return nil, fmt.Errorf("bybit %s instruments: %w", b.market, err)
+		}
+		for _, s := range resp.Result.List {
+			var ok bool
+			switch b.market {
+			case events.MarketSpot:
+				ok = s.QuoteCoin == "USDT"
+			case events.MarketUSDM:
+				ok = s.QuoteCoin == "USDT" && s.ContractType == "LinearPerpetual"
+			case events.MarketCoinM:
+				ok = s.ContractType == "InversePerpetual"
+			}
+			if ok && s.Status == "Trading" {
+				symbols = append(symbols, b.symbolOf(s.Symbol))
+			}
+		}
+		if cursor = resp.Result.NextPageCursor; cursor == "" {
+			return symbols, nil
+		}
+	}
+}
+
+func (b bybit) Klines(ctx context.Context, symbol, interval string, before time.Time, limit int) ([]events.KlineEvent, error) {
+	iv, ok := bybitIntervals[interval]
+	if !ok {
+		return nil, fmt.Errorf("bybit: %w: %q", ErrUnsupportedInterval, interval)
+	}
+	q := url.Values{
+		"category": {b.category},
+		"symbol":   {b.apiSymbol(symbol)},
+		"interval": {iv},
+		"limit":    {strconv.Itoa(min(limit, b.MaxKlines()))},
+	}
+	if !before.IsZero() {
+		q.Set("end", strconv.FormatInt(before.UnixMilli()-1, 10))
+	}
+	var resp struct {
+		bybitStatus
+		Result struct {
+			// [startTime, open, high, low, close, volume, turnover], newest first
+			List [][]string `json:"list"`
+		} `json:"result"`
+	}
+	if err := getJSON(ctx, bybitREST+"/v5/market/kline?"+q.Encode(), &resp); err != nil {
+		return nil, fmt.Errorf("bybit klines %s:%s: %w", symbol, interval, err)
+	}
+	if err := resp.err(); err != nil {
+		return nil, fmt.Errorf("bybit klines %s:%s: %w", symbol, interval, err)
+	}
+	now := time.Now()
+	out := make([]events.KlineEvent, 0, len(resp.Result.List))
+	for _, r := range resp.Result.List {
+		if len(r) < 7 {
+			return nil, fmt.Errorf("bybit klines %s:%s: short row", symbol, interval)
+		}
+		out = append(out, finish(events.KlineEvent{
+			OpenTime:    millis(r[0]),
+			Open:        number(r[1]),
+			High:        number(r[2]),
+			Low:         number(r[3]),
+			Close:       number(r[4]),
+			Volume:      number(r[5]),
+			QuoteVolume: number(r[6]),
+		}, b, symbol, interval, now))
+	}
+	return reversed(out), nil
+}
+
+func (b bybit) Stream(symbol, interval string) (string, error) {
+	iv, ok := bybitIntervals[interval]
+	if !ok {
+		return "", fmt.Errorf("bybit: %w: %q", ErrUnsupportedInterval, interval)
+	}
+	return "kline." + iv + "." + b.apiSymbol(symbol), nil
+}
+
+func (bybit) ControlMessage(id int64, subscribe bool, streams []string) ([]byte, error) {
+	op := "unsubscribe"
+	if subscribe {
+		op = "subscribe"
+	}
+	return json.Marshal(map[string]interface{}{
+		"req_id": strconv.FormatInt(id, 10),
+		"op":     op,
+		"args":   streams,
+	})
+}
+
+// Heartbeat keeps the connection alive; Bybit ignores ping frames.
+func (bybit) Heartbeat() []byte { return []byte(`{"op":"ping"}`) }
+
+func (b bybit) ParseMessage(msg []byte) ([]events.KlineEvent, error) {
+	var m struct {
+		Op      string `json:"op"`
+		Success *bool  `json:"success"`
+		RetMsg  string `json:"ret_msg"`
+		ReqID   string `json:"req_id"`
+		Topic   string `json:"topic"`
+		Data    []struct {
+			Start    int64  `json:"start"`
+			End      int64  `json:"end"`
+			Open     string `json:"open"`
+			Close    string `json:"close"`
+			High     string `json:"high"`
+			Low      string `json:"low"`
+			Volume   string `json:"volume"`
+			Turnover string `json:"turnover"`
+			Confirm  bool   `json:"confirm"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(msg, &m); err != nil {
+		return nil, err
+	}
+	if m.Op != "" {
+		if m.Success != nil && !*m.Success {
+			return nil, fmt.Errorf("%w: %s %s: %s", ErrRequestFailed, m.Op, m.ReqID, m.RetMsg)
+		}
+		return nil, nil
+	}
+	// kline.{interval}.{symbol}
+	parts := strings.Split(m.Topic, ".")
+	if len(parts) != 3 || parts[0] != "kline" {
+		return nil, fmt.Errorf("bybit: unexpected topic %q", m.Topic)
+	}
+	interval := ""
+	for name, iv := range bybitIntervals {
+		if iv == parts[1] {
+			interval = name
+		}
+	}
+	if interval == "" {
+		return nil, fmt.Errorf("bybit: %w: %q", ErrUnsupportedInterval, parts[1])
+	}
+	out := make([]events.KlineEvent, 0, len(m.Data))
+	for _, d := range m.Data {
+		out = append(out, finish(events.KlineEvent{
+			OpenTime:    time.UnixMilli(d.Start),
+			CloseTime:   time.UnixMilli(d.End),
+			Open:        number(d.Open),
+			High:        number(d.High),
+			Low:         number(d.Low),
+			Close:       number(d.Close),
+			Volume:      number(d.Volume),
+			QuoteVolume: number(d.Turnover),
+			Closed:      d.Confirm,
+		}, b, b.symbolOf(parts[2]), interval, time.Time{}))
+	}
+	return out, nil
+}
+
+// bybitStatus is the status every Bybit REST response carries.
+type bybitStatus struct {
+	RetCode int    `json:"retCode"`
+	RetMsg  string `json:"retMsg"`
+}
+
+func (s bybitStatus) err() error {
+	if s.RetCode != 0 {
+		return fmt.Errorf("%d %s", s.RetCode, s.RetMsg)
+	}
+	return nil
+}
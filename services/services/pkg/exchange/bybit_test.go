@@ -0,0 +1,203 @@
+package exchange
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/events"
+)
+
+func TestBybitParseMessage(t *testing.T) {
+	tests := []struct {
+		name, market, fixture string
+		want                  []events.KlineEvent
+		wantErr               error
+	}{
+		{
+			name: "linear open kline", market: events.MarketUSDM, fixture: "bybit/ws_kline_linear.json",
+			want: []events.KlineEvent{{
+				Exchange: "bybit", Market: events.MarketUSDM, Symbol: "BTCUSDT", Interval: "5m",
+				OpenTime: ms(1715000100000), CloseTime: ms(1715000399999),
+				Open: 63005.1, High: 63011.2, Low: 62990, Close: 62998,
+				Volume: 45.201, QuoteVolume: 2847800.55,
+			}},
+		},
+		{
+			name: "spot closed daily kline", market: events.MarketSpot, fixture: "bybit/ws_kline_spot.json",
+			want: []events.KlineEvent{{
+				Exchange: "bybit", Market: events.MarketSpot, Symbol: "ETHUSDT", Interval: "1d",
+				OpenTime: ms(1714953600000), CloseTime: ms(1715039999999),
+				Open: 3098.4, High: 3160, Low: 3080.01, Close: 3141.2,
+				Volume: 50123.45, QuoteVolume: 156700000.5, Closed: true,
+			}},
+		},
+		{
+			name: "inverse kline", market: events.MarketCoinM, fixture: "bybit/ws_kline_inverse.json",
+			want: []events.KlineEvent{{
+				Exchange: "bybit", Market: events.MarketCoinM, Symbol: "BTCUSD_PERP", Interval: "1h",
+				OpenTime: ms(1715000400000), CloseTime: ms(1715003999999),
+				Open: 63000.5, High: 63250, Low: 62950, Close: 63199,
+				Volume: 1520300, QuoteVolume: 24.07715123,
+			}},
+		},
+		{name: "subscribe ack", market: events.MarketUSDM, fixture: "bybit/ws_subscribe_ack.json"},
+		{name: "pong", market: events.MarketUSDM, fixture: "bybit/ws_pong.json"},
+		{name: "subscribe error", market: events.MarketUSDM, fixture: "bybit/ws_subscribe_error.json", wantErr: ErrRequestFailed},
+		{name: "other topic", market: events.MarketUSDM, fixture: "bybit/ws_ticker.json", wantErr: errAny},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := adapter(t, "bybit", tt.market).ParseMessage(fixture(t, tt.fixture))
+			if checkErr(t, err, tt.wantErr) {
+				checkKlines(t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBybitSymbols(t *testing.T) {
+	tests := []struct {
+		market   string
+		fixtures map[string]string // category:cursor -> fixture
+		want     []string
+		requests int
+	}{
+		{
+			market:   events.MarketSpot,
+			fixtures: map[string]string{"spot:": "bybit/instruments_spot.json"},
+			want:     []string{"BTCUSDT", "ETHUSDT"},
+			requests: 1,
+		},
+		{
+			market: events.MarketUSDM,
+			fixtures: map[string]string{
+				"linear:":                        "bybit/instruments_linear_1.json",
+				"linear:ETHPERP%2C1673308800000": "bybit/instruments_linear_2.json",
+			},
+			want:     []string{"BTCUSDT", "ETHUSDT"},
+			requests: 2,
+		},
+		{
+			market:   events.MarketCoinM,
+			fixtures: map[string]string{"inverse:": "bybit/instruments_inverse.json"},
+			want:     []string{"BTCUSD_PERP", "ETHUSD_PERP"},
+			requests: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.market, func(t *testing.T) {
+			requests := serveFixtures(t, func(u *url.URL) string {
+				if u.Host+u.Path != "api.bybit.com/v5/market/instruments-info" {
+					return ""
+				}
+				q := u.Query()
+				return tt.fixtures[q.Get("category")+":"+q.Get("cursor")]
+			})
+			got, err := adapter(t, "bybit", tt.market).Symbols(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Symbols() = %v, want %v", got, tt.want)
+			}
+			if len(*requests) != tt.requests {
+				t.Errorf("made %d requests, want %d", len(*requests), tt.requests)
+			}
+		})
+	}
+}
+
+func TestBybitKlines(t *testing.T) {
+	before := ms(1715007600000)
+	tests := []struct {
+		market, symbol, category, apiSymbol, fixture string
+		first                                        events.KlineEvent
+		wantOpen                                     []int64
+	}{
+		{
+			market: events.MarketSpot, symbol: "ETHUSDT", category: "spot", apiSymbol: "ETHUSDT", fixture: "bybit/kline_spot.json",
+			first: events.KlineEvent{
+				Exchange: "bybit", Market: events.MarketSpot, Symbol: "ETHUSDT", Interval: "1h",
+				OpenTime: ms(1714996800000), CloseTime: ms(1715000399999),
+				Open: 3105.12, High: 3125, Low: 3101, Close: 3120.55,
+				Volume: 9876.5432, QuoteVolume: 30750000.1, Closed: true,
+			},
+			wantOpen: []int64{1714996800000, 1715000400000},
+		},
+		{
+			market: events.MarketUSDM, symbol: "BTCUSDT", category: "linear", apiSymbol: "BTCUSDT", fixture: "bybit/kline_linear.json",
+			first: events.KlineEvent{
+				Exchange: "bybit", Market: events.MarketUSDM, Symbol: "BTCUSDT", Interval: "1h",
+				OpenTime: ms(1714996800000), CloseTime: ms(1715000399999),
+				Open: 62850, High: 63120.5, Low: 62700.1, Close: 63000.1,
+				Volume: 1523.412, QuoteVolume: 95800123.45, Closed: true,
+			},
+			wantOpen: []int64{1714996800000, 1715000400000, 1715004000000},
+		},
+		{
+			market: events.MarketCoinM, symbol: "BTCUSD_PERP", category: "inverse", apiSymbol: "BTCUSD", fixture: "bybit/kline_inverse.json",
+			first: events.KlineEvent{
+				Exchange: "bybit", Market: events.MarketCoinM, Symbol: "BTCUSD_PERP", Interval: "1h",
+				OpenTime: ms(1714996800000), CloseTime: ms(1715000399999),
+				Open: 62851.1, High: 63118, Low: 62702.3, Close: 63001.4,
+				Volume: 15234000, QuoteVolume: 242.1124587, Closed: true,
+			},
+			wantOpen: []int64{1714996800000, 1715000400000},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.market, func(t *testing.T) {
+			requests := serveFixtures(t, func(u *url.URL) string {
+				if u.Host+u.Path == "api.bybit.com/v5/market/kline" {
+					return tt.fixture
+				}
+				return ""
+			})
+			got, err := adapter(t, "bybit", tt.market).Klines(context.Background(), tt.symbol, "1h", before, 5000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkOpenTimes(t, got, tt.wantOpen)
+			checkKlines(t, got[:1], []events.KlineEvent{tt.first})
+
+			want := url.Values{
+				"category": {tt.category},
+				"symbol":   {tt.apiSymbol},
+				"interval": {"60"},
+				"limit":    {"1000"},
+				"end":      {"1715007599999"},
+			}
+			if q := (*requests)[0].Query(); !reflect.DeepEqual(q, want) {
+				t.Errorf("query = %v, want %v", q, want)
+			}
+		})
+	}
+}
+
+func TestBybitKlinesErrors(t *testing.T) {
+	serveFixtures(t, func(u *url.URL) string { return "bybit/error_invalid_symbol.json" })
+	a := adapter(t, "bybit", events.MarketUSDM)
+	if _, err := a.Klines(context.Background(), "BTCUSDT", "8h", time.Time{}, 10); !checkErrIs(err, ErrUnsupportedInterval) {
+		t.Errorf("unsupported interval: error = %v", err)
+	}
+	if _, err := a.Klines(context.Background(), "NOPEUSDT", "1h", time.Time{}, 10); err == nil {
+		t.Error("retCode 10001: no error")
+	}
+}
+
+func TestBybitStream(t *testing.T) {
+	a := adapter(t, "bybit", events.MarketCoinM)
+	if got, err := a.Stream("BTCUSD_PERP", "4h"); err != nil || got != "kline.240.BTCUSD" {
+		t.Errorf("Stream() = %q, %v", got, err)
+	}
+	msg, err := a.ControlMessage(3, false, []string{"kline.240.BTCUSD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"args":["kline.240.BTCUSD"],"op":"unsubscribe","req_id":"3"}`; string(msg) != want {
+		t.Errorf("ControlMessage() = %s, want %s", msg, want)
+	}
+}
@@ -0,0 +1,274 @@
+// Package exchange adapts the market data APIs of crypto exchanges to the
+// exchange-neutral events the services exchange over Kafka. An adapter
+// covers one market of an exchange: USDT spot pairs, USDT-margined
+// perpetuals (USDⓈ-M) or coin-margined perpetuals (COIN-M). Symbols use
+// the Binance form (BTCUSDT, BTCUSD_PERP) and intervals the Binance names
+// (1m, 4h, 1d) whatever the exchange calls them.
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/events"
+)
+
+// Default and DefaultMarket are used for jobs and events that don't name
+// an exchange or market; they were the only ones before adapters existed.
+const (
+	Default       = "binance"
+	DefaultMarket = events.MarketUSDM
+)
+
+var (
+	// ErrUnknownExchange is returned for exchange names without an adapter.
+	ErrUnknownExchange = errors.New("unknown exchange")
+	// ErrUnknownMarket is returned for market types an exchange doesn't offer.
+	ErrUnknownMarket = errors.New("unknown market")
+	// ErrUnsupportedInterval is returned for intervals an exchange doesn't offer.
+	ErrUnsupportedInterval = errors.New("unsupported interval")
+	// ErrRequestFailed is returned by ParseMessage when the exchange rejected
+	// a subscribe or unsubscribe request.
+	ErrRequestFailed = errors.New("websocket request failed")
+)
+
+// Adapter is the market data of one market of an exchange: the symbols it
+// trades, its live kline WebSocket and its historical klines.
+type Adapter interface {
+	// Name is the lower-case name jobs and events refer to the exchange by.
+	Name() string
+	// Market is the market type, one of the events.Market* types.
+	Market() string
+	// Intervals lists the kline intervals the exchange offers.
+	Intervals() []string
+
+	// Symbols returns the symbols currently trading: USDT pairs on spot,
+	// perpetuals on the futures markets.
+	Symbols(ctx context.Context) ([]string, error)
+
+	// Klines returns up to limit klines opening before the given time, the
+	// most recent ones, oldest first. A zero before means now. limit is
+	// capped at MaxKlines.
+	Klines(ctx context.Context, symbol, interval string, before time.Time, limit int) ([]events.KlineEvent, error)
+	// MaxKlines is the most klines a single Klines request returns.
+	MaxKlines() int
+
+	// WebsocketURL is the endpoint live klines are streamed from.
+	WebsocketURL() string
+	// Stream returns the name of a symbol's kline stream on the WebSocket.
+	Stream(symbol, interval string) (string, error)
+	// ControlMessage builds the request subscribing to (or unsubscribing
+	// from) streams; id lets replies be matched to requests.
+	ControlMessage(id int64, subscribe bool, streams []string) ([]byte, error)
+	// Heartbeat returns the application-level ping to send every
+	// Limits().PingInterval, or nil if WebSocket ping frames suffice.
+	Heartbeat() []byte
+	// ParseMessage converts a WebSocket message into kline events. Replies
+	// to requests and pongs yield no events; rejected requests yield an
+	// error wrapping ErrRequestFailed. Version and ReceivedAt are left for
+	// the caller.
+	ParseMessage(msg []byte) ([]events.KlineEvent, error)
+	// Limits returns the WebSocket limits the exchange enforces.
+	Limits() Limits
+}
+
+// Limits are the WebSocket limits of an exchange.
+type Limits struct {
+	MaxStreamsPerConn int           // streams one connection may carry
+	StreamsPerRequest int           // streams one subscribe request may carry
+	RequestInterval   time.Duration // pause between requests on a connection
+	PingInterval      time.Duration // keepalive period
+	MaxConnAge        time.Duration // the exchange drops connections older than this
+}
+
+var adapters = map[string]func(market string) (Adapter, error){
+	"binance": NewBinance,
+	"bybit":   NewBybit,
+	"okx":     NewOKX,
+}
+
+// Get returns the adapter of a market of the named exchange; empty names
+// select Default and DefaultMarket.
+func Get(name, market string) (Adapter, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		name = Default
+	}
+	newAdapter, ok := adapters[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownExchange, name)
+	}
+	return newAdapter(NormalizeMarket(market))
+}
+
+// NormalizeMarket lower-cases a market type and maps the empty one to
+// DefaultMarket.
+func NormalizeMarket(market string) string {
+	market = strings.ToLower(strings.TrimSpace(market))
+	if market == "" {
+		return DefaultMarket
+	}
+	return market
+}
+
+// unknownMarket is the error of an adapter constructor for a market the
+// exchange doesn't offer.
+func unknownMarket(name, market string) error {
+	return fmt.Errorf("%s: %w: %q", name, ErrUnknownMarket, market)
+}
+
+// Names lists the exchanges with an adapter.
+func Names() []string {
+	names := make([]string, 0, len(adapters))
+	for name := range adapters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Latest returns the n most recent klines of a symbol, paging through the
+// exchange's history as needed.
+func Latest(ctx context.Context, a Adapter, symbol, interval string, n int) ([]events.KlineEvent, error) {
+	return page(ctx, a, symbol, interval, time.Time{}, time.Time{}, n)
+}
+
+// Range returns every kline of a symbol opening in [from, to).
+func Range(ctx context.Context, a Adapter, symbol, interval string, from, to time.Time) ([]events.KlineEvent, error) {
+	return page(ctx, a, symbol, interval, from, to, 0)
+}
+
+// page walks the history backwards from before until it reaches from or
+// has n klines (zero means no limit).
+func page(ctx context.Context, a Adapter, symbol, interval string, from, before time.Time, n int) ([]events.KlineEvent, error) {
+	var out []events.KlineEvent
+	for n == 0 || len(out) < n {
+		limit := a.MaxKlines()
+		if n > 0 {
+			limit = min(limit, n-len(out))
+		}
+		ks, err := a.Klines(ctx, symbol, interval, before, limit)
+		if err != nil {
+			return nil, err
+		}
+		if len(ks) == 0 {
+			break
+		}
+		first := 0
+		for first < len(ks) && ks[first].OpenTime.Before(from) {
+			first++
+		}
+		out = append(ks[first:], out...)
+		if first > 0 || len(ks) < limit {
+			// Reached from, or the start of the symbol's history
+			break
+		}
+		before = ks[0].OpenTime
+	}
+	return out, nil
+}
+
+// IntervalDuration returns the length of a kline interval such as "1m",
+// "4h" or "1w". Months are counted as 30 days.
+func IntervalDuration(interval string) (time.Duration, error) {
+	if len(interval) < 2 {
+		return 0, fmt.Errorf("invalid interval: %q", interval)
+	}
+	n, err := strconv.Atoi(interval[:len(interval)-1])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid interval: %q", interval)
+	}
+	unit := map[byte]time.Duration{
+		'm': time.Minute,
+		'h': time.Hour,
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+		'M': 30 * 24 * time.Hour,
+	}[interval[len(interval)-1]]
+	if unit == 0 {
+		return 0, fmt.Errorf("invalid interval: %q", interval)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// keys returns the interval names of an interval map, shortest first.
+func keys(intervals map[string]string) []string {
+	names := make([]string, 0, len(intervals))
+	for name := range intervals {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		di, _ := IntervalDuration(names[i])
+		dj, _ := IntervalDuration(names[j])
+		return di < dj
+	})
+	return names
+}
+
+var httpClient = &http.Client{Timeout: 15 * time.Second}
+
+// getJSON decodes the JSON body of a GET request into v.
+func getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s: %s", url, resp.Status, body)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// number converts an exchange's decimal string, reading junk as 0.
+func number(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+// millis converts a millisecond timestamp string.
+func millis(s string) time.Time {
+	ms, _ := strconv.ParseInt(s, 10, 64)
+	return time.UnixMilli(ms)
+}
+
+// reversed returns klines listed newest first in chronological order.
+func reversed(ks []events.KlineEvent) []events.KlineEvent {
+	for i, j := 0, len(ks)-1; i < j; i, j = i+1, j-1 {
+		ks[i], ks[j] = ks[j], ks[i]
+	}
+	return ks
+}
+
+// finish sets the fields every adapter derives the same way.
+func finish(k events.KlineEvent, a Adapter, symbol, interval string, now time.Time) events.KlineEvent {
+	k.Exchange = a.Name()
+	k.Market = a.Market()
+	k.Symbol = symbol
+	k.Interval = interval
+	if k.CloseTime.IsZero() {
+		if d, err := IntervalDuration(interval); err == nil {
+			k.CloseTime = k.OpenTime.Add(d - time.Millisecond)
+		}
+	}
+	if !now.IsZero() {
+		k.Closed = k.CloseTime.Before(now)
+	}
+	return k
+}
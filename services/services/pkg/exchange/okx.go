@@ -0,0 +1,271 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/events"
+)
+
+const (
+	okxREST = "https://www.okx.com"
+	okxWS   = "wss://ws.okx.com:8443/ws/v5/business"
+)
+
+// okxIntervals maps interval names to OKX bars; daily and longer bars use
+// the UTC variants so candles open when Binance's and Bybit's do.
+var okxIntervals = map[string]string{
+	"1m": "1m", "3m": "3m", "5m": "5m", "15m": "15m", "30m": "30m",
+	"1h": "1H", "2h": "2H", "4h": "4H", "6h": "6Hutc", "12h": "12Hutc",
+	"1d": "1Dutc", "1w": "1Wutc", "1M": "1Mutc",
+}
+
+// okx is the adapter of an OKX market.
+type okx struct {
+	market string
+}
+
+// NewOKX returns the adapter of an OKX market.
+func NewOKX(market string) (Adapter, error) {
+	switch market {
+	case events.MarketSpot, events.MarketUSDM, events.MarketCoinM:
+		return okx{market: market}, nil
+	}
+	return nil, unknownMarket("okx", market)
+}
+
+func (okx) Name() string        { return "okx" }
+func (o okx) Market() string    { return o.market }
+func (okx) Intervals() []string { return keys(okxIntervals) }
+func (okx) MaxKlines() int      { return 100 }
+
+func (okx) Limits() Limits {
+	return Limits{
+		MaxStreamsPerConn: 200,
+		StreamsPerRequest: 50,
+		RequestInterval:   350 * time.Millisecond, // 3 requests per second
+		PingInterval:      20 * time.Second,       // idle connections are dropped after 30s
+	}
+}
+
+// instID converts a symbol in the Binance form into the OKX instrument:
+// BTCUSDT is BTC-USDT on spot and BTC-USDT-SWAP on USDⓈ-M, BTCUSD_PERP is
+// BTC-USD-SWAP.
+func (o okx) instID(symbol string) string {
+	switch o.market {
+	case events.MarketSpot:
+		return strings.TrimSuffix(symbol, "USDT") + "-USDT"
+	case events.MarketCoinM:
+		return strings.TrimSuffix(symbol, "USD_PERP") + "-USD-SWAP"
+	}
+	return strings.TrimSuffix(symbol, "USDT") + "-USDT-SWAP"
+}
+
+// symbolOf converts an OKX instrument into the Binance form.
+func (o okx) symbolOf(instID string) string {
+	if o.market == events.MarketCoinM {
+		return strings.TrimSuffix(instID, "-USD-SWAP") + "USD_PERP"
+	}
+	return strings.ReplaceAll(strings.TrimSuffix(instID, "-SWAP"), "-", "")
+}
+
+func (o okx) Symbols(ctx context.Context) ([]string, error) {
+	instType := "SWAP"
+	if o.market == events.MarketSpot {
+		instType = "SPOT"
+	}
+	var resp struct {
+		okxStatus
+		Data []struct {
+			InstID    string `json:"instId"`
+			QuoteCcy  string `json:"quoteCcy"`  // spot
+			SettleCcy string `json:"settleCcy"` // swaps
+			CtType    string `json:"ctType"`
+			State     string `json:"state"`
+		} `json:"data"`
+	}
+	if err := getJSON(ctx, okxREST+"/api/v5/public/instruments?instType="+instType, &resp); err != nil {
+		return nil, fmt.Errorf("okx %s instruments: %w", o.market, err)
+	}
+	if err := resp.err(); err != nil {
+		return nil, fmt.Errorf("okx %s instruments: %w", o.market, err)
+	}
+	var symbols []string
+	for _, s := range resp.Data {
+		var ok bool
+		switch o.market {
+		case events.MarketSpot:
+			ok = s.QuoteCcy == "USDT"
+		case events.MarketUSDM:
+			ok = s.SettleCcy == "USDT" && s.CtType == "linear"
+		case events.MarketCoinM:
+			ok = s.CtType == "inverse" && strings.HasSuffix(s.InstID, "-USD-SWAP")
+		}
+		if ok && s.State == "live" {
+			symbols = append(symbols, o.symbolOf(s.InstID))
+		}
+	}
+	return symbols, nil
+}
+
+func (o okx) Klines(ctx context.Context, symbol, interval string, before time.Time, limit int) ([]events.KlineEvent, error) {
+	bar, ok := okxIntervals[interval]
+	if !ok {
+		return nil, fmt.Errorf("okx: %w: %q", ErrUnsupportedInterval, interval)
+	}
+	q := url.Values{
+		"instId": {o.instID(symbol)},
+		"bar":    {bar},
+		"limit":  {strconv.Itoa(min(limit, o.MaxKlines()))},
+	}
+	if !before.IsZero() {
+		// "after" pages towards older candles
+		q.Set("after", strconv.FormatInt(before.UnixMilli(), 10))
+	}
+	var resp struct {
+		okxStatus
+		// [ts, open, high, low, close, vol, volCcy, volCcyQuote, confirm],
+		// newest first
+		Data [][]string `json:"data"`
+	}
+	if err := getJSON(ctx, okxREST+"/api/v5/market/history-candles?"+q.Encode(), &resp); err != nil {
+		return nil, fmt.Errorf("okx klines %s:%s: %w", symbol, interval, err)
+	}
+	if err := resp.err(); err != nil {
+		return nil, fmt.Errorf("okx klines %s:%s: %w", symbol, interval, err)
+	}
+	now := time.Now()
+	out := make([]events.KlineEvent, 0, len(resp.Data))
+	for _, r := range resp.Data {
+		k, err := o.candle(r)
+		if err != nil {
+			return nil, fmt.Errorf("okx klines %s:%s: %w", symbol, interval, err)
+		}
+		out = append(out, finish(k, o, symbol, interval, now))
+	}
+	return reversed(out), nil
+}
+
+// candle converts a candle row. Spot volumes are in the base currency;
+// swap volumes are in contracts, so the base currency volume (volCcy) is
+// used instead.
+func (o okx) candle(r []string) (events.KlineEvent, error) {
+	if len(r) < 9 {
+		return events.KlineEvent{}, fmt.Errorf("short candle row")
+	}
+	volume := r[6]
+	if o.market == events.MarketSpot {
+		volume = r[5]
+	}
+	return events.KlineEvent{
+		OpenTime:    millis(r[0]),
+		Open:        number(r[1]),
+		High:        number(r[2]),
+		Low:         number(r[3]),
+		Close:       number(r[4]),
+		Volume:      number(volume),
+		QuoteVolume: number(r[7]),
+		Closed:      r[8] == "1",
+	}, nil
+}
+
+func (okx) WebsocketURL() string { return okxWS }
+
+// Stream returns "candle<bar>:<instId>"; ControlMessage splits it back
+// into the channel and instrument OKX subscribes by.
+func (o okx) Stream(symbol, interval string) (string, error) {
+	bar, ok := okxIntervals[interval]
+	if !ok {
+		return "", fmt.Errorf("okx: %w: %q", ErrUnsupportedInterval, interval)
+	}
+	return "candle" + bar + ":" + o.instID(symbol), nil
+}
+
+type okxArg struct {
+	Channel string `json:"channel"`
+	InstID  string `json:"instId"`
+}
+
+func (okx) ControlMessage(id int64, subscribe bool, streams []string) ([]byte, error) {
+	op := "unsubscribe"
+	if subscribe {
+		op = "subscribe"
+	}
+	args := make([]okxArg, len(streams))
+	for i, st := range streams {
+		channel, instID, ok := strings.Cut(st, ":")
+		if !ok {
+			return nil, fmt.Errorf("okx: invalid stream %q", st)
+		}
+		args[i] = okxArg{Channel: channel, InstID: instID}
+	}
+	return json.Marshal(map[string]interface{}{
+		"id":   strconv.FormatInt(id, 10),
+		"op":   op,
+		"args": args,
+	})
+}
+
+// Heartbeat keeps the connection alive; OKX answers with a plain "pong".
+func (okx) Heartbeat() []byte { return []byte("ping") }
+
+func (o okx) ParseMessage(msg []byte) ([]events.KlineEvent, error) {
+	if string(msg) == "pong" {
+		return nil, nil
+	}
+	var m struct {
+		Event string     `json:"event"`
+		Code  string     `json:"code"`
+		Msg   string     `json:"msg"`
+		Arg   okxArg     `json:"arg"`
+		Data  [][]string `json:"data"`
+	}
+	if err := json.Unmarshal(msg, &m); err != nil {
+		return nil, err
+	}
+	switch m.Event {
+	case "":
+	case "error":
+		return nil, fmt.Errorf("%w: %s %s", ErrRequestFailed, m.Code, m.Msg)
+	default:
+		return nil, nil // subscribe/unsubscribe acks, notices
+	}
+	bar := strings.TrimPrefix(m.Arg.Channel, "candle")
+	interval := ""
+	for name, b := range okxIntervals {
+		if b == bar {
+			interval = name
+		}
+	}
+	if interval == "" {
+		return nil, fmt.Errorf("okx: unexpected channel %q", m.Arg.Channel)
+	}
+	symbol := o.symbolOf(m.Arg.InstID)
+	out := make([]events.KlineEvent, 0, len(m.Data))
+	for _, r := range m.Data {
+		k, err := o.candle(r)
+		if err != nil {
+			return nil, fmt.Errorf("okx %s: %w", m.Arg.InstID, err)
+		}
+		out = append(out, finish(k, o, symbol, interval, time.Time{}))
+	}
+	return out, nil
+}
+
+// okxStatus is the status every OKX REST response carries.
+type okxStatus struct {
+	Code string `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+func (s okxStatus) err() error {
+	if s.Code != "0" {
+		return fmt.Errorf("%s %s", s.Code, s.Msg)
+	}
+	return nil
+}
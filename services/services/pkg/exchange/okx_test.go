@@ -0,0 +1,181 @@
+package exchange
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ae144de/sonarbot-service-infra2/services/services/pkg/events"
+)
+
+func TestOKXParseMessage(t *testing.T) {
+	tests := []struct {
+		name, market, fixture string
+		want                  []events.KlineEvent
+		wantErr               error
+	}{
+		{
+			name: "swap open candle", market: events.MarketUSDM, fixture: "okx/ws_candle_swap.json",
+			want: []events.KlineEvent{{
+				Exchange: "okx", Market: events.MarketUSDM, Symbol: "BTCUSDT", Interval: "1h",
+				OpenTime: ms(1715000400000), CloseTime: ms(1715003999999),
+				Open: 63000.1, High: 63100, Low: 62900, Close: 63050.5,
+				Volume: 1234, QuoteVolume: 77800050.5,
+			}},
+		},
+		{
+			name: "spot confirmed daily candle", market: events.MarketSpot, fixture: "okx/ws_candle_spot.json",
+			want: []events.KlineEvent{{
+				Exchange: "okx", Market: events.MarketSpot, Symbol: "ETHUSDT", Interval: "1d",
+				OpenTime: ms(1714953600000), CloseTime: ms(1715039999999),
+				Open: 3098.4, High: 3160, Low: 3080.01, Close: 3141.2,
+				Volume: 50123.45, QuoteVolume: 156700000.5, Closed: true,
+			}},
+		},
+		{
+			name: "inverse swap candle", market: events.MarketCoinM, fixture: "okx/ws_candle_inverse.json",
+			want: []events.KlineEvent{{
+				Exchange: "okx", Market: events.MarketCoinM, Symbol: "BTCUSD_PERP", Interval: "5m",
+				OpenTime: ms(1715000100000), CloseTime: ms(1715000399999),
+				Open: 63005.1, High: 63011.2, Low: 62990, Close: 62998,
+				Volume: 7.17421389, QuoteVolume: 452000,
+			}},
+		},
+		{name: "subscribe ack", market: events.MarketUSDM, fixture: "okx/ws_subscribe_ack.json"},
+		{name: "pong", market: events.MarketUSDM, fixture: "okx/ws_pong.txt"},
+		{name: "error event", market: events.MarketUSDM, fixture: "okx/ws_error.json", wantErr: ErrRequestFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := adapter(t, "okx", tt.market).ParseMessage(fixture(t, tt.fixture))
+			if checkErr(t, err, tt.wantErr) {
+				checkKlines(t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOKXSymbols(t *testing.T) {
+	tests := []struct {
+		market, instType, fixture string
+		want                      []string
+	}{
+		{events.MarketSpot, "SPOT", "okx/instruments_spot.json", []string{"BTCUSDT", "ETHUSDT"}},
+		{events.MarketUSDM, "SWAP", "okx/instruments_swap.json", []string{"BTCUSDT", "ETHUSDT"}},
+		{events.MarketCoinM, "SWAP", "okx/instruments_swap.json", []string{"BTCUSD_PERP", "ETHUSD_PERP"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.market, func(t *testing.T) {
+			serveFixtures(t, func(u *url.URL) string {
+				if u.Host+u.Path == "www.okx.com/api/v5/public/instruments" && u.Query().Get("instType") == tt.instType {
+					return tt.fixture
+				}
+				return ""
+			})
+			got, err := adapter(t, "okx", tt.market).Symbols(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Symbols() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOKXKlines(t *testing.T) {
+	before := ms(1715007600000)
+	tests := []struct {
+		market, symbol, instID, fixture string
+		first                           events.KlineEvent
+		wantOpen                        []int64
+	}{
+		{
+			market: events.MarketSpot, symbol: "ETHUSDT", instID: "ETH-USDT", fixture: "okx/history_candles_spot.json",
+			first: events.KlineEvent{
+				Exchange: "okx", Market: events.MarketSpot, Symbol: "ETHUSDT", Interval: "1h",
+				OpenTime: ms(1714996800000), CloseTime: ms(1715000399999),
+				Open: 3105.12, High: 3125, Low: 3101, Close: 3120.55,
+				Volume: 9876.5432, QuoteVolume: 30750000.1, Closed: true,
+			},
+			wantOpen: []int64{1714996800000, 1715000400000},
+		},
+		{
+			market: events.MarketUSDM, symbol: "BTCUSDT", instID: "BTC-USDT-SWAP", fixture: "okx/history_candles_swap.json",
+			first: events.KlineEvent{
+				Exchange: "okx", Market: events.MarketUSDM, Symbol: "BTCUSDT", Interval: "1h",
+				OpenTime: ms(1714996800000), CloseTime: ms(1715000399999),
+				Open: 62850, High: 63120.5, Low: 62700.1, Close: 63000.1,
+				Volume: 1523.412, QuoteVolume: 95800123.45, Closed: true,
+			},
+			wantOpen: []int64{1714996800000, 1715000400000, 1715004000000},
+		},
+		{
+			market: events.MarketCoinM, symbol: "BTCUSD_PERP", instID: "BTC-USD-SWAP", fixture: "okx/history_candles_inverse.json",
+			first: events.KlineEvent{
+				Exchange: "okx", Market: events.MarketCoinM, Symbol: "BTCUSD_PERP", Interval: "1h",
+				OpenTime: ms(1714996800000), CloseTime: ms(1715000399999),
+				Open: 62851.1, High: 63118, Low: 62702.3, Close: 63001.4,
+				Volume: 242.1124587, QuoteVolume: 15234000, Closed: true,
+			},
+			wantOpen: []int64{1714996800000, 1715000400000},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.market, func(t *testing.T) {
+			requests := serveFixtures(t, func(u *url.URL) string {
+				if u.Host+u.Path == "www.okx.com/api/v5/market/history-candles" {
+					return tt.fixture
+				}
+				return ""
+			})
+			got, err := adapter(t, "okx", tt.market).Klines(context.Background(), tt.symbol, "1h", before, 5000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkOpenTimes(t, got, tt.wantOpen)
+			checkKlines(t, got[:1], []events.KlineEvent{tt.first})
+
+			want := url.Values{
+				"instId": {tt.instID},
+				"bar":    {"1H"},
+				"limit":  {"100"},
+				"after":  {"1715007600000"}, // OKX's after is exclusive
+			}
+			if q := (*requests)[0].Query(); !reflect.DeepEqual(q, want) {
+				t.Errorf("query = %v, want %v", q, want)
+			}
+		})
+	}
+}
+
+func TestOKXKlinesErrors(t *testing.T) {
+	serveFixtures(t, func(u *url.URL) string { return "okx/error_instrument.json" })
+	a := adapter(t, "okx", events.MarketUSDM)
+	if _, err := a.Klines(context.Background(), "BTCUSDT", "8h", time.Time{}, 10); !checkErrIs(err, ErrUnsupportedInterval) {
+		t.Errorf("unsupported interval: error = %v", err)
+	}
+	if _, err := a.Klines(context.Background(), "NOPEUSDT", "1h", time.Time{}, 10); err == nil {
+		t.Error("code 51001: no error")
+	}
+}
+
+func TestOKXStream(t *testing.T) {
+	a := adapter(t, "okx", events.MarketSpot)
+	stream, err := a.Stream("BTCUSDT", "1d")
+	if err != nil || stream != "candle1Dutc:BTC-USDT" {
+		t.Errorf("Stream() = %q, %v", stream, err)
+	}
+	msg, err := a.ControlMessage(9, true, []string{stream})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"args":[{"channel":"candle1Dutc","instId":"BTC-USDT"}],"id":"9","op":"subscribe"}`; string(msg) != want {
+		t.Errorf("ControlMessage() = %s, want %s", msg, want)
+	}
+	if _, err := a.ControlMessage(10, true, []string{"candle1m"}); err == nil {
+		t.Error("stream without instrument: no error")
+	}
+}